@@ -1,33 +1,88 @@
 package agent
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/slack-go/slack/slackevents"
 	"github.com/vtuson/slackagent/gpt"
+	"github.com/vtuson/slackagent/guard"
 	"github.com/vtuson/slackagent/mail"
+	"github.com/vtuson/slackagent/prompts"
 	"github.com/vtuson/slackagent/slack"
 
 	"gopkg.in/yaml.v2"
 )
 
+const (
+	// StreamErrorMessage replaces the placeholder when a streamed reply fails before any text arrived
+	StreamErrorMessage = "Sorry, I had an issue answering that."
+	// OverloadedMessage is shown when the model is rate limited or overloaded
+	OverloadedMessage = "The model is overloaded right now, please try again in a few minutes."
+	// ContextLengthMessage is shown when the conversation does not fit in the model context
+	ContextLengthMessage = "This thread is too long for me to read, please start a new one."
+	// ContentFilterMessage is shown when the content filter blocked the prompt or the reply
+	ContentFilterMessage = "I can't answer that, the request was blocked by the content filter."
+	// AuthErrorMessage is shown when the LLM credentials are rejected
+	AuthErrorMessage = "I can't reach the model, please check the API key configuration."
+	// BusyMessage is shown when too many events are waiting to be processed
+	BusyMessage = "I'm handling too many requests right now, please try again in a few minutes."
+	// TimeoutMessage is shown when processing an event took longer than the dispatch timeout
+	TimeoutMessage = "Sorry, this took too long to answer, please try again."
+	// PanicMessage is shown when processing an event failed unexpectedly
+	PanicMessage = "Sorry, something went wrong while handling this message."
+)
+
+// Slack connection modes
+const (
+	// SLACKMODESOCKET receives events over a Socket Mode websocket, the default
+	SLACKMODESOCKET = "socket"
+	// SLACKMODEHTTP receives events on a public request URL signed with the signing secret
+	SLACKMODEHTTP = "http"
+)
+
 // Config represents the YAML configuration structure
 type Config struct {
 	Slack *struct {
 		Token    string `yaml:"token,omitempty"`
 		AppToken string `yaml:"app_token,omitempty"`
 		Channel  string `yaml:"channel"`
+		// Mode is SLACKMODESOCKET or SLACKMODEHTTP
+		Mode string `yaml:"mode,omitempty"`
+		// SigningSecret, Addr and Path configure SLACKMODEHTTP, see slack.StartHTTP
+		SigningSecret string `yaml:"signing_secret,omitempty"`
+		Addr          string `yaml:"addr,omitempty"`
+		Path          string `yaml:"path,omitempty"`
 	} `yaml:"slack"`
 	GPT *struct {
-		Key   string `yaml:"key"`
-		Model string `yaml:"model"`
+		Provider       string `yaml:"provider,omitempty"`
+		Key            string `yaml:"key"`
+		Model          string `yaml:"model"`
+		URL            string `yaml:"url,omitempty"`
+		APIVersion     string `yaml:"api_version,omitempty"`
+		EmbeddingModel string `yaml:"embedding_model,omitempty"`
+		Timeout        int    `yaml:"timeout,omitempty"`
+		MaxRetries     int    `yaml:"max_retries,omitempty"`
+		TokenizerDir   string `yaml:"tokenizer_dir,omitempty"`
+		// TokenizerDownload fetches the tokenizers missing from TokenizerDir at startup
+		TokenizerDownload bool `yaml:"tokenizer_download,omitempty"`
+		// speech to text, defaults to the provider's /audio/transcriptions endpoint
+		TranscriptionURL   string `yaml:"transcription_url,omitempty"`
+		TranscriptionModel string `yaml:"transcription_model,omitempty"`
+		// Fallbacks are tried in order when the model above fails with a FallbackOn error class
+		Fallbacks      []FallbackConfig      `yaml:"fallbacks,omitempty"`
+		FallbackOn     []string              `yaml:"fallback_on,omitempty"`
+		CircuitBreaker *CircuitBreakerConfig `yaml:"circuit_breaker,omitempty"`
 	} `yaml:"gpt"`
 	Mail *struct {
 		Label     string `yaml:"label"`
@@ -36,10 +91,29 @@ type Config struct {
 		Secret    string `yaml:"secret"`
 		AuthToken string `yaml:"auth_token,omitempty"`
 	} `yaml:"mail"`
-	AgentConfig interface{} `yaml:"agent_config,omitempty"`
+	Usage *struct {
+		// File persists usage between restarts, defaults to usage.json
+		File          string `yaml:"file,omitempty"`
+		RetentionDays int    `yaml:"retention_days,omitempty"`
+		// FlushSeconds is how often usage is written to the file, defaults to 30
+		FlushSeconds int     `yaml:"flush_seconds,omitempty"`
+		Budgets      Budgets `yaml:"budgets,omitempty"`
+	} `yaml:"usage,omitempty"`
+	Cache *CacheConfig `yaml:"cache,omitempty"`
+	// Prompts are templates that agent_config values can reference as "prompt:<name>"
+	Prompts *PromptsConfig `yaml:"prompts,omitempty"`
+	Guard   *GuardConfig   `yaml:"guard,omitempty"`
+	// Redaction masks emails, phone and card numbers and custom patterns in LLM calls
+	Redaction *RedactionConfig `yaml:"redaction,omitempty"`
+	// Dispatch bounds the concurrent processing of events and emails
+	Dispatch    *DispatchConfig `yaml:"dispatch,omitempty"`
+	AgentConfig interface{}     `yaml:"agent_config,omitempty"`
 }
 
 type Agent struct {
+	ctx            context.Context
+	cancel         context.CancelFunc
+	ctxOnce        sync.Once
 	slackClient    *slack.Client
 	gptApiKey      string
 	Config         *Config
@@ -47,6 +121,38 @@ type Agent struct {
 	EmailProcessor func(email mail.Email)
 	SlackProcessor func(event interface{})
 	MCPClient      *MCPClient
+	// breakers are the circuit breakers of the fallback backends by name
+	breakersMu sync.Mutex
+	breakers   map[string]*gpt.CircuitBreaker
+	// Usage is set when the usage config is present, LLMs from NewLLM report to it
+	Usage *UsageTracker
+	// Cache is set when the cache config is present, LLMs from NewLLM answer repeated requests from it
+	Cache        *gpt.ResponseCache
+	semanticOnce sync.Once
+	// Prompts is set when the prompts config is present, the files are reloaded on change
+	Prompts *prompts.Library
+	// Guard is set when the guard config is present, it checks emails and tool outputs
+	Guard *guard.Guard
+	// Redactor is set when the redaction config is present, LLMs from NewLLM use it. Pass
+	// it to embedding.EmbeddingStore.SetRedactor to mask embedded texts too.
+	Redactor *gpt.Redactor
+	// interactions routes Block Kit interactions, see Interactions
+	interactions     *slack.InteractionRouter
+	interactionsOnce sync.Once
+	// commands runs slash commands, see Commands
+	commands     *slack.CommandRouter
+	commandsOnce sync.Once
+	// HTTPClient replaces the client of the LLMs from NewLLM and NewTranscriber, e.g. a
+	// cassette.Cassette client to record and replay their calls in tests
+	HTTPClient *http.Client
+	// dispatcher runs the processors, see Dispatcher. eventContexts holds the contexts of
+	// the events and emails being processed for EventContext and EmailContext.
+	dispatcher     *Dispatcher
+	dispatcherOnce sync.Once
+	eventContexts  sync.Map
+	// OnSlackConnection is called on every change of the Slack connection, see
+	// slack.Client.SetConnectionNotify. Set it before InitializeSlackClient.
+	OnSlackConnection func(stats slack.ConnectionStats)
 }
 
 func (a *Agent) GetCustomConfig(customConfig interface{}) error {
@@ -60,10 +166,40 @@ func (a *Agent) GetCustomConfig(customConfig interface{}) error {
 	return yaml.Unmarshal(data, customConfig)
 }
 
+// Context returns a context that is cancelled when the agent shuts down (see WaitForSignal).
+// Pass it, or a context derived from it with a deadline, to LLM calls made by processors.
+func (a *Agent) Context() context.Context {
+	a.ctxOnce.Do(func() {
+		a.ctx, a.cancel = context.WithCancel(context.Background())
+	})
+	return a.ctx
+}
+
+// Shutdown cancels the agent context, aborting in-flight LLM calls, and saves the usage
+// recorded since the last flush
+func (a *Agent) Shutdown() {
+	a.Context()
+	a.cancel()
+	if a.Usage != nil {
+		if err := a.Usage.Flush(); err != nil {
+			log.Printf("Failed to save usage: %v", err)
+		}
+	}
+}
+
 func (a *Agent) GetSlackClient() *slack.Client {
 	return a.slackClient
 }
 
+// SlackConnection returns the state and counters of the Slack connection, a zero value
+// before InitializeSlackClient
+func (a *Agent) SlackConnection() slack.ConnectionStats {
+	if a.slackClient == nil {
+		return slack.ConnectionStats{}
+	}
+	return a.slackClient.ConnectionStats()
+}
+
 // loadConfig reads and parses the YAML configuration file
 func (a *Agent) LoadConfig(configPath string) error {
 	data, err := ioutil.ReadFile(configPath)
@@ -87,35 +223,137 @@ func (a *Agent) LoadConfig(configPath string) error {
 		log.Fatal("Slack bot token is required in config file")
 	}
 
-	if config.Slack.AppToken == "" {
-		log.Fatal("Slack app-level token is required for Socket Mode in config file")
+	config.Slack.Mode = strings.ToLower(config.Slack.Mode)
+	switch config.Slack.Mode {
+	case "", SLACKMODESOCKET:
+		config.Slack.Mode = SLACKMODESOCKET
+		if config.Slack.AppToken == "" {
+			log.Fatal("Slack app-level token is required for Socket Mode in config file")
+		}
+	case SLACKMODEHTTP:
+		if config.Slack.SigningSecret == "" {
+			log.Fatal("Slack signing secret is required for HTTP mode in config file")
+		}
+	default:
+		log.Fatalf("Unsupported slack mode %q in config file", config.Slack.Mode)
 	}
 
 	if config.Slack.Channel == "" {
 		log.Fatal("Slack channel ID is required in config file")
 	}
 
+	if config.GPT != nil {
+		config.GPT.Provider = strings.ToLower(config.GPT.Provider)
+		if config.GPT.Provider == "" {
+			config.GPT.Provider = gpt.PROVIDEROPENAI
+		}
+	}
 	if config.GPT == nil {
 		log.Println("GPT configuration is not required in config file")
-	} else if config.GPT.Key == "" {
-		log.Fatal("OpenAI API Key is required in config file")
+	} else if !gpt.ValidProvider(config.GPT.Provider) {
+		log.Fatalf("Unsupported gpt provider %q in config file", config.GPT.Provider)
+	} else if config.GPT.Key == "" && config.GPT.Provider != gpt.PROVIDEROLLAMA {
+		log.Fatal("LLM API Key is required in config file")
+	} else if config.GPT.Provider == gpt.PROVIDERAZURE && config.GPT.URL == "" {
+		log.Fatal("Azure OpenAI endpoint url is required in config file")
+	} else if err := validateFallbacks(config.GPT.Provider, config.GPT.Fallbacks); err != nil {
+		log.Fatal(err)
 	} else {
 		a.gptApiKey = config.GPT.Key
 	}
+	if config.GPT != nil && config.GPT.TokenizerDir != "" {
+		if config.GPT.TokenizerDownload {
+			if err := gpt.DownloadTokenizers(a.Context(), config.GPT.TokenizerDir); err != nil {
+				log.Printf("Failed to download tokenizers: %v", err)
+			}
+		}
+		// token counts fall back to an estimate without the tokenizer files
+		if err := gpt.LoadTokenizers(config.GPT.TokenizerDir); err != nil {
+			log.Printf("Failed to load tokenizers: %v", err)
+		}
+	}
+
+	if config.Usage != nil {
+		file := config.Usage.File
+		if file == "" {
+			file = DefaultUsageFile
+		}
+		a.Usage = NewUsageTracker(file, config.Usage.Budgets)
+		if config.Usage.RetentionDays > 0 {
+			a.Usage.SetRetentionDays(config.Usage.RetentionDays)
+		}
+		if err := a.Usage.Load(); err != nil {
+			return err
+		}
+		a.Usage.StartFlushing(a.Context(), time.Duration(config.Usage.FlushSeconds)*time.Second)
+	}
+
+	if config.Cache != nil {
+		cache, err := newResponseCache(config.Cache)
+		if err != nil {
+			return err
+		}
+		a.Cache = cache
+	}
+
+	if config.Prompts != nil {
+		if err := a.loadPrompts(config.Prompts); err != nil {
+			return err
+		}
+	}
+
+	if config.Redaction != nil {
+		redactor, err := newRedactor(config.Redaction)
+		if err != nil {
+			return err
+		}
+		a.Redactor = redactor
+	}
 
 	a.Config = &config
+
+	if config.Guard != nil {
+		g, err := a.newGuard(config.Guard)
+		if err != nil {
+			return err
+		}
+		a.Guard = g
+	}
 	return nil
 }
 
 // initializeSlackClient creates and starts the Slack client
 func (a *Agent) InitializeSlackClient() {
+	cfg := a.Config.Slack
+	if cfg.Mode == SLACKMODEHTTP {
+		client := slack.NewHTTP(cfg.Token, cfg.SigningSecret, cfg.Channel)
+		client.SetInteractions(a.Interactions())
+		client.SetCommands(a.Commands())
+		client.SetRunner(a.runSlackRequest)
+		client.SetConnectionNotify(a.OnSlackConnection)
+
+		// Serve the request URL in a goroutine, a failure leaves the mail processing running
+		go func() {
+			if err := client.StartHTTP(cfg.Addr, cfg.Path, a.slackFilter); err != nil {
+				log.Printf("Error running Slack client: %v", err)
+			}
+		}()
+
+		a.slackClient = client
+		return
+	}
+
 	//create slack client by default
-	client := slack.New(a.Config.Slack.Token, a.Config.Slack.AppToken, a.Config.Slack.Channel)
+	client := slack.New(cfg.Token, cfg.AppToken, cfg.Channel)
+	client.SetInteractions(a.Interactions())
+	client.SetCommands(a.Commands())
+	client.SetRunner(a.runSlackRequest)
+	client.SetConnectionNotify(a.OnSlackConnection)
 
-	// Start the Slack client in a goroutine
+	// Start the Slack client in a goroutine, it reconnects until the agent shuts down
 	go func() {
-		if err := client.Start(a.slackFilter); err != nil {
-			log.Fatalf("Error running Slack client: %v", err)
+		if err := client.StartContext(a.Context(), a.slackFilter); err != nil {
+			log.Printf("Error running Slack client: %v", err)
 		}
 	}()
 
@@ -154,13 +392,19 @@ func (a *Agent) ProcessEmails() {
 		return
 	}
 
+	processor := func(email mail.Email) {
+		if a.guardEmail(email) {
+			a.dispatchEmail(email)
+		}
+	}
+
 	nextID := a.Config.Mail.MaxID
 	if nextID == "" {
 		nextID = mail.NO_MAX_ID
 	}
 	err = nil
 	for {
-		err, nextID = mail.GetEmails(srv, nextID, label, a.EmailProcessor)
+		err, nextID = mail.GetEmails(srv, nextID, label, processor)
 		if err != nil {
 			nextID = mail.NO_MAX_ID
 			log.Println("did not find any msgs")
@@ -173,16 +417,121 @@ func (a *Agent) ProcessEmails() {
 		time.Sleep(time.Duration(durationSleep) * time.Minute)
 	}
 }
-func (a *Agent) NewLLM() *gpt.OpenAI {
-	var openai gpt.OpenAI
-	openai.SetApiKey(a.Config.GPT.Key)
+
+// NewLLM creates the LLM backend selected by the provider key of the gpt config. With
+// fallbacks configured it returns a gpt.Fallback chain starting with that backend. With a
+// cache config the backend, or every backend of the chain, is wrapped in a gpt.CachedLLM,
+// so a reply is only reused for the model that gave it. With a redaction config the
+// outermost wrapper is a gpt.RedactingLLM, so the cache only sees redacted text.
+func (a *Agent) NewLLM() gpt.LLM {
+	if len(a.Config.GPT.Fallbacks) == 0 {
+		return a.withRedaction(a.withCache(a.newPrimaryLLM(), a.primaryName()))
+	}
+	return a.withRedaction(a.newFallback())
+}
+
+// primaryName is the provider and model of the gpt config, naming its cache entries and
+// its circuit breaker
+func (a *Agent) primaryName() string {
+	cfg := a.primaryConfig()
+	return cfg.Provider + "/" + cfg.Model
+}
+
+// newPrimaryLLM creates the backend of the provider, model and key of the gpt config
+func (a *Agent) newPrimaryLLM() gpt.LLM {
+	return a.newProviderLLM(a.primaryConfig())
+}
+
+// primaryConfig returns the provider config of the gpt config
+func (a *Agent) primaryConfig() gpt.ProviderConfig {
 	if a.Config.GPT.Model == "" {
-		a.Config.GPT.Model = gpt.GetDefaultModel()
+		a.Config.GPT.Model = gpt.DefaultModelFor(a.Config.GPT.Provider)
 		log.Println("Using default model: ", a.Config.GPT.Model)
 	}
-	openai.SetModel(a.Config.GPT.Model)
+	cfg := gpt.ProviderConfig{
+		Provider:       a.Config.GPT.Provider,
+		Key:            a.Config.GPT.Key,
+		Model:          a.Config.GPT.Model,
+		URL:            a.Config.GPT.URL,
+		APIVersion:     a.Config.GPT.APIVersion,
+		EmbeddingModel: a.Config.GPT.EmbeddingModel,
+		Timeout:        time.Duration(a.Config.GPT.Timeout) * time.Second,
+		MaxRetries:     a.Config.GPT.MaxRetries,
+		HTTPClient:     a.HTTPClient,
+	}
+	if a.Usage != nil {
+		cfg.Usage = a.Usage
+	}
+	return cfg
+}
 
-	return &openai
+// newProviderLLM creates the backend of cfg, an OpenAI client when cfg is incomplete
+func (a *Agent) newProviderLLM(cfg gpt.ProviderConfig) gpt.LLM {
+	llm, err := gpt.New(cfg)
+	if err != nil {
+		// the provider is validated by LoadConfig, so this only happens on incomplete settings
+		log.Printf("Error creating LLM provider, falling back to OpenAI: %v", err)
+		o := gpt.NewOpenAI(cfg.Key, cfg.Model)
+		o.SetUsageRecorder(cfg.Usage)
+		o.SetHTTPClient(cfg.HTTPClient)
+		if cfg.MaxRetries < 0 {
+			o.SetRetryPolicy(gpt.RetryPolicy{})
+		}
+		return o
+	}
+	return llm
+}
+
+// StreamReply streams the model answer for the conversation into a new Slack message,
+// posted as a thread reply when threadTimeStamp is set. It returns the final answer.
+func (a *Agent) StreamReply(channel string, threadTimeStamp string, messages []gpt.GPTmessage, opts *gpt.ChatOptions) (string, error) {
+	return a.StreamReplyContext(a.Context(), channel, threadTimeStamp, messages, opts)
+}
+
+// StreamReplyContext is StreamReply with a context to cancel the call or set a deadline
+func (a *Agent) StreamReplyContext(ctx context.Context, channel string, threadTimeStamp string, messages []gpt.GPTmessage, opts *gpt.ChatOptions) (string, error) {
+	stream, err := a.slackClient.StartStream(channel, threadTimeStamp)
+	if err != nil {
+		return "", err
+	}
+	reply, err := a.NewLLM().ChatStreamContext(ctx, messages, opts, stream.Append)
+	if err != nil {
+		partial := stream.Text()
+		if partial == "" {
+			partial = ErrorReply(err)
+		}
+		stream.Finish(partial)
+		return "", err
+	}
+	return reply.Content, stream.Finish(reply.Content)
+}
+
+// ErrorReply turns an LLM error into a message that can be shown to Slack users
+func ErrorReply(err error) string {
+	var contextErr *gpt.ContextLengthError
+	var filterErr *gpt.ContentFilterError
+	var authErr *gpt.AuthError
+	var budgetErr *BudgetError
+	var panicErr *PanicError
+	switch {
+	case errors.As(err, &budgetErr):
+		return BudgetExceededMessage
+	case errors.Is(err, ErrQueueFull):
+		return BusyMessage
+	case errors.Is(err, ErrDispatchTimeout):
+		return TimeoutMessage
+	case errors.As(err, &panicErr):
+		return PanicMessage
+	case errors.As(err, &contextErr):
+		return ContextLengthMessage
+	case errors.As(err, &filterErr):
+		return ContentFilterMessage
+	case errors.As(err, &authErr):
+		return AuthErrorMessage
+	case gpt.IsRetryable(err), errors.Is(err, gpt.ErrCircuitOpen):
+		return OverloadedMessage
+	}
+	return StreamErrorMessage
 }
 
 func (a *Agent) slackFilter(event interface{}) {
@@ -193,14 +542,20 @@ func (a *Agent) slackFilter(event interface{}) {
 			log.Println("Bot message, skipping")
 			return
 		}
-		a.SlackProcessor(event)
+		if !a.checkBudget(event) {
+			return
+		}
+		a.dispatchEvent(event)
 
 	case *slackevents.MessageEvent:
 		if ev.BotID != "" {
 			log.Println("Bot message, skipping")
 			return
 		}
-		a.SlackProcessor(event)
+		if !a.checkBudget(event) {
+			return
+		}
+		a.dispatchEvent(event)
 	}
 }
 
@@ -213,4 +568,5 @@ func (a *Agent) WaitForSignal() {
 	// Block until a signal is received.
 	<-sigs
 	log.Println("Signal received, exiting.")
+	a.Shutdown()
 }
@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"log"
+	"time"
+
+	"github.com/vtuson/slackagent/gpt"
+)
+
+const (
+	CACHEMEMORY = "memory"
+	CACHEDISK   = "disk"
+	CACHEBOTH   = "both"
+	// DefaultCacheDir is where the disk cache is kept when the cache config sets no dir
+	DefaultCacheDir = "cache"
+)
+
+// CacheConfig enables the response cache of the LLMs created by NewLLM
+type CacheConfig struct {
+	// Backend is memory (default), disk or both
+	Backend    string `yaml:"backend,omitempty"`
+	Dir        string `yaml:"dir,omitempty"`
+	MaxEntries int    `yaml:"max_entries,omitempty"`
+	// TTL is the time in seconds a response is reused, defaults to a day
+	TTL        int  `yaml:"ttl,omitempty"`
+	Embeddings bool `yaml:"embeddings,omitempty"`
+	// Semantic reuses the answer of a similar question, see gpt.ResponseCache.EnableSemantic
+	Semantic  bool    `yaml:"semantic,omitempty"`
+	Threshold float32 `yaml:"threshold,omitempty"`
+}
+
+// newResponseCache creates the cache store selected by the config
+func newResponseCache(config *CacheConfig) (*gpt.ResponseCache, error) {
+	var store gpt.CacheStore
+	dir := config.Dir
+	if dir == "" {
+		dir = DefaultCacheDir
+	}
+	switch config.Backend {
+	case "", CACHEMEMORY:
+		store = gpt.NewMemoryCache(config.MaxEntries)
+	case CACHEDISK:
+		disk, err := gpt.NewDiskCache(dir, config.MaxEntries)
+		if err != nil {
+			return nil, err
+		}
+		store = disk
+	case CACHEBOTH:
+		disk, err := gpt.NewDiskCache(dir, config.MaxEntries)
+		if err != nil {
+			return nil, err
+		}
+		store = gpt.TieredCache{gpt.NewMemoryCache(config.MaxEntries), disk}
+	default:
+		log.Fatalf("Unsupported cache backend %q in config file", config.Backend)
+	}
+	return gpt.NewResponseCache(store, time.Duration(config.TTL)*time.Second), nil
+}
+
+// withCache wraps the LLM with the response cache, if configured, its entries kept under
+// namespace. The semantic mode embeds queries with the first LLM wrapped unless the
+// processor set its own embedder, e.g. an embedding.EmbeddingStore, with SetCacheEmbedder.
+func (a *Agent) withCache(llm gpt.LLM, namespace string) gpt.LLM {
+	if a.Cache == nil {
+		return llm
+	}
+	a.semanticOnce.Do(func() {
+		if a.Config.Cache.Semantic {
+			a.Cache.EnableSemantic(gpt.SemanticEmbedderFunc(llm.GetEmbeddingContext), a.Config.Cache.Threshold)
+		}
+	})
+	cached := gpt.NewCachedLLM(llm, a.Cache, namespace)
+	cached.CacheEmbeddings(a.Config.Cache.Embeddings)
+	return cached
+}
+
+// SetCacheEmbedder sets the embedder of the semantic cache, e.g. an embedding.EmbeddingStore
+// so similar questions are matched with the local model. The threshold of the cache
+// config applies.
+func (a *Agent) SetCacheEmbedder(embedder gpt.SemanticEmbedder) {
+	if a.Cache == nil || !a.Config.Cache.Semantic {
+		log.Println("semantic cache is not enabled in config file")
+		return
+	}
+	// keeps withCache from replacing the embedder with the LLM
+	a.semanticOnce.Do(func() {})
+	a.Cache.EnableSemantic(embedder, a.Config.Cache.Threshold)
+}
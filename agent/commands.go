@@ -0,0 +1,32 @@
+package agent
+
+import (
+	"log"
+
+	"github.com/vtuson/slackagent/slack"
+)
+
+// Commands returns the router of the slash commands of the Slack client. Commands can be
+// registered before InitializeSlackClient; handler errors are reported with ErrorReply.
+func (a *Agent) Commands() *slack.CommandRouter {
+	a.commandsOnce.Do(func() {
+		a.commands = slack.NewCommandRouter()
+		a.commands.ErrorReply = ErrorReply
+	})
+	return a.commands
+}
+
+// OnCommand registers a slash command, e.g. a.OnCommand("/ask", "<question>", handler).
+// The command must also be created in the Slack app settings. With a usage config,
+// commands of users or channels over budget get BudgetExceededMessage instead.
+func (a *Agent) OnCommand(name string, usage string, handler slack.CommandHandler) {
+	a.Commands().OnCommand(name, usage, func(cmd *slack.Command) error {
+		if a.Usage != nil {
+			if err := a.Usage.AllowUsage(a.EventContext(cmd)); err != nil {
+				log.Printf("Skipping command %s: %v", cmd.SlashCommand.Command, err)
+				return cmd.Reply(BudgetExceededMessage)
+			}
+		}
+		return handler(cmd)
+	})
+}
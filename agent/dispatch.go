@@ -0,0 +1,352 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+	"github.com/vtuson/slackagent/mail"
+	"github.com/vtuson/slackagent/slack"
+)
+
+const (
+	// DefaultDispatchWorkers bounds the events and emails processed at the same time
+	DefaultDispatchWorkers = 8
+	// DefaultDispatchQueue bounds the events and emails waiting for a worker
+	DefaultDispatchQueue = 100
+	// DefaultDispatchTimeout is the deadline of the context of an event or email
+	DefaultDispatchTimeout = 5 * time.Minute
+)
+
+var (
+	// ErrQueueFull is reported for events submitted while the queue is full
+	ErrQueueFull = errors.New("dispatch queue full")
+	// ErrDispatchTimeout is reported for events still processed at their deadline
+	ErrDispatchTimeout = errors.New("event processing timed out")
+)
+
+// PanicError is reported for events whose processor panicked
+type PanicError struct {
+	Value interface{}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("event processing panicked: %v", e.Value)
+}
+
+// DispatchConfig bounds the concurrent processing of Slack events and emails
+type DispatchConfig struct {
+	Workers   int `yaml:"workers,omitempty"`
+	QueueSize int `yaml:"queue_size,omitempty"`
+	// Timeout in seconds of the context of an event, see DefaultDispatchTimeout. It is
+	// advisory: only the calls made with EventContext or EmailContext are cancelled.
+	Timeout int `yaml:"timeout,omitempty"`
+}
+
+// dispatchTask is a submitted event
+type dispatchTask struct {
+	run    func(ctx context.Context)
+	report func(err error)
+}
+
+// Dispatcher runs tasks on a bounded pool of workers. Tasks with the same key, e.g. the
+// events of a Slack thread, run one at a time in the order they were submitted; tasks
+// with different keys run concurrently.
+//
+// The timeout is advisory: it cancels the context given to a task, and a task that
+// ignores it keeps its worker until it returns, when ErrDispatchTimeout is reported.
+type Dispatcher struct {
+	ctx       context.Context
+	workers   int
+	queueSize int
+	timeout   time.Duration
+
+	mu      sync.Mutex
+	queues  map[string][]*dispatchTask
+	busy    map[string]bool
+	ready   []string
+	running int
+	pending int
+	space   chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher whose task contexts derive from ctx, zero values
+// select the defaults and a negative timeout disables it
+func NewDispatcher(ctx context.Context, workers int, queueSize int, timeout time.Duration) *Dispatcher {
+	if workers <= 0 {
+		workers = DefaultDispatchWorkers
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultDispatchQueue
+	}
+	if timeout == 0 {
+		timeout = DefaultDispatchTimeout
+	}
+	return &Dispatcher{
+		ctx:       ctx,
+		workers:   workers,
+		queueSize: queueSize,
+		timeout:   timeout,
+		queues:    make(map[string][]*dispatchTask),
+		busy:      make(map[string]bool),
+		space:     make(chan struct{}),
+	}
+}
+
+// Submit queues run after the tasks already submitted with key. It returns ErrQueueFull
+// without queueing when the queue is full. report, which may be nil, is called with a
+// *PanicError when run panics and with ErrDispatchTimeout when run returns after the deadline
+// of its context.
+func (d *Dispatcher) Submit(key string, run func(ctx context.Context), report func(err error)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pending >= d.queueSize {
+		return ErrQueueFull
+	}
+	d.enqueue(key, &dispatchTask{run: run, report: report})
+	return nil
+}
+
+// SubmitWait is Submit waiting for room in the queue instead of failing, until ctx is done
+func (d *Dispatcher) SubmitWait(ctx context.Context, key string, run func(ctx context.Context), report func(err error)) error {
+	for {
+		d.mu.Lock()
+		if d.pending < d.queueSize {
+			d.enqueue(key, &dispatchTask{run: run, report: report})
+			d.mu.Unlock()
+			return nil
+		}
+		space := d.space
+		d.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-space:
+		}
+	}
+}
+
+// Pending returns the number of tasks waiting for a worker
+func (d *Dispatcher) Pending() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.pending
+}
+
+// Wait blocks until every submitted task has run
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// enqueue adds a task and starts a worker for its key when one is free. d.mu must be held.
+func (d *Dispatcher) enqueue(key string, task *dispatchTask) {
+	d.wg.Add(1)
+	d.pending++
+	d.queues[key] = append(d.queues[key], task)
+	if d.busy[key] {
+		return
+	}
+	d.busy[key] = true
+	if d.running < d.workers {
+		d.running++
+		go d.work(key)
+		return
+	}
+	d.ready = append(d.ready, key)
+}
+
+// work runs the tasks of key, moving on to the other ready keys in turn so a long thread
+// does not hold a worker while other threads wait
+func (d *Dispatcher) work(key string) {
+	for {
+		d.mu.Lock()
+		queue := d.queues[key]
+		if len(queue) == 0 {
+			delete(d.queues, key)
+			delete(d.busy, key)
+			if len(d.ready) == 0 {
+				d.running--
+				d.mu.Unlock()
+				return
+			}
+			key, d.ready = d.ready[0], d.ready[1:]
+			d.mu.Unlock()
+			continue
+		}
+		task := queue[0]
+		d.queues[key] = queue[1:]
+		d.pending--
+		close(d.space)
+		d.space = make(chan struct{})
+		d.mu.Unlock()
+
+		d.run(key, task)
+
+		d.mu.Lock()
+		if len(d.ready) > 0 && len(d.queues[key]) > 0 {
+			d.ready = append(d.ready, key)
+			key, d.ready = d.ready[0], d.ready[1:]
+		}
+		d.mu.Unlock()
+	}
+}
+
+// run calls a task with its deadline, recovering from panics
+func (d *Dispatcher) run(key string, task *dispatchTask) {
+	defer d.wg.Done()
+	ctx := d.ctx
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+
+	var err error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Panic processing %s: %v\n%s", key, r, debug.Stack())
+				err = &PanicError{Value: r}
+			}
+		}()
+		task.run(ctx)
+	}()
+	if err == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Printf("Processing %s took longer than %v", key, d.timeout)
+		err = ErrDispatchTimeout
+	}
+	if err != nil && task.report != nil {
+		task.report(err)
+	}
+}
+
+// Dispatcher returns the dispatcher of the Slack events and emails, configured by the
+// dispatch config
+func (a *Agent) Dispatcher() *Dispatcher {
+	a.dispatcherOnce.Do(func() {
+		var workers, queueSize int
+		var timeout time.Duration
+		if a.Config != nil && a.Config.Dispatch != nil {
+			workers, queueSize = a.Config.Dispatch.Workers, a.Config.Dispatch.QueueSize
+			timeout = time.Duration(a.Config.Dispatch.Timeout) * time.Second
+		}
+		a.dispatcher = NewDispatcher(a.Context(), workers, queueSize, timeout)
+	})
+	return a.dispatcher
+}
+
+// dispatchEvent runs SlackProcessor on the dispatcher, serially within the event's thread.
+// Failures are reported in the thread of mentions and direct messages.
+func (a *Agent) dispatchEvent(event interface{}) {
+	key := slack.ThreadKey(eventThread(event))
+	report := func(err error) {
+		log.Printf("Slack event in %s failed: %v", key, err)
+		if channel, threadTimeStamp, ok := replyThread(event); ok {
+			if _, err := a.slackClient.PostInThread(channel, ErrorReply(err), threadTimeStamp); err != nil {
+				log.Printf("Failed to report event failure: %v", err)
+			}
+		}
+	}
+	err := a.Dispatcher().Submit(key, func(ctx context.Context) {
+		a.eventContexts.Store(event, ctx)
+		defer a.eventContexts.Delete(event)
+		a.SlackProcessor(event)
+	}, report)
+	if err != nil {
+		report(err)
+	}
+}
+
+// runSlackRequest is the slack.Runner of the agent: slash command and interaction handlers
+// run on the dispatcher with the events, the clicks in a thread after its earlier events
+func (a *Agent) runSlackRequest(key string, run func(ctx context.Context)) {
+	report := func(err error) {
+		log.Printf("Slack request %s failed: %v", key, err)
+	}
+	if err := a.Dispatcher().Submit(key, run, report); err != nil {
+		report(err)
+	}
+}
+
+// requestContext is the context a runner gave a command or interaction, the agent
+// context when it has none
+func (a *Agent) requestContext(ctx context.Context) context.Context {
+	if ctx.Done() == nil {
+		return a.Context()
+	}
+	return ctx
+}
+
+// dispatchEmail runs EmailProcessor on the dispatcher, waiting for room in the queue so
+// emails are not dropped
+func (a *Agent) dispatchEmail(email mail.Email) {
+	key := "mail:" + email.Id
+	err := a.Dispatcher().SubmitWait(a.Context(), key, func(ctx context.Context) {
+		a.eventContexts.Store(emailContextKey(email.Id), ctx)
+		defer a.eventContexts.Delete(emailContextKey(email.Id))
+		a.EmailProcessor(email)
+	}, func(err error) {
+		log.Printf("Email %s failed: %v", email.Id, err)
+	})
+	if err != nil {
+		log.Printf("Email %s not processed: %v", email.Id, err)
+	}
+}
+
+// emailContextKey keys the contexts of emails being processed
+type emailContextKey string
+
+// EmailContext returns the context to pass to the LLM calls made for an email by
+// EmailProcessor, with its deadline and usage attributed to the sender
+func (a *Agent) EmailContext(email mail.Email) context.Context {
+	ctx := a.Context()
+	if dispatched, ok := a.eventContexts.Load(emailContextKey(email.Id)); ok {
+		ctx = dispatched.(context.Context)
+	}
+	scope := UsageScope{Processor: "mail"}
+	if email.From != nil {
+		scope.User = email.From.Address
+	}
+	return WithUsageScope(ctx, scope)
+}
+
+// eventThread returns the channel and the thread of a message event, the message itself
+// when it starts a thread
+func eventThread(event interface{}) (string, string) {
+	switch ev := event.(type) {
+	case *slackevents.AppMentionEvent:
+		if ev.ThreadTimeStamp != "" {
+			return ev.Channel, ev.ThreadTimeStamp
+		}
+		return ev.Channel, ev.TimeStamp
+	case *slackevents.MessageEvent:
+		if ev.ThreadTimeStamp != "" {
+			return ev.Channel, ev.ThreadTimeStamp
+		}
+		return ev.Channel, ev.TimeStamp
+	}
+	return "", ""
+}
+
+// replyThread returns the thread where the agent may answer on its own: the thread of a
+// mention or a direct message
+func replyThread(event interface{}) (string, string, bool) {
+	switch ev := event.(type) {
+	case *slackevents.AppMentionEvent:
+	case *slackevents.MessageEvent:
+		if ev.ChannelType != "im" {
+			return "", "", false
+		}
+	default:
+		return "", "", false
+	}
+	channel, threadTimeStamp := eventThread(event)
+	return channel, threadTimeStamp, true
+}
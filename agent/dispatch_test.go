@@ -0,0 +1,184 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestDispatcherKeepsOrderPerKey(t *testing.T) {
+	d := NewDispatcher(context.Background(), 4, 1000, time.Second)
+	var mu sync.Mutex
+	order := make(map[string][]int)
+	active := make(map[string]bool)
+	for i := 0; i < 60; i++ {
+		i, key := i, fmt.Sprintf("thread-%d", i%6)
+		err := d.Submit(key, func(ctx context.Context) {
+			mu.Lock()
+			if active[key] {
+				t.Errorf("two tasks of %s ran at once", key)
+			}
+			active[key] = true
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			active[key] = false
+			order[key] = append(order[key], i)
+			mu.Unlock()
+		}, nil)
+		if err != nil {
+			t.Fatalf("Submit: %v", err)
+		}
+	}
+	d.Wait()
+	for key, got := range order {
+		if len(got) != 10 {
+			t.Errorf("%s ran %d tasks, want 10", key, len(got))
+		}
+		for j := 1; j < len(got); j++ {
+			if got[j] < got[j-1] {
+				t.Errorf("%s ran out of order: %v", key, got)
+				break
+			}
+		}
+	}
+}
+
+func TestDispatcherRunsKeysConcurrently(t *testing.T) {
+	const workers = 3
+	d := NewDispatcher(context.Background(), workers, 100, time.Second)
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	for i := 0; i < 10; i++ {
+		d.Submit(fmt.Sprintf("key-%d", i), func(ctx context.Context) {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			started <- struct{}{}
+			<-release
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}, nil)
+	}
+	for i := 0; i < workers; i++ {
+		<-started
+	}
+	if pending := d.Pending(); pending != 10-workers {
+		t.Errorf("Pending() = %d, want %d", pending, 10-workers)
+	}
+	close(release)
+	d.Wait()
+	if maxRunning != workers {
+		t.Errorf("%d tasks ran at once, want %d", maxRunning, workers)
+	}
+}
+
+func TestDispatcherQueueFull(t *testing.T) {
+	d := NewDispatcher(context.Background(), 1, 1, time.Second)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	d.Submit("a", func(ctx context.Context) { close(started); <-release }, nil)
+	<-started
+	if err := d.Submit("b", func(ctx context.Context) {}, nil); err != nil {
+		t.Fatalf("Submit with room: %v", err)
+	}
+	if err := d.Submit("c", func(ctx context.Context) {}, nil); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Submit on a full queue = %v, want ErrQueueFull", err)
+	}
+	close(release)
+	d.Wait()
+}
+
+func TestDispatcherSubmitWaitUnblocks(t *testing.T) {
+	d := NewDispatcher(context.Background(), 1, 1, time.Second)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	d.Submit("a", func(ctx context.Context) { close(started); <-release }, nil)
+	<-started
+	d.Submit("b", func(ctx context.Context) {}, nil)
+
+	ran := make(chan struct{})
+	submitted := make(chan error)
+	go func() {
+		submitted <- d.SubmitWait(context.Background(), "c", func(ctx context.Context) { close(ran) }, nil)
+	}()
+	select {
+	case err := <-submitted:
+		t.Fatalf("SubmitWait returned %v on a full queue", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-submitted; err != nil {
+		t.Fatalf("SubmitWait: %v", err)
+	}
+	<-ran
+
+	// a cancelled wait gives up
+	block := make(chan struct{})
+	d.Submit("d", func(ctx context.Context) { <-block }, nil)
+	d.Submit("d", func(ctx context.Context) {}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := d.SubmitWait(ctx, "e", func(ctx context.Context) {}, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled SubmitWait = %v, want context.Canceled", err)
+	}
+	close(block)
+	d.Wait()
+}
+
+func TestDispatcherRecoversPanics(t *testing.T) {
+	d := NewDispatcher(context.Background(), 1, 10, time.Second)
+	reported := make(chan error, 1)
+	d.Submit("a", func(ctx context.Context) { panic("boom") }, func(err error) { reported <- err })
+	ran := make(chan struct{})
+	d.Submit("a", func(ctx context.Context) { close(ran) }, nil)
+	d.Wait()
+	<-ran
+
+	var panicErr *PanicError
+	if err := <-reported; !errors.As(err, &panicErr) || panicErr.Value != "boom" {
+		t.Fatalf("reported %v, want a PanicError", err)
+	}
+	if got := ErrorReply(panicErr); got != PanicMessage {
+		t.Errorf("ErrorReply = %q, want PanicMessage", got)
+	}
+}
+
+func TestDispatcherReportsTimeout(t *testing.T) {
+	d := NewDispatcher(context.Background(), 1, 10, 10*time.Millisecond)
+	reported := make(chan error, 1)
+	d.Submit("a", func(ctx context.Context) { <-ctx.Done() }, func(err error) { reported <- err })
+	d.Wait()
+	if err := <-reported; !errors.Is(err, ErrDispatchTimeout) {
+		t.Fatalf("reported %v, want ErrDispatchTimeout", err)
+	}
+}
+
+func TestEventContextCarriesDispatchDeadline(t *testing.T) {
+	a := &Agent{Config: &Config{Dispatch: &DispatchConfig{Timeout: 30}}}
+	hasDeadline := make(chan bool, 1)
+	a.SlackProcessor = func(event interface{}) {
+		_, ok := a.EventContext(event).Deadline()
+		hasDeadline <- ok
+	}
+	event := &slackevents.MessageEvent{Channel: "C1", TimeStamp: "1.0"}
+	a.slackFilter(event)
+	if !<-hasDeadline {
+		t.Fatal("EventContext has no deadline while the event is dispatched")
+	}
+	a.Dispatcher().Wait()
+	if _, ok := a.EventContext(event).Deadline(); ok {
+		t.Error("EventContext kept the deadline after the event was processed")
+	}
+}
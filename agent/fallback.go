@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/vtuson/slackagent/gpt"
+)
+
+// FallbackConfig is an entry of the gpt fallbacks list. Empty fields are inherited from
+// the main gpt config; the key and url only when the provider is the same.
+type FallbackConfig struct {
+	Provider   string `yaml:"provider,omitempty"`
+	Key        string `yaml:"key,omitempty"`
+	Model      string `yaml:"model"`
+	URL        string `yaml:"url,omitempty"`
+	APIVersion string `yaml:"api_version,omitempty"`
+}
+
+// CircuitBreakerConfig tunes the circuit breaker of every backend of the fallback chain
+type CircuitBreakerConfig struct {
+	// Failures is the number of consecutive failures that opens the circuit
+	Failures int `yaml:"failures,omitempty"`
+	// Cooldown is the time in seconds an open circuit skips the backend
+	Cooldown int `yaml:"cooldown,omitempty"`
+}
+
+// validateFallbacks checks the fallback providers, lowercasing them like the main provider
+func validateFallbacks(provider string, fallbacks []FallbackConfig) error {
+	for i := range fallbacks {
+		fb := &fallbacks[i]
+		fb.Provider = strings.ToLower(fb.Provider)
+		if fb.Provider == "" {
+			fb.Provider = provider
+		}
+		if !gpt.ValidProvider(fb.Provider) {
+			return fmt.Errorf("unsupported provider %q in gpt fallback %d", fb.Provider, i+1)
+		}
+		if fb.Provider == gpt.PROVIDERAZURE && fb.URL == "" && provider != gpt.PROVIDERAZURE {
+			return fmt.Errorf("azure OpenAI endpoint url is required in gpt fallback %d", i+1)
+		}
+	}
+	return nil
+}
+
+// providerConfig returns the config of a fallback entry, inheriting from the gpt config
+func (a *Agent) providerConfig(fb FallbackConfig) gpt.ProviderConfig {
+	main := a.Config.GPT
+	cfg := gpt.ProviderConfig{
+		Provider:   fb.Provider,
+		Key:        fb.Key,
+		Model:      fb.Model,
+		URL:        fb.URL,
+		APIVersion: fb.APIVersion,
+		Timeout:    time.Duration(main.Timeout) * time.Second,
+		MaxRetries: main.MaxRetries,
+		HTTPClient: a.HTTPClient,
+	}
+	if fb.Provider == main.Provider {
+		if cfg.Key == "" {
+			cfg.Key = main.Key
+		}
+		if cfg.URL == "" {
+			cfg.URL = main.URL
+		}
+		if cfg.APIVersion == "" {
+			cfg.APIVersion = main.APIVersion
+		}
+	}
+	if a.Usage != nil {
+		cfg.Usage = a.Usage
+	}
+	return cfg
+}
+
+// newFallback chains the primary backend with the configured fallbacks. Only the last
+// backend retries, so a rate limited or overloaded backend fails over at once; embeddings
+// use the primary backend with its retries. Every backend has its own cache entries, so
+// the reply of a fallback model is not served later as the primary's. The circuit
+// breakers live on the agent, so their state survives the chains built per request.
+func (a *Agent) newFallback() gpt.LLM {
+	main := a.Config.GPT
+	primary := a.primaryConfig()
+	primary.MaxRetries = -1
+	names := []string{a.primaryName()}
+	// wrapped first, so the semantic cache embeds with the primary backend and its retries
+	embedder := a.withCache(a.newPrimaryLLM(), names[0])
+	llms := []gpt.LLM{a.newProviderLLM(primary)}
+	for i, fb := range main.Fallbacks {
+		cfg := a.providerConfig(fb)
+		if i < len(main.Fallbacks)-1 {
+			cfg.MaxRetries = -1
+		}
+		llm, err := gpt.New(cfg)
+		if err != nil {
+			log.Printf("Error creating LLM fallback %s/%s: %v", fb.Provider, fb.Model, err)
+			continue
+		}
+		names = append(names, fb.Provider+"/"+fb.Model)
+		llms = append(llms, llm)
+	}
+
+	backends := make([]gpt.FallbackBackend, 0, len(llms))
+	for i, llm := range llms {
+		backends = append(backends, gpt.FallbackBackend{Name: names[i], LLM: a.withCache(llm, names[i]), Breaker: a.breaker(names[i])})
+	}
+	f := gpt.NewFallback(backends...)
+	f.SetEmbedder(embedder)
+	if len(main.FallbackOn) > 0 {
+		f.SetFallbackClasses(main.FallbackOn)
+	}
+	return f
+}
+
+// breaker returns the circuit breaker of a backend, created on its first use so a backend
+// that fails to build once still gets one later
+func (a *Agent) breaker(name string) *gpt.CircuitBreaker {
+	a.breakersMu.Lock()
+	defer a.breakersMu.Unlock()
+	if b, ok := a.breakers[name]; ok {
+		return b
+	}
+	var failures, cooldown int
+	if cb := a.Config.GPT.CircuitBreaker; cb != nil {
+		failures, cooldown = cb.Failures, cb.Cooldown
+	}
+	if a.breakers == nil {
+		a.breakers = make(map[string]*gpt.CircuitBreaker)
+	}
+	b := gpt.NewCircuitBreaker(name, failures, time.Duration(cooldown)*time.Second)
+	a.breakers[name] = b
+	return b
+}
@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vtuson/slackagent/gpt"
+	"github.com/vtuson/slackagent/gpt/gpttest"
+	"gopkg.in/yaml.v2"
+)
+
+func TestFallbackFailsOverWithoutRetrying(t *testing.T) {
+	primary, fallback := gpttest.NewServer(t), gpttest.NewServer(t)
+	primary.Reply(gpttest.RateLimited(10 * time.Second))
+	fallback.Reply(gpttest.Text("from the fallback"))
+
+	var config Config
+	err := yaml.Unmarshal([]byte(`
+gpt:
+  provider: ollama
+  model: primary
+  url: `+primary.BaseURL()+`
+  max_retries: 3
+  fallbacks:
+    - model: fallback
+      url: `+fallback.BaseURL()), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Agent{Config: &config}
+
+	start := time.Now()
+	reply, err := a.NewLLM().Chat([]gpt.GPTmessage{{Role: gpt.USERROLE, Content: "hi"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Content != "from the fallback" {
+		t.Errorf("reply %q, want the fallback answer", reply.Content)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("fell back after %v, want no retry of the primary", elapsed)
+	}
+	primary.AssertChatCalls(t, 1)
+	fallback.AssertChatCalls(t, 1)
+}
+
+func TestFallbackBreakersAreKeptByName(t *testing.T) {
+	var config Config
+	err := yaml.Unmarshal([]byte(`
+gpt:
+  provider: ollama
+  model: primary
+  url: http://localhost:1
+  fallbacks:
+    - provider: azure
+      model: deployment
+    - provider: ollama
+      model: last`), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Agent{Config: &config}
+
+	// the azure fallback has no endpoint yet and is left out of the chain
+	a.newFallback()
+	last := a.breaker("ollama/last")
+	if len(a.breakers) != 2 || a.breakers["ollama/primary"] == nil || last == nil {
+		t.Fatalf("breakers %v, want the primary and the last fallback", a.breakers)
+	}
+
+	config.GPT.Fallbacks[0].URL = "https://example.openai.azure.com"
+	a.newFallback()
+	if len(a.breakers) != 3 || a.breakers["azure/deployment"] == nil {
+		t.Errorf("breakers %v, want one for the azure fallback once it builds", a.breakers)
+	}
+	if a.breaker("ollama/last") != last {
+		t.Error("the last fallback got a new breaker, want its state kept")
+	}
+}
+
+func TestFallbackRepliesAreCachedPerBackend(t *testing.T) {
+	primary, fallback := gpttest.NewServer(t), gpttest.NewServer(t)
+	primary.Reply(gpttest.RateLimited(10*time.Second), gpttest.Text("from the primary"))
+	fallback.Reply(gpttest.Text("from the fallback"))
+
+	var config Config
+	err := yaml.Unmarshal([]byte(`
+gpt:
+  provider: ollama
+  model: primary
+  url: `+primary.BaseURL()+`
+  fallbacks:
+    - model: fallback
+      url: `+fallback.BaseURL()+`
+cache: {}`), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := newResponseCache(config.Cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Agent{Config: &config, Cache: cache}
+
+	messages := []gpt.GPTmessage{{Role: gpt.USERROLE, Content: "hi"}}
+	for _, want := range []string{"from the fallback", "from the primary", "from the primary"} {
+		reply, err := a.NewLLM().Chat(messages, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.Content != want {
+			t.Errorf("reply %q, want %q", reply.Content, want)
+		}
+	}
+	// the fallback reply was not reused for the primary, whose reply was then cached
+	primary.AssertChatCalls(t, 2)
+	fallback.AssertChatCalls(t, 1)
+}
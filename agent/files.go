@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"context"
+	"log"
+	"time"
+
+	goslack "github.com/slack-go/slack"
+	"github.com/vtuson/slackagent/gpt"
+	"github.com/vtuson/slackagent/slack"
+)
+
+// ImageParts downloads the images among the files of a message as content parts for a
+// multimodal prompt. Other files are skipped and failed downloads are logged.
+func (a *Agent) ImageParts(ctx context.Context, files []goslack.File) []gpt.ContentPart {
+	var parts []gpt.ContentPart
+	for _, f := range files {
+		if !slack.IsImage(f) {
+			continue
+		}
+		data, err := a.slackClient.DownloadFile(ctx, f)
+		if err != nil {
+			log.Printf("Failed to download image %s: %v", f.Name, err)
+			continue
+		}
+		parts = append(parts, gpt.ImageDataPart(f.Mimetype, data))
+	}
+	return parts
+}
+
+// NewTranscriber returns the speech to text backend: the configured transcription_url,
+// otherwise the transcription endpoint of the LLM provider when it has one
+func (a *Agent) NewTranscriber() (gpt.Transcriber, error) {
+	cfg := a.Config.GPT
+	o, ok := a.newPrimaryLLM().(*gpt.OpenAI)
+	if !ok {
+		if cfg.TranscriptionURL == "" {
+			return nil, gpt.ErrTranscriptionUnsupported
+		}
+		// the provider key is not valid for the whisper server
+		o = gpt.NewOpenAI("", cfg.Model)
+		o.SetTimeout(time.Duration(cfg.Timeout) * time.Second)
+		o.SetHTTPClient(a.HTTPClient)
+		if a.Usage != nil {
+			o.SetUsageRecorder(a.Usage)
+		}
+	}
+	if cfg.TranscriptionURL != "" {
+		o.SetTranscriptionURL(cfg.TranscriptionURL)
+	}
+	if cfg.TranscriptionModel != "" {
+		o.SetTranscriptionModel(cfg.TranscriptionModel)
+	}
+	return o, nil
+}
+
+// TranscribeFile downloads a Slack voice clip or recording and transcribes it
+func (a *Agent) TranscribeFile(ctx context.Context, file goslack.File, opts *gpt.TranscriptionOptions) (string, error) {
+	transcriber, err := a.NewTranscriber()
+	if err != nil {
+		return "", err
+	}
+	audio, name, err := a.slackClient.DownloadAudio(ctx, file)
+	if err != nil {
+		return "", err
+	}
+	transcription, err := transcriber.TranscribeContext(ctx, audio, name, opts)
+	if err != nil {
+		return "", err
+	}
+	return transcription.Text, nil
+}
@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	goslack "github.com/slack-go/slack"
+	"github.com/vtuson/slackagent/gpt"
+	"github.com/vtuson/slackagent/slack"
+	"gopkg.in/yaml.v2"
+)
+
+// newFilesAgent returns an agent whose Slack client downloads files from a fake server
+func newFilesAgent(t *testing.T, handler http.HandlerFunc) (*Agent, *httptest.Server) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/auth.test", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true,"user_id":"UBOT","bot_id":"BBOT"}`))
+	})
+	mux.HandleFunc("/files/", handler)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	api := goslack.New("xoxb-test", goslack.OptionAPIURL(server.URL+"/api/"))
+	return &Agent{Config: &Config{}, slackClient: slack.NewWithAPI(api, "C1")}, server
+}
+
+func TestImagePartsSkipsOtherFilesAndFailedDownloads(t *testing.T) {
+	a, server := newFilesAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/files/logo.png":
+			w.Write([]byte{1, 2})
+		case "/files/gone.png":
+			http.NotFound(w, r)
+		default:
+			t.Errorf("unexpected download of %s", r.URL.Path)
+		}
+	})
+	files := []goslack.File{
+		{Name: "logo.png", Mimetype: "image/png", URLPrivateDownload: server.URL + "/files/logo.png"},
+		{Name: "report.pdf", Mimetype: "application/pdf", URLPrivateDownload: server.URL + "/files/report.pdf"},
+		{Name: "gone.png", Mimetype: "image/png", URLPrivateDownload: server.URL + "/files/gone.png"},
+	}
+	parts := a.ImageParts(context.Background(), files)
+	if len(parts) != 1 {
+		t.Fatalf("parts %+v, want the logo only", parts)
+	}
+
+	data, err := json.Marshal(gpt.NewImageMessage("what is this?", parts...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"role":"user","content":[{"type":"text","text":"what is this?"},` +
+		`{"type":"image_url","image_url":{"url":"data:image/png;base64,AQI="}}]}`
+	if string(data) != want {
+		t.Errorf("message\n got %s\nwant %s", data, want)
+	}
+}
+
+func TestTranscribeFileSendsTheDownloadedAudio(t *testing.T) {
+	whisper := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		audio, _ := io.ReadAll(file)
+		if header.Filename != "F1.m4a" || string(audio) != "AUDIO" || r.FormValue("model") != "whisper-small" || r.FormValue("language") != "en" {
+			t.Errorf("transcribed %s (%q) with model %q, language %q", header.Filename, audio, r.FormValue("model"), r.FormValue("language"))
+		}
+		w.Write([]byte(`{"text":"Remind me to call Bob","language":"english","duration":1.5}`))
+	}))
+	defer whisper.Close()
+	a, server := newFilesAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("AUDIO"))
+	})
+	err := yaml.Unmarshal([]byte(`
+gpt:
+  provider: anthropic
+  key: key
+  transcription_url: `+whisper.URL+`/inference
+  transcription_model: whisper-small`), a.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clip := goslack.File{ID: "F1", Mimetype: "audio/mp4", URLPrivateDownload: server.URL + "/files/clip"}
+	text, err := a.TranscribeFile(context.Background(), clip, &gpt.TranscriptionOptions{Language: "en"})
+	if err != nil || text != "Remind me to call Bob" {
+		t.Errorf("TranscribeFile = %q, %v", text, err)
+	}
+	if _, err := a.TranscribeFile(context.Background(), goslack.File{Name: "notes.txt", Mimetype: "text/plain"}, nil); err == nil {
+		t.Error("transcribed a text file")
+	}
+}
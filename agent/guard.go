@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/vtuson/slackagent/gpt"
+	"github.com/vtuson/slackagent/guard"
+	"github.com/vtuson/slackagent/mail"
+)
+
+// GuardConfig enables the content guard on emails and MCP tool outputs, see the guard package
+type GuardConfig struct {
+	// Sources are the inputs checked, email and/or tool, defaults to both
+	Sources   []string `yaml:"sources,omitempty"`
+	Threshold float64  `yaml:"threshold,omitempty"`
+	// Moderation checks inputs with the provider's moderation endpoint
+	Moderation bool `yaml:"moderation,omitempty"`
+	// Classifier asks the LLM whether inputs the heuristics let through are injections
+	Classifier    bool   `yaml:"classifier,omitempty"`
+	OnSuspicious  string `yaml:"on_suspicious,omitempty"`
+	OnFlagged     string `yaml:"on_flagged,omitempty"`
+	QuarantineDir string `yaml:"quarantine_dir,omitempty"`
+	// NotifyChannel is the Slack channel told about quarantined and rejected emails
+	NotifyChannel string `yaml:"notify_channel,omitempty"`
+}
+
+// newGuard creates the guard of the config. The moderation and classifier calls use the
+// gpt config and are skipped without it.
+func (a *Agent) newGuard(config *GuardConfig) (*guard.Guard, error) {
+	for _, action := range []string{config.OnSuspicious, config.OnFlagged} {
+		switch action {
+		case "", guard.ACTIONALLOW, guard.ACTIONQUARANTINE, guard.ACTIONREJECT:
+		default:
+			log.Fatalf("Unsupported guard action %q in config file", action)
+		}
+	}
+
+	g := guard.New()
+	g.OnSuspicious = config.OnSuspicious
+	g.OnFlagged = config.OnFlagged
+	if config.Threshold > 0 {
+		g.Threshold = config.Threshold
+	}
+	if config.QuarantineDir != "" {
+		quarantine, err := guard.NewDirQuarantine(config.QuarantineDir)
+		if err != nil {
+			return nil, err
+		}
+		g.Quarantine = quarantine
+	}
+
+	if (config.Moderation || config.Classifier) && a.Config.GPT == nil {
+		log.Println("guard moderation and classifier need the gpt configuration, using heuristics only")
+		return g, nil
+	}
+	if config.Moderation {
+		if moderator, ok := a.newPrimaryLLM().(gpt.Moderator); ok && moderationSupported(moderator) {
+			g.Moderator = moderator
+			if a.Redactor != nil {
+				g.Moderator = redactedModerator{Moderator: moderator, redactor: a.Redactor}
+			}
+		} else {
+			log.Printf("Provider %s has no moderation endpoint, using heuristics only", a.Config.GPT.Provider)
+		}
+	}
+	if config.Classifier {
+		g.Classifier = a.NewLLM()
+	}
+	return g, nil
+}
+
+// moderationSupported reports whether the moderator has an endpoint to call
+func moderationSupported(moderator gpt.Moderator) bool {
+	if m, ok := moderator.(interface{ ModerationSupported() bool }); ok {
+		return m.ModerationSupported()
+	}
+	return true
+}
+
+// guards reports whether inputs from source are checked by the guard
+func (a *Agent) guards(source string) bool {
+	if a.Guard == nil {
+		return false
+	}
+	sources := a.Config.Guard.Sources
+	if len(sources) == 0 {
+		return true
+	}
+	for _, s := range sources {
+		if strings.EqualFold(s, source) {
+			return true
+		}
+	}
+	return false
+}
+
+// guardEmail checks an email before it is handed to the email processor. Rejected and
+// quarantined emails are skipped, and reported to the notify channel for review.
+func (a *Agent) guardEmail(email mail.Email) bool {
+	if !a.guards(guard.SOURCEEMAIL) {
+		return true
+	}
+	// the headers identify the email in the quarantine
+	text := strings.Join([]string{"Email: " + email.Id, "From: " + emailSender(email), "Subject: " + email.Subject,
+		email.Body, email.BodyHtml}, "\n")
+	ctx := WithUsageScope(a.Context(), UsageScope{User: emailSender(email), Processor: "guard"})
+	result := a.Guard.Check(ctx, guard.SOURCEEMAIL, text)
+	if result.Allowed() {
+		return true
+	}
+	log.Printf("Skipping email %s from %s: %s", email.Id, emailSender(email), result.Reason)
+	a.notifyGuardedEmail(email, result)
+	return false
+}
+
+// notifyGuardedEmail posts a skipped email to the notify channel of the guard config
+func (a *Agent) notifyGuardedEmail(email mail.Email, result guard.Result) {
+	channel := a.Config.Guard.NotifyChannel
+	if channel == "" || a.slackClient == nil {
+		return
+	}
+	message := fmt.Sprintf("Email %s from %s was %s by the guard: %s\nSubject: %s",
+		email.Id, emailSender(email), guardVerb(result.Action), result.Reason, email.Subject)
+	if result.Action == guard.ACTIONQUARANTINE && a.Config.Guard.QuarantineDir != "" {
+		message += "\nIt is saved in " + a.Config.Guard.QuarantineDir + " for review."
+	}
+	if _, err := a.slackClient.PostInChannel(channel, message); err != nil {
+		log.Printf("Failed to notify guarded email %s: %v", email.Id, err)
+	}
+}
+
+func guardVerb(action string) string {
+	if action == guard.ACTIONREJECT {
+		return "rejected"
+	}
+	return "quarantined"
+}
+
+func emailSender(email mail.Email) string {
+	if email.From == nil {
+		return ""
+	}
+	return email.From.Address
+}
+
+// GuardedEmail returns the subject and text of an email wrapped as untrusted content with
+// guard.Wrap, to pass it to a prompt whose system prompt has guard.UntrustedInstructions
+func GuardedEmail(email mail.Email) string {
+	return guard.Wrap(guard.SOURCEEMAIL, "Subject: "+email.Subject+"\n\n"+email.Text())
+}
+
+// EmailMessages returns the messages of a chat about an email: the system prompt, followed
+// by guard.UntrustedInstructions, and the email wrapped by GuardedEmail as the user message
+func EmailMessages(systemPrompt string, email mail.Email) []gpt.GPTmessage {
+	var messages []gpt.GPTmessage
+	if systemPrompt != "" {
+		messages = append(messages, gpt.GPTmessage{Role: gpt.SYSTEMROLE, Content: systemPrompt})
+	}
+	return append(messages,
+		gpt.GPTmessage{Role: gpt.SYSTEMROLE, Content: guard.UntrustedInstructions},
+		gpt.GPTmessage{Role: gpt.USERROLE, Content: GuardedEmail(email)})
+}
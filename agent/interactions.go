@@ -0,0 +1,35 @@
+package agent
+
+import (
+	"github.com/vtuson/slackagent/slack"
+)
+
+// Interactions returns the router of the Block Kit interactions of the Slack client.
+// Handlers can be registered before InitializeSlackClient.
+func (a *Agent) Interactions() *slack.InteractionRouter {
+	a.interactionsOnce.Do(func() {
+		a.interactions = slack.NewInteractionRouter()
+	})
+	return a.interactions
+}
+
+// OnAction handles the block actions with actionID, e.g. slack.ACTIONAPPROVE of the
+// buttons from slack.ApprovalButtons
+func (a *Agent) OnAction(actionID string, handler slack.InteractionHandler) {
+	a.Interactions().OnAction(actionID, handler)
+}
+
+// OnViewSubmission handles the submissions of the modals with callbackID
+func (a *Agent) OnViewSubmission(callbackID string, handler slack.InteractionHandler) {
+	a.Interactions().OnViewSubmission(callbackID, handler)
+}
+
+// OnViewClosed handles the modals with callbackID closed by the user
+func (a *Agent) OnViewClosed(callbackID string, handler slack.InteractionHandler) {
+	a.Interactions().OnViewClosed(callbackID, handler)
+}
+
+// OnShortcut handles the global and message shortcuts with callbackID
+func (a *Agent) OnShortcut(callbackID string, handler slack.InteractionHandler) {
+	a.Interactions().OnShortcut(callbackID, handler)
+}
@@ -11,6 +11,7 @@ import (
 	"sync"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/vtuson/slackagent/cassette"
 )
 
 // MCPConnectionMethod identifies how to connect to an MCP server.
@@ -42,12 +43,27 @@ type MCPOptions struct {
 	Args       []string
 	Env        []string
 	HTTPClient *http.Client
+
+	// Transport, when set, is used as-is and Method is ignored.
+	// Useful to connect to an in-process server via mcp.NewInMemoryTransports.
+	Transport mcp.Transport
+
+	// Cassette, when set, records the tool calls of the session or, in replay mode,
+	// answers them without connecting to the server.
+	Cassette *cassette.Cassette
+}
+
+// mcpSession is the part of *mcp.ClientSession used by MCPClient.
+type mcpSession interface {
+	ListTools(ctx context.Context, params *mcp.ListToolsParams) (*mcp.ListToolsResult, error)
+	CallTool(ctx context.Context, params *mcp.CallToolParams) (*mcp.CallToolResult, error)
+	Close() error
 }
 
 // MCPClient wraps an MCP client session and provides convenience helpers.
 type MCPClient struct {
 	client  *mcp.Client
-	session *mcp.ClientSession
+	session mcpSession
 	mu      sync.RWMutex
 }
 
@@ -61,11 +77,67 @@ func ConnectMCP(ctx context.Context, opts MCPOptions) (*MCPClient, error) {
 		log.Println("implementation version is empty, using default")
 	}
 
+	if opts.Cassette != nil && !opts.Cassette.Recording() {
+		return &MCPClient{session: &cassetteSession{cassette: opts.Cassette}}, nil
+	}
+
 	client := mcp.NewClient(&mcp.Implementation{
 		Name:    opts.ImplementationName,
 		Version: opts.ImplementationVersion,
 	}, nil)
 
+	transport := opts.Transport
+	if transport == nil {
+		var err error
+		transport, err = newTransport(opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	session, err := client.Connect(ctx, transport)
+	if err != nil {
+		return nil, fmt.Errorf("connect MCP: %w", err)
+	}
+	if opts.Cassette != nil {
+		return &MCPClient{client: client, session: &cassetteSession{cassette: opts.Cassette, session: session}}, nil
+	}
+
+	return &MCPClient{client: client, session: session}, nil
+}
+
+// cassetteSession records the calls made on session to the cassette, or replays them
+// when session is nil.
+type cassetteSession struct {
+	cassette *cassette.Cassette
+	session  *mcp.ClientSession
+}
+
+func (s *cassetteSession) ListTools(ctx context.Context, params *mcp.ListToolsParams) (*mcp.ListToolsResult, error) {
+	var res *mcp.ListToolsResult
+	err := s.cassette.Call("tools/list", params, &res, func() (interface{}, error) {
+		return s.session.ListTools(ctx, params)
+	})
+	return res, err
+}
+
+func (s *cassetteSession) CallTool(ctx context.Context, params *mcp.CallToolParams) (*mcp.CallToolResult, error) {
+	var res *mcp.CallToolResult
+	err := s.cassette.Call("tools/call", params, &res, func() (interface{}, error) {
+		return s.session.CallTool(ctx, params)
+	})
+	return res, err
+}
+
+func (s *cassetteSession) Close() error {
+	if s.session == nil {
+		return nil
+	}
+	return s.session.Close()
+}
+
+// newTransport builds the client transport for the selected connection method.
+func newTransport(opts MCPOptions) (mcp.Transport, error) {
 	var transport mcp.Transport
 
 	switch opts.Method {
@@ -101,12 +173,7 @@ func ConnectMCP(ctx context.Context, opts MCPOptions) (*MCPClient, error) {
 		return nil, fmt.Errorf("unsupported MCP connection method: %q", string(opts.Method))
 	}
 
-	session, err := client.Connect(ctx, transport)
-	if err != nil {
-		return nil, fmt.Errorf("connect MCP: %w", err)
-	}
-
-	return &MCPClient{client: client, session: session}, nil
+	return transport, nil
 }
 
 // Close closes the underlying MCP session and releases resources.
@@ -0,0 +1,60 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+	"github.com/vtuson/slackagent/gpt"
+	"github.com/vtuson/slackagent/prompts"
+	"github.com/vtuson/slackagent/slack"
+)
+
+// PromptsConfig loads the prompt templates of a directory, see the prompts package
+type PromptsConfig struct {
+	Dir string `yaml:"dir"`
+	// Reload is how often, in seconds, the files are checked for changes, -1 disables it
+	Reload int `yaml:"reload,omitempty"`
+}
+
+// loadPrompts loads the prompts library and watches it for changes until shutdown
+func (a *Agent) loadPrompts(config *PromptsConfig) error {
+	library, err := prompts.NewLibrary(config.Dir)
+	if err != nil {
+		return err
+	}
+	a.Prompts = library
+	if config.Reload >= 0 {
+		go library.Watch(a.Context(), time.Duration(config.Reload)*time.Second)
+	}
+	return nil
+}
+
+// PromptVars returns the template variables of a Slack event: the display name of the
+// user, the channel name and the current date
+func (a *Agent) PromptVars(event interface{}) prompts.Vars {
+	vars := prompts.Vars{Date: time.Now()}
+	var user, channel string
+	switch ev := event.(type) {
+	case *slackevents.AppMentionEvent:
+		user, channel = ev.User, ev.Channel
+	case *slackevents.MessageEvent:
+		user, channel = ev.User, ev.Channel
+	case *slack.Interaction:
+		user, channel = ev.User.ID, ev.Channel.ID
+	case *slack.Command:
+		user, channel = ev.UserID, ev.ChannelID
+	}
+	vars.User, vars.Channel = user, channel
+	if a.slackClient != nil {
+		vars.User = a.slackClient.UserNameContext(a.Context(), user)
+		vars.Channel = a.slackClient.ChannelNameContext(a.Context(), channel)
+	}
+	return vars
+}
+
+// Prompt resolves a prompt referenced from agent_config: "prompt:<name>" renders the named
+// template with the variables and returns the options of its front-matter, any other
+// value is a literal prompt returned as is with nil options
+func (a *Agent) Prompt(value string, vars prompts.Vars) (string, *gpt.ChatOptions, error) {
+	return a.Prompts.Resolve(value, vars)
+}
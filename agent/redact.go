@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/vtuson/slackagent/gpt"
+)
+
+// RedactionConfig masks personal data before it is sent to the LLM provider
+type RedactionConfig struct {
+	// Salt keeps placeholders stable across restarts, random when empty
+	Salt string `yaml:"salt,omitempty"`
+	// Patterns are extra regular expressions to mask, by placeholder name
+	Patterns map[string]string `yaml:"patterns,omitempty"`
+	// KeepPlaceholders leaves the placeholders in replies instead of restoring the values
+	KeepPlaceholders bool `yaml:"keep_placeholders,omitempty"`
+}
+
+// newRedactor creates the redactor of the config
+func newRedactor(config *RedactionConfig) (*gpt.Redactor, error) {
+	redactor := gpt.NewRedactor(config.Salt)
+	for name, pattern := range config.Patterns {
+		if err := redactor.AddRule(name, pattern); err != nil {
+			return nil, err
+		}
+	}
+	return redactor, nil
+}
+
+// withRedaction wraps the LLM with the redactor, if configured
+func (a *Agent) withRedaction(llm gpt.LLM) gpt.LLM {
+	if a.Redactor == nil {
+		return llm
+	}
+	redacting := gpt.NewRedactingLLM(llm, a.Redactor)
+	redacting.KeepPlaceholders = a.Config.Redaction.KeepPlaceholders
+	return redacting
+}
+
+// redactedModerator masks personal data before text is sent to the moderation endpoint
+type redactedModerator struct {
+	gpt.Moderator
+	redactor *gpt.Redactor
+}
+
+func (m redactedModerator) Moderate(text string) (*gpt.Moderation, error) {
+	return m.ModerateContext(context.Background(), text)
+}
+
+func (m redactedModerator) ModerateContext(ctx context.Context, text string) (*gpt.Moderation, error) {
+	return m.Moderator.ModerateContext(ctx, m.redactor.Redact(text))
+}
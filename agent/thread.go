@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	goslack "github.com/slack-go/slack"
+	"github.com/vtuson/slackagent/gpt"
+)
+
+// ThreadOptions configures ThreadConversation
+type ThreadOptions struct {
+	// SystemPrompt is prepended as a system turn when set
+	SystemPrompt string
+	// Model selects the tokenizer and context window, defaults to the configured model
+	Model string
+	// MaxTokens is the token budget of the whole conversation, defaults to the context
+	// window of the model. The oldest turns are dropped (or summarized) until it fits.
+	MaxTokens int
+	// Strategy is a gpt.TRUNCATE* strategy, defaults to gpt.TRUNCATEOLDEST
+	Strategy string
+	// Summarizer writes the summary of the gpt.TRUNCATESUMMARIZE strategy
+	Summarizer gpt.LLM
+	// Images attaches the images shared by users to their turns, for vision models
+	Images bool
+}
+
+// ThreadConversation fetches a thread and turns it into a conversation for the LLM.
+// The bot's own messages become assistant turns, others become user turns prefixed with
+// the display name of their author.
+func (a *Agent) ThreadConversation(ctx context.Context, channel string, threadTimeStamp string, opts *ThreadOptions) ([]gpt.GPTmessage, error) {
+	msgs, err := a.slackClient.GetThreadMessagesContext(ctx, channel, threadTimeStamp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch thread: %w", err)
+	}
+	if opts == nil {
+		opts = &ThreadOptions{}
+	}
+
+	var conversation []gpt.GPTmessage
+	if opts.SystemPrompt != "" {
+		conversation = append(conversation, gpt.GPTmessage{Role: gpt.SYSTEMROLE, Content: opts.SystemPrompt})
+	}
+	conversation = append(conversation, a.threadMessages(ctx, msgs, opts.Images)...)
+
+	model := opts.Model
+	if model == "" && a.Config.GPT != nil {
+		model = a.Config.GPT.Model
+	}
+	return gpt.FitMessages(ctx, conversation, &gpt.TruncateOptions{
+		Model:      model,
+		MaxTokens:  opts.MaxTokens,
+		Strategy:   opts.Strategy,
+		Summarizer: opts.Summarizer,
+	})
+}
+
+// threadMessages converts Slack messages into conversation turns
+func (a *Agent) threadMessages(ctx context.Context, msgs []goslack.Message, images bool) []gpt.GPTmessage {
+	var turns []gpt.GPTmessage
+	for _, msg := range msgs {
+		text := a.slackClient.PlainTextContext(ctx, msg.Text)
+		if files := summarizeFiles(msg); files != "" {
+			text = strings.TrimSpace(text + "\n" + files)
+		}
+		if text == "" {
+			continue
+		}
+
+		if a.slackClient.IsOwnMessage(msg) {
+			turns = append(turns, gpt.GPTmessage{Role: gpt.ASSISTANTROLE, Content: text})
+			continue
+		}
+		name := msg.Username
+		if msg.User != "" {
+			name = a.slackClient.UserNameContext(ctx, msg.User)
+		}
+		if name != "" {
+			text = name + ": " + text
+		}
+		turn := gpt.GPTmessage{Role: gpt.USERROLE, Content: text}
+		if images {
+			turn.Parts = a.ImageParts(ctx, msg.Files)
+		}
+		turns = append(turns, turn)
+	}
+	return turns
+}
+
+// summarizeFiles describes the files and attachments of a message, which the model can't see
+func summarizeFiles(msg goslack.Message) string {
+	var parts []string
+	for _, f := range msg.Files {
+		name := f.Title
+		if name == "" {
+			name = f.Name
+		}
+		kind := f.PrettyType
+		if kind == "" {
+			kind = f.Filetype
+		}
+		parts = append(parts, fmt.Sprintf("[file: %s (%s)]", name, kind))
+	}
+	for _, att := range msg.Attachments {
+		text := att.Title
+		if att.Text != "" {
+			text = strings.TrimSpace(text + " " + att.Text)
+		}
+		if text == "" {
+			text = att.Fallback
+		}
+		if text != "" {
+			parts = append(parts, "[attachment: "+text+"]")
+		}
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	goslack "github.com/slack-go/slack"
+	"github.com/vtuson/slackagent/gpt"
+	"github.com/vtuson/slackagent/slack"
+)
+
+// threadReplies is the conversations.replies answer of the fake Slack server
+const threadReplies = `{"ok":true,"has_more":false,"messages":[
+	{"type":"message","user":"U1","ts":"1.1","text":"<@UBOT> what's the weather in Paris?"},
+	{"type":"message","user":"UBOT","bot_id":"BBOT","ts":"1.2","text":"Sunny, 25 degrees."},
+	{"type":"message","bot_id":"BHOOK","username":"alerts","ts":"1.3","text":"Storm warning &amp; rain"},
+	{"type":"message","user":"U2","ts":"1.4","text":"<@U1> see <https://example.com/w|the forecast>",
+		"files":[{"id":"F1","name":"radar.png","title":"Radar","pretty_type":"PNG"}]},
+	{"type":"message","user":"U1","ts":"1.5","text":"<@UBOT>"},
+	{"type":"message","user":"U1","ts":"1.6","text":"<@UBOT> should I take an umbrella?"}
+]}`
+
+// newThreadAgent returns an agent whose Slack client talks to a fake server serving one thread
+func newThreadAgent(t *testing.T) *Agent {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/auth.test":
+			w.Write([]byte(`{"ok":true,"user_id":"UBOT","bot_id":"BBOT"}`))
+		case "/conversations.replies":
+			if r.Form.Get("channel") != "C1" || r.Form.Get("ts") != "1.1" {
+				t.Errorf("replies of %s/%s", r.Form.Get("channel"), r.Form.Get("ts"))
+			}
+			w.Write([]byte(threadReplies))
+		case "/users.info":
+			names := map[string]string{"U1": "alice", "U2": "bob"}
+			w.Write([]byte(`{"ok":true,"user":{"id":"` + r.Form.Get("user") + `","profile":{"display_name":"` + names[r.Form.Get("user")] + `"}}}`))
+		default:
+			t.Errorf("unexpected call to %s", r.URL.Path)
+		}
+	}))
+	t.Cleanup(server.Close)
+	api := goslack.New("xoxb-test", goslack.OptionAPIURL(server.URL+"/"))
+	return &Agent{Config: &Config{}, slackClient: slack.NewWithAPI(api, "C1")}
+}
+
+func TestThreadConversationMapsRolesAndNames(t *testing.T) {
+	a := newThreadAgent(t)
+	conversation, err := a.ThreadConversation(context.Background(), "C1", "1.1", &ThreadOptions{SystemPrompt: "Be brief.", Model: "gpt-4o"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []gpt.GPTmessage{
+		{Role: gpt.SYSTEMROLE, Content: "Be brief."},
+		{Role: gpt.USERROLE, Content: "alice: what's the weather in Paris?"},
+		{Role: gpt.ASSISTANTROLE, Content: "Sunny, 25 degrees."},
+		{Role: gpt.USERROLE, Content: "alerts: Storm warning & rain"},
+		{Role: gpt.USERROLE, Content: "bob: @alice see the forecast (https://example.com/w)\n[file: Radar (PNG)]"},
+		{Role: gpt.USERROLE, Content: "alice: should I take an umbrella?"},
+	}
+	if len(conversation) != len(want) {
+		t.Fatalf("conversation %+v, want %d turns", conversation, len(want))
+	}
+	for i := range want {
+		if conversation[i].Role != want[i].Role || conversation[i].Content != want[i].Content {
+			t.Errorf("turn %d = %s %q, want %s %q", i, conversation[i].Role, conversation[i].Content, want[i].Role, want[i].Content)
+		}
+	}
+}
+
+func TestThreadConversationFitsTheBudget(t *testing.T) {
+	a := newThreadAgent(t)
+	full, err := a.ThreadConversation(context.Background(), "C1", "1.1", &ThreadOptions{SystemPrompt: "Be brief.", Model: "gpt-4o"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	budget := gpt.CountMessageTokens("gpt-4o", append([]gpt.GPTmessage{full[0]}, full[len(full)-2:]...))
+	fitted, err := a.ThreadConversation(context.Background(), "C1", "1.1", &ThreadOptions{SystemPrompt: "Be brief.", Model: "gpt-4o", MaxTokens: budget})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fitted) >= len(full) || len(fitted) < 3 {
+		t.Fatalf("fitted %d of %d turns", len(fitted), len(full))
+	}
+	if gpt.CountMessageTokens("gpt-4o", fitted) > budget {
+		t.Errorf("fitted conversation exceeds %d tokens", budget)
+	}
+	if fitted[0].Role != gpt.SYSTEMROLE {
+		t.Errorf("first turn %+v, want the system prompt", fitted[0])
+	}
+	// the oldest turns are dropped, the rest is kept in order
+	offset := len(full) - len(fitted)
+	for i := 1; i < len(fitted); i++ {
+		if fitted[i].Content != full[i+offset].Content {
+			t.Errorf("turn %d = %q, want %q", i, fitted[i].Content, full[i+offset].Content)
+		}
+	}
+}
+
+func TestThreadConversationStopsWithTheContext(t *testing.T) {
+	a := newThreadAgent(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := a.ThreadConversation(ctx, "C1", "1.1", nil); err == nil {
+		t.Error("fetched the thread with a cancelled context")
+	}
+}
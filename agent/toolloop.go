@@ -0,0 +1,151 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/vtuson/slackagent/gpt"
+	"github.com/vtuson/slackagent/guard"
+)
+
+const (
+	// DefaultMaxToolSteps is the number of model round trips allowed when no budget is given.
+	DefaultMaxToolSteps = 8
+)
+
+// ErrMaxToolSteps is returned when the model keeps requesting tools past the step budget.
+var ErrMaxToolSteps = errors.New("tool loop exceeded maximum steps")
+
+// ToolLoop runs the "ask model, run tool, feed result back" cycle between an LLM and an MCP server.
+type ToolLoop struct {
+	LLM gpt.LLM
+	MCP *MCPClient
+	// MaxSteps bounds the number of model calls. Defaults to DefaultMaxToolSteps.
+	MaxSteps int
+	// Guard checks tool outputs before they are fed back to the model. Allowed outputs are
+	// wrapped in untrusted delimiters, others are replaced with the reason.
+	Guard *guard.Guard
+}
+
+// MCPToolsToGPT converts MCP tool definitions into function tools for the chat completion API.
+func MCPToolsToGPT(tools []*mcp.Tool) ([]gpt.Tool, error) {
+	out := make([]gpt.Tool, 0, len(tools))
+	for _, t := range tools {
+		params := json.RawMessage(`{"type":"object","properties":{}}`)
+		if t.InputSchema != nil {
+			data, err := json.Marshal(t.InputSchema)
+			if err != nil {
+				return nil, fmt.Errorf("marshal schema for tool %q: %w", t.Name, err)
+			}
+			params = data
+		}
+		out = append(out, gpt.NewFunctionTool(t.Name, t.Description, params))
+	}
+	return out, nil
+}
+
+// Run asks the model to answer the prompt using the MCP tools and returns its final answer.
+func (l *ToolLoop) Run(ctx context.Context, systemPrompt string, message string) (string, error) {
+	messages := []gpt.GPTmessage{
+		{Role: gpt.SYSTEMROLE, Content: systemPrompt},
+		{Role: gpt.USERROLE, Content: message},
+	}
+	return l.RunMessages(ctx, messages)
+}
+
+// RunMessages continues the given conversation, executing requested tool calls until the
+// model replies without tool calls or the step budget is exhausted.
+func (l *ToolLoop) RunMessages(ctx context.Context, messages []gpt.GPTmessage) (string, error) {
+	if l.LLM == nil {
+		return "", errors.New("tool loop has no LLM")
+	}
+	if l.MCP == nil {
+		return "", errors.New("tool loop has no MCP client")
+	}
+	maxSteps := l.MaxSteps
+	if maxSteps <= 0 {
+		maxSteps = DefaultMaxToolSteps
+	}
+
+	mcpTools, err := l.MCP.ListTools(ctx)
+	if err != nil {
+		return "", err
+	}
+	tools, err := MCPToolsToGPT(mcpTools)
+	if err != nil {
+		return "", err
+	}
+	if l.Guard != nil {
+		messages = append([]gpt.GPTmessage{{Role: gpt.SYSTEMROLE, Content: guard.UntrustedInstructions}}, messages...)
+	}
+
+	for step := 0; step < maxSteps; step++ {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		reply, err := l.LLM.ChatContext(ctx, messages, &gpt.ChatOptions{Tools: tools})
+		if err != nil {
+			return "", err
+		}
+		if len(reply.ToolCalls) == 0 {
+			return reply.Content, nil
+		}
+
+		messages = append(messages, reply)
+		for _, call := range reply.ToolCalls {
+			log.Printf("tool loop step %d: calling tool %s", step+1, call.Function.Name)
+			messages = append(messages, gpt.ToolResultMessage(call.ID, l.callTool(ctx, call)))
+		}
+	}
+	return "", ErrMaxToolSteps
+}
+
+// callTool executes a tool call and renders its outcome as text for the model.
+// Failures are reported back to the model instead of aborting the loop.
+func (l *ToolLoop) callTool(ctx context.Context, call gpt.ToolCall) string {
+	text := l.runTool(ctx, call)
+	if l.Guard == nil {
+		return text
+	}
+	result := l.Guard.Check(WithUsageProcessor(ctx, "guard"), guard.SOURCETOOL, text)
+	if !result.Allowed() {
+		return "error: the output of " + call.Function.Name + " was withheld: " + result.Reason
+	}
+	return guard.Wrap(call.Function.Name, text)
+}
+
+func (l *ToolLoop) runTool(ctx context.Context, call gpt.ToolCall) string {
+	args, err := call.ParseArguments()
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	res, err := l.MCP.CallTool(ctx, call.Function.Name, args)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	text := strings.Join(ExtractTextResponses(res), "\n")
+	if res.IsError {
+		return "error: " + text
+	}
+	return text
+}
+
+// NewToolLoop returns a tool loop wired to the agent's LLM and MCP client.
+func (a *Agent) NewToolLoop() (*ToolLoop, error) {
+	if a.MCPClient == nil {
+		return nil, errors.New("agent has no MCP client")
+	}
+	if a.Config == nil || a.Config.GPT == nil {
+		return nil, errors.New("gpt configuration is required for the tool loop")
+	}
+	loop := &ToolLoop{LLM: a.NewLLM(), MCP: a.MCPClient, MaxSteps: DefaultMaxToolSteps}
+	if a.guards(guard.SOURCETOOL) {
+		loop.Guard = a.Guard
+	}
+	return loop, nil
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/vtuson/slackagent/gpt"
+	"github.com/vtuson/slackagent/gpt/gpttest"
+)
+
+type weatherArgs struct {
+	City string `json:"city"`
+}
+
+// connectWeatherServer connects an MCP client to an in-process server with a weather tool
+func connectWeatherServer(t *testing.T) (*MCPClient, *int) {
+	t.Helper()
+	calls := 0
+	server := mcp.NewServer(&mcp.Implementation{Name: "weather", Version: "v1.0.0"}, nil)
+	mcp.AddTool(server, &mcp.Tool{Name: "weather", Description: "current weather of a city"},
+		func(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[weatherArgs]) (*mcp.CallToolResultFor[any], error) {
+			calls++
+			return &mcp.CallToolResultFor[any]{Content: []mcp.Content{&mcp.TextContent{Text: "sunny in " + params.Arguments.City}}}, nil
+		})
+
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	session, err := server.Connect(context.Background(), serverTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { session.Close() })
+	client, err := ConnectMCP(context.Background(), MCPOptions{ImplementationName: "test", Transport: clientTransport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, &calls
+}
+
+func TestToolLoopRunsToolsAndFeedsResultsBack(t *testing.T) {
+	client, calls := connectWeatherServer(t)
+	s := gpttest.NewServer(t)
+	s.Reply(gpttest.ToolCall("weather", weatherArgs{City: "Paris"}), gpttest.Text("It is sunny in Paris."))
+
+	loop := &ToolLoop{LLM: s.Client("gpt-4o"), MCP: client}
+	answer, err := loop.Run(context.Background(), "You answer weather questions.", "Weather in Paris?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != "It is sunny in Paris." {
+		t.Errorf("answer %q", answer)
+	}
+	if *calls != 1 {
+		t.Errorf("tool called %d times, want 1", *calls)
+	}
+	s.AssertChatCalls(t, 2)
+	s.AssertTool(t, "weather")
+	last := s.LastChatRequest(t).LastMessage()
+	if last.Role != gpt.TOOLROLE || last.ToolCallID != "call_weather" || last.Content != "sunny in Paris" {
+		t.Errorf("tool result sent as %+v", last)
+	}
+}
+
+func TestToolLoopStopsAtMaxSteps(t *testing.T) {
+	client, calls := connectWeatherServer(t)
+	s := gpttest.NewServer(t)
+	call := gpttest.ToolCall("weather", weatherArgs{City: "Paris"})
+	s.Reply(call, call, call)
+
+	loop := &ToolLoop{LLM: s.Client("gpt-4o"), MCP: client, MaxSteps: 2}
+	if _, err := loop.Run(context.Background(), "", "Weather in Paris?"); !errors.Is(err, ErrMaxToolSteps) {
+		t.Fatalf("Run = %v, want ErrMaxToolSteps", err)
+	}
+	s.AssertChatCalls(t, 2)
+	if *calls != 2 {
+		t.Errorf("tool called %d times, want 2", *calls)
+	}
+}
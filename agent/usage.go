@@ -0,0 +1,473 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+	"github.com/vtuson/slackagent/gpt"
+	"github.com/vtuson/slackagent/slack"
+)
+
+const (
+	// BudgetExceededMessage is the polite refusal posted when a spend budget is exhausted
+	BudgetExceededMessage = "I've reached my usage budget for now, please try again later."
+	// DefaultUsageFile is where usage is persisted when the usage config sets no file
+	DefaultUsageFile = "usage.json"
+	// DefaultUsageRetentionDays is how long daily usage is kept for reports
+	DefaultUsageRetentionDays = 93
+	// DefaultUsageFlushInterval is how often recorded usage is written to the file
+	DefaultUsageFlushInterval = 30 * time.Second
+
+	PERIODDAY   = "day"
+	PERIODMONTH = "month"
+
+	// usage keys, totals are kept per key and per UTC day
+	usageTotalKey     = "total"
+	usageUserKey      = "user:"
+	usageChannelKey   = "channel:"
+	usageProcessorKey = "processor:"
+	usageModelKey     = "model:"
+
+	usageDayFormat = "2006-01-02"
+)
+
+// UsageScope attributes LLM calls to a Slack user, channel and processor. EventContext
+// names the processor after the handler, e.g. "slack:mention" or "command:/ask".
+type UsageScope struct {
+	User      string
+	Channel   string
+	Processor string
+}
+
+type usageScopeKey struct{}
+
+// WithUsageScope returns a context whose LLM calls are attributed to the scope
+func WithUsageScope(ctx context.Context, scope UsageScope) context.Context {
+	return context.WithValue(ctx, usageScopeKey{}, scope)
+}
+
+// WithUsageProcessor returns a context whose LLM calls are attributed to processor, keeping
+// the user and channel of the scope already attached, e.g. to tell a summary from a reply
+func WithUsageProcessor(ctx context.Context, processor string) context.Context {
+	scope := UsageScopeFrom(ctx)
+	scope.Processor = processor
+	return WithUsageScope(ctx, scope)
+}
+
+// UsageScopeFrom returns the scope attached to the context, if any
+func UsageScopeFrom(ctx context.Context) UsageScope {
+	scope, _ := ctx.Value(usageScopeKey{}).(UsageScope)
+	return scope
+}
+
+// UsageTotals aggregates the usage of a key over a period
+type UsageTotals struct {
+	Calls            int     `json:"calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+func (t *UsageTotals) add(other UsageTotals) {
+	t.Calls += other.Calls
+	t.PromptTokens += other.PromptTokens
+	t.CompletionTokens += other.CompletionTokens
+	t.Cost += other.Cost
+}
+
+// Budgets are spend limits in USD, zero means unlimited
+type Budgets struct {
+	UserDaily      float64 `yaml:"user_daily,omitempty"`
+	UserMonthly    float64 `yaml:"user_monthly,omitempty"`
+	ChannelDaily   float64 `yaml:"channel_daily,omitempty"`
+	ChannelMonthly float64 `yaml:"channel_monthly,omitempty"`
+	TotalDaily     float64 `yaml:"total_daily,omitempty"`
+	TotalMonthly   float64 `yaml:"total_monthly,omitempty"`
+}
+
+// BudgetError is returned by LLM calls refused because a budget is exhausted
+type BudgetError struct {
+	// Scope is the exhausted key, e.g. "user:U123", "channel:C123" or "total"
+	Scope  string
+	Period string
+	Limit  float64
+	Spent  float64
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%s %s budget of $%.2f exceeded ($%.2f spent)", e.Scope, e.Period, e.Limit, e.Spent)
+}
+
+type budgetCheck struct {
+	key            string
+	daily, monthly float64
+}
+
+// UsageTracker records the usage of LLM calls per user, channel, processor and model,
+// persists it to a JSON file and enforces the budgets. It implements gpt.UsageRecorder
+// and gpt.UsageLimiter, so it can be set as ProviderConfig.Usage. Recorded usage is only
+// written by Save, Flush or StartFlushing, so calls never wait for the file.
+type UsageTracker struct {
+	mu            sync.Mutex
+	saveMu        sync.Mutex
+	filePath      string
+	budgets       Budgets
+	retentionDays int
+	dirty         bool
+	// unpriced are the models already reported as missing from gpt.ModelPrices
+	unpriced map[string]bool
+	// Days maps a UTC day to the totals of every key on that day
+	Days        map[string]map[string]*UsageTotals `json:"days"`
+	LastUpdated string                             `json:"last_updated"`
+}
+
+// NewUsageTracker creates a tracker persisted to filePath, an empty path keeps usage in memory
+func NewUsageTracker(filePath string, budgets Budgets) *UsageTracker {
+	return &UsageTracker{
+		filePath:      filePath,
+		budgets:       budgets,
+		retentionDays: DefaultUsageRetentionDays,
+		unpriced:      make(map[string]bool),
+		Days:          make(map[string]map[string]*UsageTotals),
+	}
+}
+
+// SetRetentionDays sets how many days of usage are kept
+func (u *UsageTracker) SetRetentionDays(days int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.retentionDays = days
+}
+
+// Load loads usage from file
+func (u *UsageTracker) Load() error {
+	if u.filePath == "" {
+		return nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	data, err := os.ReadFile(u.filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Printf("Usage file does not exist, starting fresh")
+			return nil
+		}
+		return fmt.Errorf("failed to read usage file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, u); err != nil {
+		return fmt.Errorf("failed to unmarshal usage: %w", err)
+	}
+	if u.Days == nil {
+		u.Days = make(map[string]map[string]*UsageTotals)
+	}
+	return nil
+}
+
+// Save persists usage to file. The totals are copied under the lock and written after it,
+// so recording is not held up by the file.
+func (u *UsageTracker) Save() error {
+	if u.filePath == "" {
+		return nil
+	}
+	u.saveMu.Lock()
+	defer u.saveMu.Unlock()
+
+	u.mu.Lock()
+	u.LastUpdated = time.Now().Format(time.RFC3339)
+	data, err := json.MarshalIndent(u, "", "  ")
+	u.dirty = false
+	u.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage: %w", err)
+	}
+
+	if err := u.write(data); err != nil {
+		u.mu.Lock()
+		u.dirty = true
+		u.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (u *UsageTracker) write(data []byte) error {
+	// write then rename so a crash never leaves a truncated file behind
+	tmp := u.filePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write usage file: %w", err)
+	}
+	if err := os.Rename(tmp, u.filePath); err != nil {
+		return fmt.Errorf("failed to write usage file: %w", err)
+	}
+	return nil
+}
+
+// Flush saves the usage recorded since the last save, if any
+func (u *UsageTracker) Flush() error {
+	u.mu.Lock()
+	dirty := u.dirty
+	u.mu.Unlock()
+	if !dirty {
+		return nil
+	}
+	return u.Save()
+}
+
+// StartFlushing flushes recorded usage every interval until ctx is done, then flushes
+// once more. Call Flush after cancelling ctx to be sure the last calls are written.
+func (u *UsageTracker) StartFlushing(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultUsageFlushInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				if err := u.Flush(); err != nil {
+					log.Printf("Failed to save usage: %v", err)
+				}
+				return
+			}
+			if err := u.Flush(); err != nil {
+				log.Printf("Failed to save usage: %v", err)
+			}
+		}
+	}()
+}
+
+// RecordUsage adds the usage of a call to the totals of its scope, they are persisted by
+// the next flush
+func (u *UsageTracker) RecordUsage(ctx context.Context, usage gpt.Usage) {
+	scope := UsageScopeFrom(ctx)
+	totals := UsageTotals{
+		Calls:            1,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		Cost:             usage.Cost(),
+	}
+
+	keys := []string{usageTotalKey}
+	if scope.User != "" {
+		keys = append(keys, usageUserKey+scope.User)
+	}
+	if scope.Channel != "" {
+		keys = append(keys, usageChannelKey+scope.Channel)
+	}
+	if scope.Processor != "" {
+		keys = append(keys, usageProcessorKey+scope.Processor)
+	}
+	if usage.Model != "" {
+		keys = append(keys, usageModelKey+usage.Model)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if _, ok := gpt.PriceFor(usage.Model); !ok && usage.Model != "" && !u.unpriced[usage.Model] {
+		// e.g. an Azure deployment name or a local model
+		u.unpriced[usage.Model] = true
+		log.Printf("No price for model %s, its usage is recorded at $0; add it to gpt.ModelPrices", usage.Model)
+	}
+
+	now := time.Now().UTC()
+	day := now.Format(usageDayFormat)
+	if u.Days[day] == nil {
+		u.Days[day] = make(map[string]*UsageTotals)
+	}
+	for _, key := range keys {
+		if u.Days[day][key] == nil {
+			u.Days[day][key] = &UsageTotals{}
+		}
+		u.Days[day][key].add(totals)
+	}
+	u.prune(now)
+	u.dirty = true
+}
+
+// AllowUsage refuses calls with a *BudgetError once a budget of the scope is exhausted
+func (u *UsageTracker) AllowUsage(ctx context.Context) error {
+	scope := UsageScopeFrom(ctx)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	checks := []budgetCheck{{usageTotalKey, u.budgets.TotalDaily, u.budgets.TotalMonthly}}
+	if scope.User != "" {
+		checks = append(checks, budgetCheck{usageUserKey + scope.User, u.budgets.UserDaily, u.budgets.UserMonthly})
+	}
+	if scope.Channel != "" {
+		checks = append(checks, budgetCheck{usageChannelKey + scope.Channel, u.budgets.ChannelDaily, u.budgets.ChannelMonthly})
+	}
+
+	now := time.Now().UTC()
+	for _, check := range checks {
+		if check.daily > 0 {
+			if spent := u.totals(check.key, PERIODDAY, now).Cost; spent >= check.daily {
+				return &BudgetError{Scope: check.key, Period: PERIODDAY, Limit: check.daily, Spent: spent}
+			}
+		}
+		if check.monthly > 0 {
+			if spent := u.totals(check.key, PERIODMONTH, now).Cost; spent >= check.monthly {
+				return &BudgetError{Scope: check.key, Period: PERIODMONTH, Limit: check.monthly, Spent: spent}
+			}
+		}
+	}
+	return nil
+}
+
+// Totals returns the usage of a key ("total", "user:<id>", "channel:<id>", "processor:<name>"
+// or "model:<name>") in the current UTC day or month
+func (u *UsageTracker) Totals(key string, period string) UsageTotals {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.totals(key, period, time.Now().UTC())
+}
+
+// Report returns the usage of every key in the current UTC day or month
+func (u *UsageTracker) Report(period string) map[string]UsageTotals {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	report := make(map[string]UsageTotals)
+	now := time.Now().UTC()
+	for day, keys := range u.Days {
+		if !inPeriod(day, period, now) {
+			continue
+		}
+		for key, totals := range keys {
+			sum := report[key]
+			sum.add(*totals)
+			report[key] = sum
+		}
+	}
+	return report
+}
+
+// Summary formats the report of a period, most expensive keys first, e.g. to post it in Slack
+func (u *UsageTracker) Summary(period string) string {
+	report := u.Report(period)
+	keys := make([]string, 0, len(report))
+	for key := range report {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if report[keys[i]].Cost != report[keys[j]].Cost {
+			return report[keys[i]].Cost > report[keys[j]].Cost
+		}
+		return keys[i] < keys[j]
+	})
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "LLM usage this %s:\n", period)
+	for _, key := range keys {
+		t := report[key]
+		fmt.Fprintf(&sb, "%s: $%.4f, %d calls, %d prompt + %d completion tokens\n",
+			key, t.Cost, t.Calls, t.PromptTokens, t.CompletionTokens)
+	}
+	return sb.String()
+}
+
+func (u *UsageTracker) totals(key string, period string, now time.Time) UsageTotals {
+	var sum UsageTotals
+	for day, keys := range u.Days {
+		if totals, ok := keys[key]; ok && inPeriod(day, period, now) {
+			sum.add(*totals)
+		}
+	}
+	return sum
+}
+
+// prune drops the days older than the retention
+func (u *UsageTracker) prune(now time.Time) {
+	if u.retentionDays <= 0 {
+		return
+	}
+	oldest := now.AddDate(0, 0, -u.retentionDays).Format(usageDayFormat)
+	for day := range u.Days {
+		if day < oldest {
+			delete(u.Days, day)
+		}
+	}
+}
+
+func inPeriod(day string, period string, now time.Time) bool {
+	if period == PERIODMONTH {
+		return strings.HasPrefix(day, now.Format("2006-01"))
+	}
+	return day == now.Format(usageDayFormat)
+}
+
+// EventContext returns the agent context with the usage scope of a Slack event, so the
+// LLM calls made while processing it are attributed to its user, channel and handler. For
+// events, commands and interactions run by the dispatcher it carries their dispatch deadline.
+func (a *Agent) EventContext(event interface{}) context.Context {
+	ctx := a.Context()
+	scope := UsageScope{Processor: "slack"}
+	switch ev := event.(type) {
+	case *slackevents.AppMentionEvent:
+		scope.User, scope.Channel, scope.Processor = ev.User, ev.Channel, "slack:mention"
+		if dispatched, ok := a.eventContexts.Load(ev); ok {
+			ctx = dispatched.(context.Context)
+		}
+	case *slackevents.MessageEvent:
+		scope.User, scope.Channel, scope.Processor = ev.User, ev.Channel, "slack:message"
+		if dispatched, ok := a.eventContexts.Load(ev); ok {
+			ctx = dispatched.(context.Context)
+		}
+	case *slack.Interaction:
+		scope.User, scope.Channel, scope.Processor = ev.User.ID, ev.Channel.ID, interactionProcessor(ev)
+		ctx = a.requestContext(ev.Context())
+	case *slack.Command:
+		scope.User, scope.Channel, scope.Processor = ev.UserID, ev.ChannelID, "command:"+ev.Command
+		ctx = a.requestContext(ev.Context())
+	}
+	return WithUsageScope(ctx, scope)
+}
+
+// interactionProcessor names the handler of an interaction after its action or callback id
+func interactionProcessor(interaction *slack.Interaction) string {
+	switch {
+	case interaction.Action != nil:
+		return "action:" + interaction.Action.ActionID
+	case interaction.View.CallbackID != "":
+		return "view:" + interaction.View.CallbackID
+	case interaction.CallbackID != "":
+		return "shortcut:" + interaction.CallbackID
+	}
+	return "slack:interaction"
+}
+
+// checkBudget refuses events whose user or channel budget is exhausted. Mentions and
+// direct messages get a polite reply in the thread, other messages are skipped silently.
+func (a *Agent) checkBudget(event interface{}) bool {
+	if a.Usage == nil {
+		return true
+	}
+	err := a.Usage.AllowUsage(a.EventContext(event))
+	if err == nil {
+		return true
+	}
+	log.Printf("Skipping event: %v", err)
+
+	channel, threadTimeStamp, ok := replyThread(event)
+	if !ok {
+		return false
+	}
+	if _, err := a.slackClient.PostInThread(channel, BudgetExceededMessage, threadTimeStamp); err != nil {
+		log.Printf("Failed to post budget message: %v", err)
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	goslack "github.com/slack-go/slack"
+	"github.com/vtuson/slackagent/gpt"
+	"github.com/vtuson/slackagent/slack"
+)
+
+func TestUsageTrackerFlushesRecordedUsage(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "usage.json")
+	u := NewUsageTracker(file, Budgets{})
+	ctx := WithUsageScope(context.Background(), UsageScope{User: "U1", Processor: "command:/ask"})
+	u.RecordUsage(ctx, gpt.Usage{Model: "gpt-4o", PromptTokens: 1000, CompletionTokens: 100})
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("usage written on record: %v", err)
+	}
+
+	if err := u.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewUsageTracker(file, Budgets{})
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Totals(usageProcessorKey+"command:/ask", PERIODDAY); got.Calls != 1 || got.PromptTokens != 1000 {
+		t.Errorf("flushed processor totals %+v, want the call", got)
+	}
+
+	// nothing new to write
+	os.Remove(file)
+	if err := u.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("usage written without new records: %v", err)
+	}
+}
+
+func TestEventContextNamesTheProcessor(t *testing.T) {
+	a := &Agent{}
+	cmd := &slack.Command{SlashCommand: goslack.SlashCommand{Command: "/ask", UserID: "U1", ChannelID: "C1"}}
+	action := &slack.Interaction{Action: &goslack.BlockAction{ActionID: "approve"}}
+	for event, want := range map[interface{}]string{cmd: "command:/ask", action: "action:approve"} {
+		if got := UsageScopeFrom(a.EventContext(event)).Processor; got != want {
+			t.Errorf("processor %q, want %q", got, want)
+		}
+	}
+	ctx := WithUsageProcessor(a.EventContext(cmd), "summarize")
+	if scope := UsageScopeFrom(ctx); scope.Processor != "summarize" || scope.User != "U1" || scope.Channel != "C1" {
+		t.Errorf("WithUsageProcessor scope %+v, want the command user and channel", scope)
+	}
+}
@@ -0,0 +1,221 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+)
+
+// modes of a cassette
+const (
+	// MODERECORD makes live calls and records them, replacing the cassette on Save
+	MODERECORD = "record"
+	// MODEREPLAY answers from the cassette and fails on calls it has not recorded
+	MODEREPLAY = "replay"
+	// MODEAUTO replays an existing cassette and records a missing one
+	MODEAUTO = "auto"
+)
+
+// kinds of interactions
+const (
+	KINDHTTP = "http"
+	KINDCALL = "call"
+)
+
+// Redacted replaces scrubbed secrets
+const Redacted = "[REDACTED]"
+
+// ErrNoInteraction is returned in replay mode for calls missing from the cassette
+var ErrNoInteraction = errors.New("no recorded interaction")
+
+// Interaction is a recorded HTTP exchange or API call
+type Interaction struct {
+	Kind string           `json:"kind"`
+	HTTP *HTTPInteraction `json:"http,omitempty"`
+	Call *CallInteraction `json:"call,omitempty"`
+}
+
+// CallInteraction is a recorded call of an API that is not plain HTTP, e.g. an MCP tool call
+type CallInteraction struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Cassette records interactions to a JSON file and replays them in order. Tests create one
+// per scenario, pass HTTPClient to the LLM and the cassette to the MCP client, and commit
+// the file so the test runs offline.
+type Cassette struct {
+	// Scrub is called on every interaction before it is recorded, e.g. to remove secrets
+	// from bodies. Secret headers are always scrubbed, see ScrubHeaders.
+	Scrub func(*Interaction) `json:"-"`
+	// MatchHTTP overrides how a request is matched to a recorded one, defaults to
+	// DefaultHTTPMatcher
+	MatchHTTP func(request HTTPRequest, recorded HTTPRequest) bool `json:"-"`
+	// Interactions are the recorded interactions, in call order
+	Interactions []Interaction `json:"interactions"`
+
+	path      string
+	recording bool
+	mu        sync.Mutex
+	used      []bool
+}
+
+// Load opens the cassette at path in the given mode
+func Load(path string, mode string) (*Cassette, error) {
+	c := &Cassette{path: path}
+	switch mode {
+	case MODERECORD:
+		c.recording = true
+		return c, nil
+	case MODEREPLAY:
+	case MODEAUTO:
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			c.recording = true
+			return c, nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported cassette mode %q", mode)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette: %w", err)
+	}
+	c.used = make([]bool, len(c.Interactions))
+	return c, nil
+}
+
+// Recording reports whether the cassette makes live calls
+func (c *Cassette) Recording() bool {
+	return c.recording
+}
+
+// Save writes the recorded interactions. It does nothing in replay mode.
+func (c *Cassette) Save() error {
+	if !c.recording {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cassette: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("failed to create cassette dir: %w", err)
+	}
+	if err := os.WriteFile(c.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+// Unused returns the recorded interactions that were not replayed, to check a test made
+// every call it made when it was recorded
+func (c *Cassette) Unused() []Interaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var unused []Interaction
+	for i, used := range c.used {
+		if !used {
+			unused = append(unused, c.Interactions[i])
+		}
+	}
+	return unused
+}
+
+// record appends an interaction after scrubbing it
+func (c *Cassette) record(interaction Interaction) {
+	scrubHeaders(interaction.HTTP)
+	if c.Scrub != nil {
+		c.Scrub(&interaction)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Interactions = append(c.Interactions, interaction)
+}
+
+// next returns the first interaction not replayed yet that matches, and marks it used
+func (c *Cassette) next(match func(Interaction) bool) (Interaction, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, interaction := range c.Interactions {
+		if !c.used[i] && match(interaction) {
+			c.used[i] = true
+			return interaction, true
+		}
+	}
+	return Interaction{}, false
+}
+
+// Call records or replays a call of a non HTTP API. live makes the real call and returns
+// its result, which is stored as JSON; on replay the recorded result is decoded into out,
+// a pointer to the result type. Calls are matched on method and params.
+func (c *Cassette) Call(method string, params interface{}, out interface{}, live func() (interface{}, error)) error {
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to marshal params: %w", err)
+	}
+
+	if !c.recording {
+		interaction, ok := c.next(func(i Interaction) bool {
+			return i.Kind == KINDCALL && i.Call.Method == method && jsonEqual(i.Call.Params, paramsJSON)
+		})
+		if !ok {
+			return fmt.Errorf("%w for %s %s", ErrNoInteraction, method, paramsJSON)
+		}
+		if interaction.Call.Error != "" {
+			return errors.New(interaction.Call.Error)
+		}
+		if err := json.Unmarshal(interaction.Call.Result, out); err != nil {
+			return fmt.Errorf("failed to decode recorded result: %w", err)
+		}
+		return nil
+	}
+
+	result, liveErr := live()
+	call := &CallInteraction{Method: method, Params: paramsJSON}
+	if liveErr != nil {
+		call.Error = liveErr.Error()
+	} else {
+		call.Result, err = json.Marshal(result)
+		if err != nil {
+			return fmt.Errorf("failed to marshal result: %w", err)
+		}
+	}
+	c.record(Interaction{Kind: KINDCALL, Call: call})
+	if liveErr != nil {
+		return liveErr
+	}
+	// hand back the live result through out, like a replay would
+	rv := reflect.ValueOf(out)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		if value := reflect.ValueOf(result); value.IsValid() && value.Type().AssignableTo(rv.Elem().Type()) {
+			rv.Elem().Set(value)
+			return nil
+		}
+	}
+	return json.Unmarshal(call.Result, out)
+}
+
+// jsonEqual compares two JSON documents ignoring formatting and key order
+func jsonEqual(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
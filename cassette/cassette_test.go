@@ -0,0 +1,156 @@
+package cassette
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testToken  = "sk-secret-token"
+	testAPIKey = "azure-secret-key"
+)
+
+func post(t *testing.T, client *http.Client, url string, body string) (int, string, error) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	req.Header.Set("api-key", testAPIKey)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(data), nil
+}
+
+func TestRecordThenReplayHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("echo " + string(body)))
+	}))
+	path := filepath.Join(t.TempDir(), "testdata", "http.json")
+
+	recorder, err := Load(path, MODEAUTO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !recorder.Recording() {
+		t.Fatal("a missing cassette is not recorded in auto mode")
+	}
+	for _, body := range []string{`{"n": 1}`, `{"n": 2}`} {
+		if _, _, err := post(t, recorder.HTTPClient(), server.URL+"/chat", body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{testToken, testAPIKey, "session=secret"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("cassette contains the secret %q", secret)
+		}
+	}
+
+	player, err := Load(path, MODEAUTO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if player.Recording() {
+		t.Fatal("an existing cassette is recorded again in auto mode")
+	}
+	// JSON bodies match regardless of formatting, in any order
+	status, body, err := post(t, player.HTTPClient(), server.URL+"/chat", `{"n":2}`)
+	if err != nil || status != http.StatusCreated || body != `echo {"n": 2}` {
+		t.Errorf("replayed %d %q, %v", status, body, err)
+	}
+	if unused := player.Unused(); len(unused) != 1 || unused[0].HTTP.Request.Body != `{"n": 1}` {
+		t.Errorf("Unused = %+v, want the first request", unused)
+	}
+	if _, _, err := post(t, player.HTTPClient(), server.URL+"/chat", `{"n":3}`); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("unrecorded request = %v, want ErrNoInteraction", err)
+	}
+	if _, _, err := post(t, player.HTTPClient(), server.URL+"/chat", `{"n":2}`); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("request replayed twice = %v, want ErrNoInteraction", err)
+	}
+}
+
+type weather struct {
+	City string `json:"city"`
+	Sky  string `json:"sky"`
+}
+
+func TestRecordThenReplayCall(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "call.json")
+	recorder, err := Load(path, MODERECORD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got weather
+	err = recorder.Call("weather", map[string]string{"city": "Paris"}, &got, func() (interface{}, error) {
+		return weather{City: "Paris", Sky: "sunny"}, nil
+	})
+	if err != nil || got.Sky != "sunny" {
+		t.Fatalf("recorded call = %+v, %v", got, err)
+	}
+	err = recorder.Call("weather", map[string]string{"city": "Oslo"}, &got, func() (interface{}, error) {
+		return nil, errors.New("unknown city")
+	})
+	if err == nil || err.Error() != "unknown city" {
+		t.Fatalf("recorded failing call = %v", err)
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	player, err := Load(path, MODEREPLAY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := func() (interface{}, error) {
+		t.Error("live call made on replay")
+		return nil, nil
+	}
+	var replayed weather
+	if err := player.Call("weather", map[string]string{"city": "Paris"}, &replayed, live); err != nil || replayed.Sky != "sunny" {
+		t.Errorf("replayed call = %+v, %v", replayed, err)
+	}
+	if err := player.Call("weather", map[string]string{"city": "Oslo"}, &replayed, live); err == nil || err.Error() != "unknown city" {
+		t.Errorf("replayed failing call = %v, want the recorded error", err)
+	}
+	if err := player.Call("weather", map[string]string{"city": "Rome"}, &replayed, live); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("unrecorded call = %v, want ErrNoInteraction", err)
+	}
+	if unused := player.Unused(); len(unused) != 0 {
+		t.Errorf("Unused = %+v, want none", unused)
+	}
+}
+
+func TestLoadReplayNeedsTheFile(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json"), MODEREPLAY); err == nil {
+		t.Error("replaying a missing cassette succeeded")
+	}
+	if _, err := Load("cassette.json", "live"); err == nil {
+		t.Error("an unknown mode was accepted")
+	}
+}
@@ -0,0 +1,181 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// ScrubHeaders are the headers replaced with Redacted when recorded, so cassettes can be
+// committed. Match is case-insensitive.
+var ScrubHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Api-Key",
+	"X-Api-Key",
+	"Anthropic-Api-Key",
+	"OpenAI-Organization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// HTTPInteraction is a recorded HTTP request and its response
+type HTTPInteraction struct {
+	Request  HTTPRequest  `json:"request"`
+	Response HTTPResponse `json:"response"`
+}
+
+// HTTPRequest is a recorded request. Bodies that are not UTF-8 text are base64 encoded.
+type HTTPRequest struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 bool        `json:"body_base64,omitempty"`
+}
+
+// HTTPResponse is a recorded response
+type HTTPResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 bool        `json:"body_base64,omitempty"`
+}
+
+// DefaultHTTPMatcher matches requests on method and URL, and on the body when both are
+// JSON. Other bodies, e.g. multipart uploads with random boundaries, are not compared.
+func DefaultHTTPMatcher(request HTTPRequest, recorded HTTPRequest) bool {
+	if request.Method != recorded.Method || request.URL != recorded.URL {
+		return false
+	}
+	if request.BodyBase64 || recorded.BodyBase64 || !json.Valid([]byte(request.Body)) || !json.Valid([]byte(recorded.Body)) {
+		return true
+	}
+	return jsonEqual([]byte(request.Body), []byte(recorded.Body))
+}
+
+// HTTPClient returns a client whose requests go through the cassette
+func (c *Cassette) HTTPClient() *http.Client {
+	return &http.Client{Transport: c.Transport(nil)}
+}
+
+// Transport returns a round tripper recording the exchanges made with inner, defaults to
+// http.DefaultTransport, or replaying them
+func (c *Cassette) Transport(inner http.RoundTripper) http.RoundTripper {
+	if inner == nil {
+		inner = http.DefaultTransport
+	}
+	return &transport{cassette: c, inner: inner}
+}
+
+type transport struct {
+	cassette *Cassette
+	inner    http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+	}
+	request := HTTPRequest{Method: req.Method, URL: req.URL.String(), Header: req.Header.Clone()}
+	request.Body, request.BodyBase64 = encodeBody(body)
+
+	if !t.cassette.recording {
+		return t.replay(req, request)
+	}
+
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	resp, err := t.inner.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	// the whole body is read, so streamed replies are replayed at once
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	response := HTTPResponse{StatusCode: resp.StatusCode, Header: resp.Header.Clone()}
+	response.Body, response.BodyBase64 = encodeBody(respBody)
+	t.cassette.record(Interaction{Kind: KINDHTTP, HTTP: &HTTPInteraction{Request: request, Response: response}})
+
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	resp.ContentLength = int64(len(respBody))
+	return resp, nil
+}
+
+func (t *transport) replay(req *http.Request, request HTTPRequest) (*http.Response, error) {
+	match := t.cassette.MatchHTTP
+	if match == nil {
+		match = DefaultHTTPMatcher
+	}
+	interaction, ok := t.cassette.next(func(i Interaction) bool {
+		return i.Kind == KINDHTTP && match(request, i.HTTP.Request)
+	})
+	if !ok {
+		return nil, fmt.Errorf("%w for %s %s", ErrNoInteraction, request.Method, request.URL)
+	}
+	recorded := interaction.HTTP.Response
+	body, err := decodeBody(recorded.Body, recorded.BodyBase64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode recorded body: %w", err)
+	}
+	header := recorded.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// scrubHeaders replaces the secrets in the headers of an exchange
+func scrubHeaders(interaction *HTTPInteraction) {
+	if interaction == nil {
+		return
+	}
+	for _, header := range []http.Header{interaction.Request.Header, interaction.Response.Header} {
+		for name := range header {
+			for _, secret := range ScrubHeaders {
+				if strings.EqualFold(name, secret) {
+					header[name] = []string{Redacted}
+				}
+			}
+		}
+	}
+}
+
+func encodeBody(body []byte) (string, bool) {
+	if utf8.Valid(body) {
+		return string(body), false
+	}
+	return base64.StdEncoding.EncodeToString(body), true
+}
+
+func decodeBody(body string, isBase64 bool) ([]byte, error) {
+	if isBase64 {
+		return base64.StdEncoding.DecodeString(body)
+	}
+	return []byte(body), nil
+}
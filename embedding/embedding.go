@@ -88,6 +88,7 @@ type EmbeddingStore struct {
 	session     *hugot.Session
 	pipeline    *pipelines.FeatureExtractionPipeline
 	provider    EmbeddingProvider
+	redactor    *gpt.Redactor
 }
 
 // NewEmbeddingStore creates a new embedding store
@@ -276,20 +277,56 @@ func (es *EmbeddingStore) SetProvider(provider EmbeddingProvider) {
 	es.provider = provider
 }
 
+// SetRedactor masks personal data in the texts before they are embedded. Document
+// contents are stored as they are.
+func (es *EmbeddingStore) SetRedactor(redactor *gpt.Redactor) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.redactor = redactor
+}
+
+// embedder returns the provider and the redactor, which SetProvider and SetRedactor may change
+func (es *EmbeddingStore) embedder() (EmbeddingProvider, *gpt.Redactor) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.provider, es.redactor
+}
+
 // GetEmbedding generates an embedding for the given text using the configured provider
 func (es *EmbeddingStore) GetEmbedding(ctx context.Context, text string) ([]float32, error) {
-	if es.provider == nil {
+	provider, redactor := es.embedder()
+	return embed(ctx, provider, redactor, text)
+}
+
+// embed generates an embedding without locking the store, for callers holding es.mu
+func embed(ctx context.Context, provider EmbeddingProvider, redactor *gpt.Redactor, text string) ([]float32, error) {
+	if provider == nil {
 		return nil, fmt.Errorf("embedding provider not initialized")
 	}
-	return es.provider.GetEmbedding(text)
+	text = redactor.Redact(text)
+	if cp, ok := provider.(gpt.ContextEmbedder); ok {
+		return cp.GetEmbeddingContext(ctx, text)
+	}
+	return provider.GetEmbedding(text)
 }
 
 // GetEmbeddingsBatch generates embeddings for multiple texts at once using the configured provider
 func (es *EmbeddingStore) GetEmbeddingsBatch(ctx context.Context, texts []string) ([][]float32, error) {
-	if es.provider == nil {
+	provider, redactor := es.embedder()
+	if provider == nil {
 		return nil, fmt.Errorf("embedding provider not initialized")
 	}
-	return es.provider.GetEmbeddingsBatch(texts)
+	if redactor != nil {
+		redacted := make([]string, len(texts))
+		for i, text := range texts {
+			redacted[i] = redactor.Redact(text)
+		}
+		texts = redacted
+	}
+	if cp, ok := provider.(gpt.ContextEmbedder); ok {
+		return cp.GetEmbeddingsBatchContext(ctx, texts)
+	}
+	return provider.GetEmbeddingsBatch(texts)
 }
 
 // CosineSimilarity calculates the cosine similarity between two vectors
@@ -323,8 +360,8 @@ func (es *EmbeddingStore) Search(ctx context.Context, query string, topK int, th
 		return nil, nil
 	}
 
-	// Get embedding for query
-	queryEmbedding, err := es.GetEmbedding(ctx, query)
+	// Get embedding for query, es.mu is already held
+	queryEmbedding, err := embed(ctx, es.provider, es.redactor, query)
 	if err != nil {
 		return nil, err
 	}
@@ -432,6 +469,48 @@ func ChunkText(text string, chunkSize int, overlap int) []string {
 	return chunks
 }
 
+// ChunkTextTokens splits text into chunks of at most maxTokens tokens, measured with the
+// tokenizer of the embedding model, with about overlap tokens shared between chunks. Chunks
+// end on word boundaries; a single word longer than maxTokens becomes its own chunk.
+func ChunkTextTokens(text string, maxTokens int, overlap int, tokenizer gpt.Tokenizer) []string {
+	if tokenizer == nil {
+		tokenizer = gpt.EstimateTokenizer
+	}
+	if overlap >= maxTokens {
+		overlap = 0
+	}
+
+	words := strings.Fields(text)
+	// BPE tokens don't cross spaces, so word counts add up to the chunk count
+	costs := make([]int, len(words))
+	for i, w := range words {
+		costs[i] = tokenizer.CountTokens(" " + w)
+	}
+
+	chunks := make([]string, 0)
+	for start := 0; start < len(words); {
+		end, size := start+1, costs[start]
+		for end < len(words) && size+costs[end] <= maxTokens {
+			size += costs[end]
+			end++
+		}
+		chunks = append(chunks, strings.Join(words[start:end], " "))
+		if end >= len(words) {
+			break
+		}
+
+		// step back over the overlap for the next chunk
+		next, shared := end, 0
+		for next > start+1 && shared+costs[next-1] <= overlap {
+			shared += costs[next-1]
+			next--
+		}
+		start = next
+	}
+
+	return chunks
+}
+
 // ExtractLinks extracts page URLs from content based on the base URL pattern
 // baseURL should be the domain pattern to match (e.g., "https://www.notion.so/")
 func ExtractLinks(content string, baseURL string) []string {
@@ -0,0 +1,446 @@
+package gpt
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	ANTHROPICURL       = "https://api.anthropic.com/v1/messages"
+	ANTHROPICVERSION   = "2023-06-01"
+	ANTHROPICMAXTOKENS = 1024
+)
+
+// Anthropic implements LLM on top of the Anthropic Messages API
+type Anthropic struct {
+	transport
+	apiKey    string
+	model     string
+	url       string
+	maxTokens int
+	embedder  Embedder
+}
+
+// anthropicContent is a content block of a Messages API message
+type anthropicContent struct {
+	Type      string           `json:"type"`
+	Text      string           `json:"text,omitempty"`
+	ID        string           `json:"id,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Input     json.RawMessage  `json:"input,omitempty"`
+	ToolUseID string           `json:"tool_use_id,omitempty"`
+	Content   string           `json:"content,omitempty"`
+	Source    *anthropicSource `json:"source,omitempty"`
+}
+
+// anthropicSource is the source of an image block, base64 data or a URL
+type anthropicSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string             `json:"role"`
+	Content []anthropicContent `json:"content"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+func (u *anthropicUsage) toUsage(model string) Usage {
+	return Usage{Model: model, PromptTokens: u.InputTokens, CompletionTokens: u.OutputTokens}
+}
+
+type anthropicResponse struct {
+	Content    []anthropicContent `json:"content"`
+	StopReason string             `json:"stop_reason"`
+	Usage      *anthropicUsage    `json:"usage,omitempty"`
+	Error      *anthropicError    `json:"error,omitempty"`
+}
+
+// anthropicStreamEvent covers the event payloads of a streamed Messages API response
+type anthropicStreamEvent struct {
+	Type         string           `json:"type"`
+	Index        int              `json:"index"`
+	ContentBlock anthropicContent `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+	} `json:"delta"`
+	// message_start carries the prompt usage, message_delta the output tokens so far
+	Message struct {
+		Usage *anthropicUsage `json:"usage"`
+	} `json:"message"`
+	Usage *anthropicUsage `json:"usage"`
+	Error *anthropicError `json:"error,omitempty"`
+}
+
+// NewAnthropic creates an Anthropic client for the given key and model
+func NewAnthropic(key string, model string) *Anthropic {
+	return &Anthropic{apiKey: key, model: model, maxTokens: ANTHROPICMAXTOKENS}
+}
+
+func (a *Anthropic) SetURL(url string) {
+	a.url = url
+}
+
+func (a *Anthropic) SetApiKey(key string) {
+	a.apiKey = key
+}
+
+func (a *Anthropic) SetModel(model string) {
+	a.model = model
+}
+
+// SetMaxTokens sets the default max_tokens, which the Messages API requires on every call
+func (a *Anthropic) SetMaxTokens(maxTokens int) {
+	a.maxTokens = maxTokens
+}
+
+// SetEmbedder sets the provider used for embeddings, as Anthropic does not offer any
+func (a *Anthropic) SetEmbedder(embedder Embedder) {
+	a.embedder = embedder
+}
+
+func (a *Anthropic) GptQuery(systemPrompt string, message string, userContext string) (string, error) {
+	return a.GptQueryContext(context.Background(), systemPrompt, message, userContext)
+}
+
+func (a *Anthropic) GptQueryContext(ctx context.Context, systemPrompt string, message string, userContext string) (string, error) {
+	messages := []GPTmessage{
+		{Role: SYSTEMROLE, Content: systemPrompt},
+		{Role: USERROLE, Content: message},
+	}
+	if userContext != "" {
+		messages = append(messages, GPTmessage{Role: USERROLE, Content: userContext})
+	}
+	reply, err := a.ChatContext(ctx, messages, nil)
+	if err != nil {
+		return "", err
+	}
+	return reply.Content, nil
+}
+
+// Chat sends the conversation to the Messages API and returns the assistant reply
+func (a *Anthropic) Chat(messages []GPTmessage, opts *ChatOptions) (GPTmessage, error) {
+	return a.ChatContext(context.Background(), messages, opts)
+}
+
+func (a *Anthropic) ChatContext(ctx context.Context, messages []GPTmessage, opts *ChatOptions) (GPTmessage, error) {
+	if len(messages) == 0 {
+		return GPTmessage{}, errors.New("no messages to send")
+	}
+	data := a.request(messages, opts)
+	resp, err := a.send(ctx, data)
+	if err != nil {
+		return GPTmessage{}, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return GPTmessage{}, fmt.Errorf("failed to read API response: %w", err)
+	}
+
+	var msgResp anthropicResponse
+	if err := json.Unmarshal(respBody, &msgResp); err != nil {
+		return GPTmessage{}, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if msgResp.Error != nil {
+		return GPTmessage{}, newAPIError(resp.StatusCode, resp.Header, respBody)
+	}
+	if msgResp.Usage != nil {
+		a.recordUsage(ctx, msgResp.Usage.toUsage(requestModel(data)))
+	}
+
+	reply := GPTmessage{Role: ASSISTANTROLE}
+	var text strings.Builder
+	for _, block := range msgResp.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "tool_use":
+			reply.ToolCalls = append(reply.ToolCalls, anthropicToolCall(block.ID, block.Name, string(block.Input)))
+		}
+	}
+	reply.Content = text.String()
+	if reply.Content == "" && len(reply.ToolCalls) == 0 {
+		return GPTmessage{}, errors.New("no reply")
+	}
+	return reply, nil
+}
+
+// ChatStream streams the reply, calling onDelta with every text fragment
+func (a *Anthropic) ChatStream(messages []GPTmessage, opts *ChatOptions, onDelta func(delta string)) (GPTmessage, error) {
+	return a.ChatStreamContext(context.Background(), messages, opts, onDelta)
+}
+
+func (a *Anthropic) ChatStreamContext(ctx context.Context, messages []GPTmessage, opts *ChatOptions, onDelta func(delta string)) (GPTmessage, error) {
+	if len(messages) == 0 {
+		return GPTmessage{}, errors.New("no messages to send")
+	}
+	data := a.request(messages, opts)
+	data["stream"] = true
+
+	resp, err := a.send(ctx, data)
+	if err != nil {
+		return GPTmessage{}, err
+	}
+	defer resp.Body.Close()
+
+	reply, usage, err := readAnthropicStream(resp.Body, onDelta)
+	if usage.InputTokens > 0 || usage.OutputTokens > 0 {
+		a.recordUsage(ctx, usage.toUsage(requestModel(data)))
+	}
+	return reply, err
+}
+
+func (a *Anthropic) GetEmbedding(text string) ([]float32, error) {
+	return a.GetEmbeddingContext(context.Background(), text)
+}
+
+func (a *Anthropic) GetEmbeddingContext(ctx context.Context, text string) ([]float32, error) {
+	if a.embedder == nil {
+		return nil, ErrEmbeddingsUnsupported
+	}
+	if ce, ok := a.embedder.(ContextEmbedder); ok {
+		return ce.GetEmbeddingContext(ctx, text)
+	}
+	return a.embedder.GetEmbedding(text)
+}
+
+func (a *Anthropic) GetEmbeddingsBatch(texts []string) ([][]float32, error) {
+	return a.GetEmbeddingsBatchContext(context.Background(), texts)
+}
+
+func (a *Anthropic) GetEmbeddingsBatchContext(ctx context.Context, texts []string) ([][]float32, error) {
+	if a.embedder == nil {
+		return nil, ErrEmbeddingsUnsupported
+	}
+	if ce, ok := a.embedder.(ContextEmbedder); ok {
+		return ce.GetEmbeddingsBatchContext(ctx, texts)
+	}
+	return a.embedder.GetEmbeddingsBatch(texts)
+}
+
+// request builds the Messages API request body. Seed and response format have no
+// Messages API equivalent and are ignored.
+func (a *Anthropic) request(messages []GPTmessage, opts *ChatOptions) map[string]interface{} {
+	system, msgs := anthropicMessages(messages)
+	data := map[string]interface{}{
+		"model":      a.model,
+		"max_tokens": a.maxTokens,
+		"messages":   msgs,
+	}
+	if system != "" {
+		data["system"] = system
+	}
+	if opts == nil {
+		return data
+	}
+	if opts.Model != "" {
+		data["model"] = opts.Model
+	}
+	if opts.Temperature != nil {
+		data["temperature"] = *opts.Temperature
+	}
+	if opts.MaxTokens > 0 {
+		data["max_tokens"] = opts.MaxTokens
+	}
+	if len(opts.Stop) > 0 {
+		data["stop_sequences"] = opts.Stop
+	}
+	if len(opts.Tools) > 0 {
+		tools := make([]anthropicTool, 0, len(opts.Tools))
+		for _, t := range opts.Tools {
+			schema := t.Function.Parameters
+			if len(schema) == 0 {
+				schema = json.RawMessage(`{"type":"object","properties":{}}`)
+			}
+			tools = append(tools, anthropicTool{
+				Name:        t.Function.Name,
+				Description: t.Function.Description,
+				InputSchema: schema,
+			})
+		}
+		data["tools"] = tools
+	}
+	return data
+}
+
+// send posts the request, error replies come back as typed errors
+func (a *Anthropic) send(ctx context.Context, data map[string]interface{}) (*http.Response, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := a.url
+	if url == "" {
+		url = ANTHROPICURL
+	}
+
+	setHeaders := func(req *http.Request) {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("x-api-key", a.apiKey)
+		req.Header.Set("anthropic-version", ANTHROPICVERSION)
+	}
+	if stream, _ := data["stream"].(bool); stream {
+		return a.postStream(ctx, url, jsonData, setHeaders)
+	}
+	return a.post(ctx, url, jsonData, setHeaders)
+}
+
+// anthropicMessages converts the conversation into a system prompt and Messages API turns.
+// Tool results become tool_result blocks of a user turn and consecutive turns of the same
+// role are merged, as the API expects user and assistant turns to alternate.
+func anthropicMessages(messages []GPTmessage) (string, []anthropicMessage) {
+	var system []string
+	var out []anthropicMessage
+
+	appendBlock := func(role string, block anthropicContent) {
+		if n := len(out); n > 0 && out[n-1].Role == role {
+			out[n-1].Content = append(out[n-1].Content, block)
+			return
+		}
+		out = append(out, anthropicMessage{Role: role, Content: []anthropicContent{block}})
+	}
+
+	for _, m := range messages {
+		switch m.Role {
+		case SYSTEMROLE:
+			system = append(system, m.Content)
+		case TOOLROLE:
+			appendBlock(USERROLE, anthropicContent{Type: "tool_result", ToolUseID: m.ToolCallID, Content: m.Content})
+		case ASSISTANTROLE:
+			if m.Content != "" {
+				appendBlock(ASSISTANTROLE, anthropicContent{Type: "text", Text: m.Content})
+			}
+			for _, tc := range m.ToolCalls {
+				input := json.RawMessage(tc.Function.Arguments)
+				if len(input) == 0 {
+					input = json.RawMessage(`{}`)
+				}
+				appendBlock(ASSISTANTROLE, anthropicContent{Type: "tool_use", ID: tc.ID, Name: tc.Function.Name, Input: input})
+			}
+		default:
+			if m.Content != "" || len(m.Parts) == 0 {
+				appendBlock(USERROLE, anthropicContent{Type: "text", Text: m.Content})
+			}
+			for _, part := range m.Parts {
+				appendBlock(USERROLE, anthropicPart(part))
+			}
+		}
+	}
+	return strings.Join(system, "\n\n"), out
+}
+
+// anthropicPart converts a content part into a text or image block
+func anthropicPart(part ContentPart) anthropicContent {
+	if part.Type != PARTIMAGEURL || part.ImageURL == nil {
+		return anthropicContent{Type: "text", Text: part.Text}
+	}
+	if mediaType, data, ok := parseDataURL(part.ImageURL.URL); ok {
+		return anthropicContent{Type: "image", Source: &anthropicSource{Type: "base64", MediaType: mediaType, Data: data}}
+	}
+	return anthropicContent{Type: "image", Source: &anthropicSource{Type: "url", URL: part.ImageURL.URL}}
+}
+
+func anthropicToolCall(id string, name string, input string) ToolCall {
+	call := ToolCall{ID: id, Type: TOOLFUNCTION}
+	call.Function.Name = name
+	call.Function.Arguments = input
+	return call
+}
+
+// readAnthropicStream consumes the Messages API event stream and assembles the reply
+func readAnthropicStream(body io.Reader, onDelta func(delta string)) (GPTmessage, anthropicUsage, error) {
+	reply := GPTmessage{Role: ASSISTANTROLE}
+	var usage anthropicUsage
+	var text strings.Builder
+	// content block index -> position in reply.ToolCalls
+	toolIndex := map[int]int{}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, sseDataPrefix) {
+			continue
+		}
+		var event anthropicStreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, sseDataPrefix))), &event); err != nil {
+			return reply, usage, fmt.Errorf("failed to unmarshal stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "error":
+			apiErr := &APIError{StatusCode: http.StatusOK}
+			if event.Error != nil {
+				apiErr.Type = event.Error.Type
+				apiErr.Message = event.Error.Message
+			}
+			return reply, usage, classifyAPIError(apiErr)
+		case "message_start":
+			if event.Message.Usage != nil {
+				usage = *event.Message.Usage
+			}
+		case "message_delta":
+			if event.Usage != nil {
+				usage.OutputTokens = event.Usage.OutputTokens
+			}
+		case "content_block_start":
+			if event.ContentBlock.Type == "tool_use" {
+				toolIndex[event.Index] = len(reply.ToolCalls)
+				reply.ToolCalls = append(reply.ToolCalls, anthropicToolCall(event.ContentBlock.ID, event.ContentBlock.Name, ""))
+			}
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "text_delta":
+				text.WriteString(event.Delta.Text)
+				if onDelta != nil {
+					onDelta(event.Delta.Text)
+				}
+			case "input_json_delta":
+				if i, ok := toolIndex[event.Index]; ok {
+					reply.ToolCalls[i].Function.Arguments += event.Delta.PartialJSON
+				}
+			}
+		}
+		if event.Type == "message_stop" {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return reply, usage, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	reply.Content = text.String()
+	if reply.Content == "" && len(reply.ToolCalls) == 0 {
+		return reply, usage, errors.New("no reply")
+	}
+	return reply, usage, nil
+}
@@ -0,0 +1,149 @@
+package gpt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	OPENAITRANSCRIPTIONURL = "https://api.openai.com/v1/audio/transcriptions"
+	MODELTRANSCRIPTION     = "whisper-1"
+	transcriptionsEP       = "/audio/transcriptions"
+)
+
+// ErrTranscriptionUnsupported is returned by providers without a transcription endpoint
+var ErrTranscriptionUnsupported = errors.New("provider does not support audio transcription")
+
+// Transcriber turns speech into text
+type Transcriber interface {
+	Transcribe(audio []byte, filename string, opts *TranscriptionOptions) (*Transcription, error)
+	TranscribeContext(ctx context.Context, audio []byte, filename string, opts *TranscriptionOptions) (*Transcription, error)
+}
+
+// TranscriptionOptions are the optional parameters of a transcription
+type TranscriptionOptions struct {
+	// Model overrides the transcription model
+	Model string
+	// Language is the ISO-639-1 code of the audio, it improves accuracy and latency
+	Language string
+	// Prompt guides the style or spelling, e.g. product names said in the audio
+	Prompt      string
+	Temperature *float64
+}
+
+// Transcription is the result of a transcription
+type Transcription struct {
+	Text     string  `json:"text"`
+	Language string  `json:"language,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+}
+
+var _ Transcriber = (*OpenAI)(nil)
+
+// SetTranscriptionURL sets the transcriptions endpoint, e.g. a local whisper server
+// or an Azure whisper deployment
+func (o *OpenAI) SetTranscriptionURL(url string) {
+	o.transcribeURL = url
+}
+
+// SetTranscriptionModel sets the transcription model, defaults to MODELTRANSCRIPTION
+func (o *OpenAI) SetTranscriptionModel(model string) {
+	o.transcribeModel = model
+}
+
+func (o *OpenAI) transcriptionURL() string {
+	if o.transcribeURL != "" {
+		return o.transcribeURL
+	}
+	if o.azure {
+		// whisper runs in its own deployment
+		return ""
+	}
+	if o.url != "" {
+		return strings.TrimSuffix(strings.TrimRight(o.url, "/"), chatCompletionsEP) + transcriptionsEP
+	}
+	return OPENAITRANSCRIPTIONURL
+}
+
+// Transcribe sends audio to the Whisper compatible transcriptions endpoint. filename must
+// carry the extension of the audio format (mp3, mp4, m4a, wav, webm, ...).
+func (o *OpenAI) Transcribe(audio []byte, filename string, opts *TranscriptionOptions) (*Transcription, error) {
+	return o.TranscribeContext(context.Background(), audio, filename, opts)
+}
+
+func (o *OpenAI) TranscribeContext(ctx context.Context, audio []byte, filename string, opts *TranscriptionOptions) (*Transcription, error) {
+	url := o.transcriptionURL()
+	if url == "" {
+		return nil, ErrTranscriptionUnsupported
+	}
+	if opts == nil {
+		opts = &TranscriptionOptions{}
+	}
+	model := opts.Model
+	if model == "" {
+		model = o.transcribeModel
+	}
+	if model == "" {
+		model = MODELTRANSCRIPTION
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	var temperature string
+	if opts.Temperature != nil {
+		temperature = strconv.FormatFloat(*opts.Temperature, 'f', -1, 64)
+	}
+	fields := [][2]string{
+		{"model", model},
+		{"response_format", "verbose_json"},
+		{"language", opts.Language},
+		{"prompt", opts.Prompt},
+		{"temperature", temperature},
+	}
+	for _, field := range fields {
+		if field[1] == "" {
+			continue
+		}
+		if err := form.WriteField(field[0], field[1]); err != nil {
+			return nil, fmt.Errorf("failed to build request: %w", err)
+		}
+	}
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	if _, err := part.Write(audio); err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	if err := form.Close(); err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+
+	resp, err := o.post(ctx, url, body.Bytes(), func(req *http.Request) {
+		o.setHeaders(req)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API response: %w", err)
+	}
+
+	var transcription Transcription
+	if err := json.Unmarshal(respBody, &transcription); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return &transcription, nil
+}
@@ -0,0 +1,90 @@
+package gpt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTranscribeSendsMultipartAndParsesTheReply(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/audio/transcriptions" {
+			t.Errorf("request to %s", r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer key" {
+			t.Errorf("Authorization %q", auth)
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Error(err)
+			return
+		}
+		want := map[string]string{
+			"model":           "whisper-large",
+			"response_format": "verbose_json",
+			"language":        "fr",
+			"prompt":          "Slackagent",
+			"temperature":     "0.2",
+		}
+		for field, value := range want {
+			if got := r.FormValue(field); got != value {
+				t.Errorf("field %s = %q, want %q", field, got, value)
+			}
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		audio, _ := io.ReadAll(file)
+		if header.Filename != "memo.webm" || string(audio) != "AUDIO" {
+			t.Errorf("file %s with %q", header.Filename, audio)
+		}
+		w.Write([]byte(`{"task":"transcribe","language":"french","duration":2.5,"text":"Bonjour",
+			"segments":[{"id":0,"start":0,"end":2.5,"text":"Bonjour"}]}`))
+	}))
+	defer server.Close()
+
+	o := NewOpenAICompatible(server.URL+"/v1", "key", "llama3.1")
+	o.SetTranscriptionModel("whisper-large")
+	temperature := 0.2
+	transcription, err := o.TranscribeContext(context.Background(), []byte("AUDIO"), "memo.webm",
+		&TranscriptionOptions{Language: "fr", Prompt: "Slackagent", Temperature: &temperature})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transcription.Text != "Bonjour" || transcription.Language != "french" || transcription.Duration != 2.5 {
+		t.Errorf("transcription %+v", transcription)
+	}
+}
+
+func TestTranscribeDefaultsAndErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseMultipartForm(1 << 20)
+		if model := r.FormValue("model"); model != MODELTRANSCRIPTION {
+			t.Errorf("model %q, want the default", model)
+		}
+		for _, field := range []string{"language", "prompt", "temperature"} {
+			if _, ok := r.MultipartForm.Value[field]; ok {
+				t.Errorf("unset field %s was sent", field)
+			}
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"message":"Invalid file format","type":"invalid_request_error"}}`))
+	}))
+	defer server.Close()
+
+	o := NewOpenAI("key", "gpt-4o")
+	o.SetTranscriptionURL(server.URL + "/transcribe")
+	o.SetRetryPolicy(RetryPolicy{})
+	if _, err := o.Transcribe([]byte("AUDIO"), "memo.txt", nil); err == nil {
+		t.Error("a rejected transcription succeeded")
+	}
+
+	azure := NewAzureOpenAI("https://example.openai.azure.com", "key", "gpt-4o", "", "")
+	if _, err := azure.Transcribe([]byte("AUDIO"), "memo.webm", nil); !errors.Is(err, ErrTranscriptionUnsupported) {
+		t.Errorf("azure without a whisper deployment = %v, want ErrTranscriptionUnsupported", err)
+	}
+}
@@ -0,0 +1,607 @@
+package gpt
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCacheEntries bounds a MemoryCache created with no size
+	DefaultCacheEntries = 1000
+	// DefaultCacheTTL is used by a ResponseCache created with no TTL
+	DefaultCacheTTL = 24 * time.Hour
+	// DefaultSemanticThreshold is the cosine similarity above which a cached answer is reused
+	DefaultSemanticThreshold = 0.95
+	// maxSemanticEntries bounds the embeddings kept by the semantic index
+	maxSemanticEntries = 1000
+	// diskPruneEvery is the number of writes between two prunings of a DiskCache
+	diskPruneEvery = 100
+)
+
+// CacheStore stores cached responses by key
+type CacheStore interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+}
+
+// TTLCacheStore is a CacheStore that also returns the time left before an entry expires,
+// so TieredCache fills its faster stores with the same expiry
+type TTLCacheStore interface {
+	CacheStore
+	GetTTL(key string) ([]byte, time.Duration, bool)
+}
+
+type cacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// MemoryCache is an in-memory LRU CacheStore
+type MemoryCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List
+	entries    map[string]*list.Element
+}
+
+// NewMemoryCache creates an LRU cache holding up to maxEntries responses
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheEntries
+	}
+	return &MemoryCache{maxEntries: maxEntries, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (m *MemoryCache) Get(key string) ([]byte, bool) {
+	value, _, ok := m.GetTTL(key)
+	return value, ok
+}
+
+func (m *MemoryCache) GetTTL(key string) ([]byte, time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.entries[key]
+	if !ok {
+		return nil, 0, false
+	}
+	entry := el.Value.(*cacheEntry)
+	ttl := time.Until(entry.expires)
+	if ttl <= 0 {
+		m.order.Remove(el)
+		delete(m.entries, key)
+		return nil, 0, false
+	}
+	m.order.MoveToFront(el)
+	return entry.value, ttl, true
+}
+
+func (m *MemoryCache) Set(key string, value []byte, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := &cacheEntry{key: key, value: value, expires: time.Now().Add(ttl)}
+	if el, ok := m.entries[key]; ok {
+		el.Value = entry
+		m.order.MoveToFront(el)
+		return
+	}
+	m.entries[key] = m.order.PushFront(entry)
+	for m.order.Len() > m.maxEntries {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// DiskCache is a CacheStore persisting every response as a JSON file in a directory.
+// Expired files are pruned every few writes, and the least recently written ones when
+// there are more than maxEntries.
+type DiskCache struct {
+	dir        string
+	maxEntries int
+
+	mu      sync.Mutex
+	entries int
+	writes  int
+}
+
+type diskEntry struct {
+	Expires time.Time       `json:"expires"`
+	Value   json.RawMessage `json:"value"`
+}
+
+// NewDiskCache creates a cache in dir holding up to maxEntries responses, creating dir
+// if needed and pruning its expired entries
+func NewDiskCache(dir string, maxEntries int) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache dir: %w", err)
+	}
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheEntries
+	}
+	d := &DiskCache{dir: dir, maxEntries: maxEntries}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.prune()
+	return d, nil
+}
+
+func (d *DiskCache) path(key string) string {
+	return filepath.Join(d.dir, key+".json")
+}
+
+func (d *DiskCache) Get(key string) ([]byte, bool) {
+	value, _, ok := d.GetTTL(key)
+	return value, ok
+}
+
+func (d *DiskCache) GetTTL(key string) ([]byte, time.Duration, bool) {
+	data, err := os.ReadFile(d.path(key))
+	if err != nil {
+		return nil, 0, false
+	}
+	var entry diskEntry
+	var ttl time.Duration
+	if err := json.Unmarshal(data, &entry); err == nil {
+		ttl = time.Until(entry.Expires)
+	}
+	if ttl <= 0 {
+		d.remove(d.path(key))
+		return nil, 0, false
+	}
+	return entry.Value, ttl, true
+}
+
+func (d *DiskCache) Set(key string, value []byte, ttl time.Duration) {
+	data, err := json.Marshal(diskEntry{Expires: time.Now().Add(ttl), Value: value})
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, err = os.Stat(d.path(key))
+	exists := err == nil
+	tmp := d.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("Failed to write cache entry: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, d.path(key)); err != nil {
+		log.Printf("Failed to write cache entry: %v", err)
+		return
+	}
+	if !exists {
+		d.entries++
+	}
+	d.writes++
+	if d.entries > d.maxEntries || d.writes >= diskPruneEvery {
+		d.prune()
+	}
+}
+
+func (d *DiskCache) remove(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if os.Remove(path) == nil {
+		d.entries--
+	}
+}
+
+// prune removes the expired and unreadable entries, then the oldest ones above
+// maxEntries. d.mu must be held.
+func (d *DiskCache) prune() {
+	d.writes = 0
+	paths, err := filepath.Glob(filepath.Join(d.dir, "*.json"))
+	if err != nil {
+		log.Printf("Failed to list cache entries: %v", err)
+		return
+	}
+	type diskFile struct {
+		path     string
+		modified time.Time
+	}
+	now := time.Now()
+	files := make([]diskFile, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var entry diskEntry
+		info, statErr := os.Stat(path)
+		if json.Unmarshal(data, &entry) != nil || now.After(entry.Expires) || statErr != nil {
+			os.Remove(path)
+			continue
+		}
+		files = append(files, diskFile{path: path, modified: info.ModTime()})
+	}
+	if extra := len(files) - d.maxEntries; extra > 0 {
+		sort.Slice(files, func(i, j int) bool { return files[i].modified.Before(files[j].modified) })
+		for _, file := range files[:extra] {
+			os.Remove(file.path)
+		}
+		files = files[extra:]
+	}
+	d.entries = len(files)
+}
+
+// TieredCache looks up its stores in order, e.g. memory then disk, and fills the
+// faster stores on a hit in a slower one, with the time left before the entry expires.
+// Hits in a store that is not a TTLCacheStore are not copied to the faster ones.
+type TieredCache []CacheStore
+
+func (t TieredCache) Get(key string) ([]byte, bool) {
+	value, _, ok := t.GetTTL(key)
+	return value, ok
+}
+
+func (t TieredCache) GetTTL(key string) ([]byte, time.Duration, bool) {
+	for i, store := range t {
+		expiring, ok := store.(TTLCacheStore)
+		if !ok {
+			if value, ok := store.Get(key); ok {
+				return value, 0, true
+			}
+			continue
+		}
+		if value, ttl, ok := expiring.GetTTL(key); ok {
+			for _, faster := range t[:i] {
+				faster.Set(key, value, ttl)
+			}
+			return value, ttl, true
+		}
+	}
+	return nil, 0, false
+}
+
+func (t TieredCache) Set(key string, value []byte, ttl time.Duration) {
+	for _, store := range t {
+		store.Set(key, value, ttl)
+	}
+}
+
+// SemanticEmbedder embeds queries for the semantic cache. *embedding.EmbeddingStore
+// implements it, so the local ONNX model can be used.
+type SemanticEmbedder interface {
+	GetEmbedding(ctx context.Context, text string) ([]float32, error)
+}
+
+// SemanticEmbedderFunc adapts a function, e.g. the GetEmbeddingContext method of an LLM
+type SemanticEmbedderFunc func(ctx context.Context, text string) ([]float32, error)
+
+func (f SemanticEmbedderFunc) GetEmbedding(ctx context.Context, text string) ([]float32, error) {
+	return f(ctx, text)
+}
+
+type semanticEntry struct {
+	scope     string
+	embedding []float32
+	key       string
+	expires   time.Time
+}
+
+// ResponseCache caches LLM replies and embeddings in a CacheStore. Shared by the
+// CachedLLM wrappers of an application so the semantic index is kept across calls.
+type ResponseCache struct {
+	store     CacheStore
+	ttl       time.Duration
+	embedder  SemanticEmbedder
+	threshold float32
+
+	mu    sync.Mutex
+	index []semanticEntry
+}
+
+// NewResponseCache creates a cache whose entries expire after ttl
+func NewResponseCache(store CacheStore, ttl time.Duration) *ResponseCache {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &ResponseCache{store: store, ttl: ttl}
+}
+
+// EnableSemantic reuses the answer of a cached query whose embedding is at least threshold
+// similar to the new one, when everything else in the request (model, system prompt,
+// earlier turns, options) is identical
+func (c *ResponseCache) EnableSemantic(embedder SemanticEmbedder, threshold float32) {
+	if threshold <= 0 {
+		threshold = DefaultSemanticThreshold
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.embedder = embedder
+	c.threshold = threshold
+}
+
+// cacheKey hashes the parts of a request into a key
+func cacheKey(parts ...interface{}) string {
+	data, _ := json.Marshal(parts)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *ResponseCache) get(key string, out interface{}) bool {
+	data, ok := c.store.Get(key)
+	if !ok {
+		return false
+	}
+	return json.Unmarshal(data, out) == nil
+}
+
+func (c *ResponseCache) set(key string, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	c.store.Set(key, data, c.ttl)
+}
+
+// semanticLookup finds a cached key for a query similar to text within scope
+func (c *ResponseCache) semanticLookup(ctx context.Context, scope string, text string) (string, []float32) {
+	c.mu.Lock()
+	embedder, threshold := c.embedder, c.threshold
+	c.mu.Unlock()
+	if embedder == nil || text == "" {
+		return "", nil
+	}
+
+	query, err := embedder.GetEmbedding(ctx, text)
+	if err != nil {
+		log.Printf("Semantic cache embedding failed: %v", err)
+		return "", nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	best, bestScore := "", threshold
+	for _, entry := range c.index {
+		if entry.scope != scope || now.After(entry.expires) {
+			continue
+		}
+		if score := cosine(query, entry.embedding); score >= bestScore {
+			best, bestScore = entry.key, score
+		}
+	}
+	return best, query
+}
+
+func (c *ResponseCache) semanticStore(scope string, embedding []float32, key string) {
+	if embedding == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.index = append(c.index, semanticEntry{scope: scope, embedding: embedding, key: key, expires: time.Now().Add(c.ttl)})
+	if len(c.index) > maxSemanticEntries {
+		c.index = c.index[len(c.index)-maxSemanticEntries:]
+	}
+}
+
+func cosine(a, b []float32) float32 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(na) * math.Sqrt(nb)))
+}
+
+// CachedLLM is an LLM answering repeated requests from a ResponseCache. Replies with
+// errors are never cached.
+type CachedLLM struct {
+	llm   LLM
+	cache *ResponseCache
+	// namespace separates the entries of different models or providers
+	namespace string
+	// embeddings enables caching of embedding calls
+	embeddings bool
+}
+
+var (
+	_ LLM       = (*CachedLLM)(nil)
+	_ Responder = (*CachedLLM)(nil)
+)
+
+// NewCachedLLM wraps llm with the cache. namespace, usually the provider and model,
+// keeps the entries of different backends apart.
+func NewCachedLLM(llm LLM, cache *ResponseCache, namespace string) *CachedLLM {
+	return &CachedLLM{llm: llm, cache: cache, namespace: namespace}
+}
+
+// CacheEmbeddings enables caching of embedding calls, keyed on the text
+func (c *CachedLLM) CacheEmbeddings(enabled bool) {
+	c.embeddings = enabled
+}
+
+func (c *CachedLLM) GptQuery(systemPrompt string, message string, userContext string) (string, error) {
+	return c.GptQueryContext(context.Background(), systemPrompt, message, userContext)
+}
+
+func (c *CachedLLM) GptQueryContext(ctx context.Context, systemPrompt string, message string, userContext string) (string, error) {
+	key := cacheKey("query", c.namespace, systemPrompt, message, userContext)
+	var reply string
+	if c.cache.get(key, &reply) {
+		return reply, nil
+	}
+
+	scope := cacheKey("query", c.namespace, systemPrompt, userContext)
+	similar, embedding := c.cache.semanticLookup(ctx, scope, message)
+	if similar != "" && c.cache.get(similar, &reply) {
+		return reply, nil
+	}
+
+	reply, err := c.llm.GptQueryContext(ctx, systemPrompt, message, userContext)
+	if err != nil {
+		return "", err
+	}
+	c.cache.set(key, reply)
+	c.cache.semanticStore(scope, embedding, key)
+	return reply, nil
+}
+
+func (c *CachedLLM) Chat(messages []GPTmessage, opts *ChatOptions) (GPTmessage, error) {
+	return c.ChatContext(context.Background(), messages, opts)
+}
+
+func (c *CachedLLM) ChatContext(ctx context.Context, messages []GPTmessage, opts *ChatOptions) (GPTmessage, error) {
+	reply, found, store := c.lookup(ctx, messages, opts)
+	if found {
+		return reply, nil
+	}
+	reply, err := c.llm.ChatContext(ctx, messages, opts)
+	if err != nil {
+		return reply, err
+	}
+	store(reply)
+	return reply, nil
+}
+
+func (c *CachedLLM) ChatStream(messages []GPTmessage, opts *ChatOptions, onDelta func(delta string)) (GPTmessage, error) {
+	return c.ChatStreamContext(context.Background(), messages, opts, onDelta)
+}
+
+// ChatStreamContext replays a cached reply as a single delta
+func (c *CachedLLM) ChatStreamContext(ctx context.Context, messages []GPTmessage, opts *ChatOptions, onDelta func(delta string)) (GPTmessage, error) {
+	reply, found, store := c.lookup(ctx, messages, opts)
+	if found {
+		if onDelta != nil && reply.Content != "" {
+			onDelta(reply.Content)
+		}
+		return reply, nil
+	}
+	reply, err := c.llm.ChatStreamContext(ctx, messages, opts, onDelta)
+	if err != nil {
+		return reply, err
+	}
+	store(reply)
+	return reply, nil
+}
+
+// lookup finds the cached reply of a conversation. On a miss it returns the function
+// storing the reply under the conversation key and in the semantic index.
+func (c *CachedLLM) lookup(ctx context.Context, messages []GPTmessage, opts *ChatOptions) (GPTmessage, bool, func(GPTmessage)) {
+	key := cacheKey("chat", c.namespace, messages, opts)
+	var reply GPTmessage
+	if c.cache.get(key, &reply) {
+		return reply, true, nil
+	}
+
+	// the semantic index compares the last user turn, everything before it must match
+	var embedding []float32
+	var scope string
+	if n := len(messages); n > 0 && messages[n-1].Role == USERROLE && len(messages[n-1].Parts) == 0 {
+		scope = cacheKey("chat", c.namespace, messages[:n-1], opts)
+		var similar string
+		similar, embedding = c.cache.semanticLookup(ctx, scope, messages[n-1].Content)
+		if similar != "" && c.cache.get(similar, &reply) {
+			return reply, true, nil
+		}
+	}
+
+	return GPTmessage{}, false, func(reply GPTmessage) {
+		c.cache.set(key, reply)
+		c.cache.semanticStore(scope, embedding, key)
+	}
+}
+
+// Respond answers a repeated Responses call from the cache. Only completed responses are
+// cached, and the wrapped LLM must be a Responder, or ErrResponsesUnsupported is returned.
+func (c *CachedLLM) Respond(input []GPTmessage, opts *ResponseOptions) (*Response, error) {
+	return c.RespondContext(context.Background(), input, opts)
+}
+
+func (c *CachedLLM) RespondContext(ctx context.Context, input []GPTmessage, opts *ResponseOptions) (*Response, error) {
+	responder, ok := c.llm.(Responder)
+	if !ok {
+		return nil, ErrResponsesUnsupported
+	}
+	key := cacheKey("respond", c.namespace, input, opts)
+	var response Response
+	if c.cache.get(key, &response) {
+		return &response, nil
+	}
+	reply, err := responder.RespondContext(ctx, input, opts)
+	if err != nil {
+		return reply, err
+	}
+	if reply.Status == "" || reply.Status == "completed" {
+		c.cache.set(key, reply)
+	}
+	return reply, nil
+}
+
+func (c *CachedLLM) GetEmbedding(text string) ([]float32, error) {
+	return c.GetEmbeddingContext(context.Background(), text)
+}
+
+func (c *CachedLLM) GetEmbeddingContext(ctx context.Context, text string) ([]float32, error) {
+	if !c.embeddings {
+		return c.llm.GetEmbeddingContext(ctx, text)
+	}
+	key := cacheKey("embedding", c.namespace, text)
+	var embedding []float32
+	if c.cache.get(key, &embedding) {
+		return embedding, nil
+	}
+	embedding, err := c.llm.GetEmbeddingContext(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.set(key, embedding)
+	return embedding, nil
+}
+
+func (c *CachedLLM) GetEmbeddingsBatch(texts []string) ([][]float32, error) {
+	return c.GetEmbeddingsBatchContext(context.Background(), texts)
+}
+
+// GetEmbeddingsBatchContext only sends the texts missing from the cache
+func (c *CachedLLM) GetEmbeddingsBatchContext(ctx context.Context, texts []string) ([][]float32, error) {
+	if !c.embeddings {
+		return c.llm.GetEmbeddingsBatchContext(ctx, texts)
+	}
+	embeddings := make([][]float32, len(texts))
+	var missing []string
+	var missingIdx []int
+	for i, text := range texts {
+		if !c.cache.get(cacheKey("embedding", c.namespace, text), &embeddings[i]) {
+			missing = append(missing, text)
+			missingIdx = append(missingIdx, i)
+		}
+	}
+	if len(missing) == 0 {
+		return embeddings, nil
+	}
+
+	fetched, err := c.llm.GetEmbeddingsBatchContext(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	if len(fetched) != len(missing) {
+		return nil, fmt.Errorf("got %d embeddings for %d texts", len(fetched), len(missing))
+	}
+	for j, i := range missingIdx {
+		embeddings[i] = fetched[j]
+		c.cache.set(cacheKey("embedding", c.namespace, missing[j]), fetched[j])
+	}
+	return embeddings, nil
+}
@@ -0,0 +1,65 @@
+package gpt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTieredCachePromotesWithRemainingTTL(t *testing.T) {
+	disk, err := NewDiskCache(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := NewMemoryCache(10)
+	tiers := TieredCache{memory, disk}
+	disk.Set("key", []byte(`"reply"`), time.Minute)
+
+	if _, ok := tiers.Get("key"); !ok {
+		t.Fatal("entry on disk not found")
+	}
+	_, ttl, ok := memory.GetTTL("key")
+	if !ok {
+		t.Fatal("entry not promoted to memory")
+	}
+	if ttl > time.Minute || ttl < 50*time.Second {
+		t.Errorf("promoted with ttl %v, want the minute left on disk", ttl)
+	}
+}
+
+func TestDiskCachePrunes(t *testing.T) {
+	dir := t.TempDir()
+	disk, err := NewDiskCache(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk.Set("expired", []byte(`1`), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		disk.Set(key, []byte(`1`), time.Hour)
+		time.Sleep(5 * time.Millisecond)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 3 {
+		t.Fatalf("%d entries on disk, want 3", len(files))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "expired.json")); !os.IsNotExist(err) {
+		t.Error("expired entry not pruned")
+	}
+	if _, ok := disk.Get("a"); ok {
+		t.Error("oldest entry not evicted")
+	}
+	if _, ok := disk.Get("d"); !ok {
+		t.Error("newest entry evicted")
+	}
+
+	// expired entries left by a previous run are pruned on open
+	os.WriteFile(filepath.Join(dir, "old.json"), []byte(`{"expires":"2000-01-01T00:00:00Z","value":1}`), 0644)
+	if _, err := NewDiskCache(dir, 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "old.json")); !os.IsNotExist(err) {
+		t.Error("expired entry not pruned on open")
+	}
+}
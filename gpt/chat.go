@@ -0,0 +1,150 @@
+package gpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+const (
+	RESPONSETEXT = "text"
+	RESPONSEJSON = "json_object"
+)
+
+// ChatOptions holds the per-call settings of a chat completion.
+// Zero values are left out of the request so the API defaults apply.
+type ChatOptions struct {
+	Model          string
+	Temperature    *float64
+	MaxTokens      int
+	Stop           []string
+	Seed           *int
+	ResponseFormat *ResponseFormat
+	Tools          []Tool
+	// ReasoningEffort is one of the EFFORT* constants, for OpenAI reasoning models only
+	ReasoningEffort string
+}
+
+// ResponseFormat constrains the format of the model reply.
+// JSONSchema is only used with the json_schema type, see StructuredChat.
+type ResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+// chatResponse is the subset of the chat completions response we decode
+type chatResponse struct {
+	Choices []struct {
+		Index        int        `json:"index"`
+		Message      GPTmessage `json:"message"`
+		FinishReason string     `json:"finish_reason"`
+	} `json:"choices"`
+	Model string       `json:"model,omitempty"`
+	Usage *openAIUsage `json:"usage,omitempty"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// Float64 returns a pointer to v, for optional fields such as ChatOptions.Temperature
+func Float64(v float64) *float64 {
+	return &v
+}
+
+// Int returns a pointer to v, for optional fields such as ChatOptions.Seed
+func Int(v int) *int {
+	return &v
+}
+
+// Chat sends a full conversation (system, user, assistant and tool turns) and returns the assistant reply
+func (o *OpenAI) Chat(messages []GPTmessage, opts *ChatOptions) (GPTmessage, error) {
+	return o.ChatContext(context.Background(), messages, opts)
+}
+
+// ChatContext is Chat with a context to cancel the call or set a deadline
+func (o *OpenAI) ChatContext(ctx context.Context, messages []GPTmessage, opts *ChatOptions) (GPTmessage, error) {
+	if len(messages) == 0 {
+		return GPTmessage{}, errors.New("no messages to send")
+	}
+	return o.sendChat(ctx, o.chatRequest(messages, opts))
+}
+
+// chatRequest builds the chat completions request body
+func (o *OpenAI) chatRequest(messages []GPTmessage, opts *ChatOptions) map[string]interface{} {
+	data := map[string]interface{}{
+		"model":    o.model,
+		"messages": messages,
+	}
+	if opts == nil {
+		return data
+	}
+	if opts.Model != "" {
+		data["model"] = opts.Model
+	}
+	if opts.Temperature != nil {
+		data["temperature"] = *opts.Temperature
+	}
+	if opts.MaxTokens > 0 {
+		data["max_tokens"] = opts.MaxTokens
+	}
+	if len(opts.Stop) > 0 {
+		data["stop"] = opts.Stop
+	}
+	if opts.Seed != nil {
+		data["seed"] = *opts.Seed
+	}
+	if opts.ResponseFormat != nil {
+		data["response_format"] = opts.ResponseFormat
+	}
+	if len(opts.Tools) > 0 {
+		data["tools"] = opts.Tools
+	}
+	if opts.ReasoningEffort != "" {
+		data["reasoning_effort"] = opts.ReasoningEffort
+	}
+	return data
+}
+
+// sendChat posts a chat completions request and returns the first choice
+func (o *OpenAI) sendChat(ctx context.Context, data map[string]interface{}) (GPTmessage, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return GPTmessage{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := o.post(ctx, o.chatURL(), jsonData, o.setHeaders)
+	if err != nil {
+		return GPTmessage{}, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return GPTmessage{}, fmt.Errorf("failed to read API response: %w", err)
+	}
+
+	var chatResp chatResponse
+	if err := json.Unmarshal(respBody, &chatResp); err != nil {
+		return GPTmessage{}, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if chatResp.Error != nil {
+		return GPTmessage{}, newAPIError(resp.StatusCode, resp.Header, respBody)
+	}
+
+	if chatResp.Usage != nil {
+		o.recordUsage(ctx, chatResp.Usage.toUsage(o.usageModel(chatResp.Model, data)))
+	}
+
+	if len(chatResp.Choices) == 0 {
+		return GPTmessage{}, errors.New("no reply")
+	}
+
+	choice := chatResp.Choices[0]
+	if choice.FinishReason == "content_filter" && choice.Message.Content == "" {
+		return GPTmessage{}, contentFilterError()
+	}
+	return choice.Message, nil
+}
@@ -0,0 +1,118 @@
+package gpt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	PARTTEXT     = "text"
+	PARTIMAGEURL = "image_url"
+
+	IMAGEDETAILAUTO = "auto"
+	IMAGEDETAILLOW  = "low"
+	IMAGEDETAILHIGH = "high"
+
+	// imageTokens approximates the prompt tokens of an image, the cost of a high detail
+	// 1024x1024 image with the OpenAI tiling
+	imageTokens = 765
+)
+
+// ContentPart is a part of a multimodal message: text or an image
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+// ImageURL points to an image, either a public URL or a base64 data URL
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// TextPart creates a text content part
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: PARTTEXT, Text: text}
+}
+
+// ImagePart creates an image content part from a URL the provider can fetch
+func ImagePart(url string) ContentPart {
+	return ContentPart{Type: PARTIMAGEURL, ImageURL: &ImageURL{URL: url}}
+}
+
+// ImageDataPart creates an image content part from the image bytes, e.g. a downloaded Slack file
+func ImageDataPart(mimeType string, data []byte) ContentPart {
+	return ImagePart("data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data))
+}
+
+// NewImageMessage creates a user message asking about images
+func NewImageMessage(text string, images ...ContentPart) GPTmessage {
+	return GPTmessage{Role: USERROLE, Content: text, Parts: images}
+}
+
+// MarshalJSON sends messages with Parts as an array of content parts, with Content as
+// the leading text part, and other messages with plain string content
+func (m GPTmessage) MarshalJSON() ([]byte, error) {
+	type plain GPTmessage
+	if len(m.Parts) == 0 {
+		return json.Marshal(plain(m))
+	}
+	return json.Marshal(struct {
+		plain
+		Content []ContentPart `json:"content"`
+	}{plain(m), m.contentParts()})
+}
+
+// UnmarshalJSON reads a content that is text or a list of parts, as written by MarshalJSON,
+// so messages with images round-trip through caches and cassettes. A leading text part
+// becomes Content and the other parts Parts.
+func (m *GPTmessage) UnmarshalJSON(data []byte) error {
+	type plain GPTmessage
+	var raw struct {
+		plain
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*m = GPTmessage(raw.plain)
+	if len(raw.Content) == 0 || json.Unmarshal(raw.Content, &m.Content) == nil {
+		return nil
+	}
+	var parts []ContentPart
+	if err := json.Unmarshal(raw.Content, &parts); err != nil {
+		return fmt.Errorf("message content is neither text nor parts: %w", err)
+	}
+	if len(parts) > 0 && parts[0].Type == PARTTEXT {
+		m.Content = parts[0].Text
+		parts = parts[1:]
+	}
+	if len(parts) > 0 {
+		m.Parts = parts
+	}
+	return nil
+}
+
+// contentParts returns Content followed by Parts
+func (m GPTmessage) contentParts() []ContentPart {
+	parts := make([]ContentPart, 0, len(m.Parts)+1)
+	if m.Content != "" {
+		parts = append(parts, TextPart(m.Content))
+	}
+	return append(parts, m.Parts...)
+}
+
+// parseDataURL splits a base64 data URL into its media type and data
+func parseDataURL(url string) (mediaType string, data string, ok bool) {
+	if !strings.HasPrefix(url, "data:") {
+		return "", "", false
+	}
+	meta, data, found := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	if !found || !strings.HasSuffix(meta, ";base64") {
+		return "", "", false
+	}
+	return strings.TrimSuffix(meta, ";base64"), data, true
+}
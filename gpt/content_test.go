@@ -0,0 +1,69 @@
+package gpt
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMessagePartsRoundTrip(t *testing.T) {
+	image := ImageDataPart("image/png", []byte{1, 2})
+	for _, tc := range []struct {
+		message GPTmessage
+		json    string
+	}{
+		{
+			NewImageMessage("what is this?", image),
+			`{"role":"user","content":[{"type":"text","text":"what is this?"},{"type":"image_url","image_url":{"url":"data:image/png;base64,AQI="}}]}`,
+		},
+		{
+			GPTmessage{Role: USERROLE, Parts: []ContentPart{image, TextPart("and this?")}},
+			`{"role":"user","content":[{"type":"image_url","image_url":{"url":"data:image/png;base64,AQI="}},{"type":"text","text":"and this?"}]}`,
+		},
+		{
+			GPTmessage{Role: ASSISTANTROLE, Content: "a logo"},
+			`{"role":"assistant","content":"a logo"}`,
+		},
+	} {
+		data, err := json.Marshal(tc.message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tc.json {
+			t.Errorf("marshalled\n got %s\nwant %s", data, tc.json)
+		}
+		var decoded GPTmessage
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, tc.message) {
+			t.Errorf("round trip of %s = %+v", data, decoded)
+		}
+	}
+
+	var decoded GPTmessage
+	if err := json.Unmarshal([]byte(`{"role":"assistant","content":null,"tool_calls":[{"id":"c1","type":"function","function":{"name":"f","arguments":"{}"}}]}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Content != "" || decoded.Parts != nil || len(decoded.ToolCalls) != 1 {
+		t.Errorf("decoded %+v", decoded)
+	}
+	if err := json.Unmarshal([]byte(`{"role":"user","content":42}`), &decoded); err == nil {
+		t.Error("a numeric content was accepted")
+	}
+}
+
+func TestDiskCacheKeepsMessageParts(t *testing.T) {
+	store, err := NewDiskCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewResponseCache(store, time.Hour)
+	message := NewImageMessage("what is this?", ImagePart("https://example.com/logo.png"))
+	cache.set("key", message)
+	var cached GPTmessage
+	if !cache.get("key", &cached) || !reflect.DeepEqual(cached, message) {
+		t.Errorf("cached %+v, want %+v", cached, message)
+	}
+}
@@ -0,0 +1,147 @@
+package gpt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIError is returned when an LLM API replies with an error.
+// The more specific error types below wrap it, so errors.As works for both.
+type APIError struct {
+	StatusCode int
+	Type       string
+	Code       string
+	Message    string
+	// RetryAfter is the wait requested by the server, if any
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	if e.Code != "" {
+		return fmt.Sprintf("API error %d (%s): %s", e.StatusCode, e.Code, msg)
+	}
+	return fmt.Sprintf("API error %d: %s", e.StatusCode, msg)
+}
+
+// Retryable reports whether repeating the request may succeed
+func (e *APIError) Retryable() bool {
+	if e.Code == "insufficient_quota" {
+		return false
+	}
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// RateLimitError is returned for 429 replies, including exhausted quota
+type RateLimitError struct{ *APIError }
+
+// AuthError is returned when the API key is missing, invalid or not allowed to use the model
+type AuthError struct{ *APIError }
+
+// ContextLengthError is returned when the prompt does not fit in the model context window
+type ContextLengthError struct{ *APIError }
+
+// ContentFilterError is returned when the prompt or the reply was blocked by a content filter
+type ContentFilterError struct{ *APIError }
+
+// OverloadedError is returned for server side failures (5xx), usually temporary
+type OverloadedError struct{ *APIError }
+
+func (e *RateLimitError) Unwrap() error     { return e.APIError }
+func (e *AuthError) Unwrap() error          { return e.APIError }
+func (e *ContextLengthError) Unwrap() error { return e.APIError }
+func (e *ContentFilterError) Unwrap() error { return e.APIError }
+func (e *OverloadedError) Unwrap() error    { return e.APIError }
+
+// apiErrorBody matches the error envelope of both the OpenAI and Anthropic APIs
+type apiErrorBody struct {
+	Error *struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+		Code    any    `json:"code"`
+	} `json:"error"`
+}
+
+// newAPIError classifies an error reply into one of the typed errors
+func newAPIError(statusCode int, header http.Header, body []byte) error {
+	apiErr := &APIError{StatusCode: statusCode, RetryAfter: retryAfter(header)}
+
+	var envelope apiErrorBody
+	if json.Unmarshal(body, &envelope) == nil && envelope.Error != nil {
+		apiErr.Message = envelope.Error.Message
+		apiErr.Type = envelope.Error.Type
+		if envelope.Error.Code != nil {
+			apiErr.Code = fmt.Sprint(envelope.Error.Code)
+		}
+	} else if len(body) > 0 {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	return classifyAPIError(apiErr)
+}
+
+func classifyAPIError(apiErr *APIError) error {
+	msg := strings.ToLower(apiErr.Message)
+	switch {
+	case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden,
+		apiErr.Type == "authentication_error", apiErr.Type == "permission_error":
+		return &AuthError{apiErr}
+	case apiErr.StatusCode == http.StatusTooManyRequests, apiErr.Type == "rate_limit_error":
+		return &RateLimitError{apiErr}
+	case apiErr.Code == "context_length_exceeded",
+		strings.Contains(msg, "maximum context length"),
+		strings.Contains(msg, "prompt is too long"):
+		return &ContextLengthError{apiErr}
+	case apiErr.Code == "content_filter", apiErr.Code == "content_policy_violation":
+		return &ContentFilterError{apiErr}
+	case apiErr.StatusCode >= http.StatusInternalServerError, apiErr.Type == "overloaded_error":
+		return &OverloadedError{apiErr}
+	}
+	return apiErr
+}
+
+// contentFilterError is returned when a completion finished because of the content filter
+func contentFilterError() error {
+	return &ContentFilterError{&APIError{StatusCode: http.StatusOK, Code: "content_filter", Message: "reply was blocked by the content filter"}}
+}
+
+// retryAfter reads the Retry-After (seconds or HTTP date) and retry-after-ms headers
+func retryAfter(header http.Header) time.Duration {
+	if header == nil {
+		return 0
+	}
+	if ms := header.Get("retry-after-ms"); ms != "" {
+		if v, err := strconv.ParseFloat(ms, 64); err == nil && v > 0 {
+			return time.Duration(v * float64(time.Millisecond))
+		}
+	}
+	ra := header.Get("Retry-After")
+	if ra == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseFloat(ra, 64); err == nil && secs > 0 {
+		return time.Duration(secs * float64(time.Second))
+	}
+	if t, err := http.ParseTime(ra); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// IsRetryable reports whether err is a temporary failure worth retrying later
+func IsRetryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+	return false
+}
@@ -0,0 +1,390 @@
+package gpt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// error classes used to decide when Fallback moves on to the next backend
+const (
+	ERRRATELIMIT     = "rate_limit"
+	ERROVERLOADED    = "overloaded"
+	ERRAUTH          = "auth"
+	ERRCONTEXTLENGTH = "context_length"
+	ERRCONTENTFILTER = "content_filter"
+	ERRNOTFOUND      = "not_found"
+	ERRNETWORK       = "network"
+)
+
+const (
+	// DefaultBreakerFailures is the number of consecutive failures that opens a circuit
+	DefaultBreakerFailures = 3
+	// DefaultBreakerCooldown is how long an open circuit rejects calls before a trial call
+	DefaultBreakerCooldown = time.Minute
+
+	BREAKERCLOSED   = "closed"
+	BREAKEROPEN     = "open"
+	BREAKERHALFOPEN = "half-open"
+)
+
+// DefaultFallbackClasses are the error classes that move a call to the next backend
+var DefaultFallbackClasses = []string{ERRRATELIMIT, ERROVERLOADED, ERRNOTFOUND, ERRNETWORK}
+
+// unhealthyClasses are the error classes that count as failures of the backend itself
+var unhealthyClasses = map[string]bool{ERRRATELIMIT: true, ERROVERLOADED: true, ERRAUTH: true, ERRNOTFOUND: true, ERRNETWORK: true}
+
+// ErrCircuitOpen is returned when every backend of a Fallback is cooling down
+var ErrCircuitOpen = errors.New("all LLM backends are unavailable")
+
+// ErrorClass returns the class of an LLM error (one of the ERR* constants), or "" for
+// errors such as cancelled calls or invalid requests that no other backend would fix
+func ErrorClass(err error) string {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ""
+	}
+	var rateErr *RateLimitError
+	var overloadedErr *OverloadedError
+	var authErr *AuthError
+	var contextErr *ContextLengthError
+	var filterErr *ContentFilterError
+	var apiErr *APIError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &rateErr):
+		return ERRRATELIMIT
+	case errors.As(err, &overloadedErr):
+		return ERROVERLOADED
+	case errors.As(err, &authErr):
+		return ERRAUTH
+	case errors.As(err, &contextErr):
+		return ERRCONTEXTLENGTH
+	case errors.As(err, &filterErr):
+		return ERRCONTENTFILTER
+	case errors.As(err, &apiErr):
+		if apiErr.StatusCode == http.StatusNotFound || apiErr.Code == "model_not_found" || apiErr.Type == "not_found_error" {
+			return ERRNOTFOUND
+		}
+	case errors.As(err, &netErr):
+		return ERRNETWORK
+	}
+	return ""
+}
+
+// CircuitBreaker stops calls to a failing backend for a cooldown period. After the
+// cooldown a trial call is let through; its success closes the circuit again.
+type CircuitBreaker struct {
+	Name     string
+	Failures int
+	Cooldown time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failed   int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker creates a breaker, zero values select the defaults
+func NewCircuitBreaker(name string, failures int, cooldown time.Duration) *CircuitBreaker {
+	if failures <= 0 {
+		failures = DefaultBreakerFailures
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultBreakerCooldown
+	}
+	return &CircuitBreaker{Name: name, Failures: failures, Cooldown: cooldown, state: BREAKERCLOSED}
+}
+
+// State returns BREAKERCLOSED, BREAKEROPEN or BREAKERHALFOPEN
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Allow reports whether a call may be made
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BREAKEROPEN:
+		if time.Since(b.openedAt) < b.Cooldown {
+			return false
+		}
+		b.transition(BREAKERHALFOPEN)
+		return true
+	case BREAKERHALFOPEN:
+		// a trial call is in flight
+		return false
+	}
+	return true
+}
+
+// Success records a successful call
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failed = 0
+	b.transition(BREAKERCLOSED)
+}
+
+// Failure records a failed call
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failed++
+	if b.state == BREAKERHALFOPEN || b.failed >= b.Failures {
+		b.openedAt = time.Now()
+		b.transition(BREAKEROPEN)
+	}
+}
+
+// Release records a call whose outcome says nothing about the backend health,
+// e.g. a cancelled call, so a half-open circuit can try again
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BREAKERHALFOPEN {
+		b.openedAt = time.Now().Add(-b.Cooldown)
+		b.state = BREAKEROPEN
+	}
+}
+
+func (b *CircuitBreaker) transition(state string) {
+	if b.state == state {
+		return
+	}
+	log.Printf("LLM backend %s circuit %s -> %s", b.Name, b.state, state)
+	b.state = state
+}
+
+// FallbackBackend is an entry of a fallback chain
+type FallbackBackend struct {
+	Name string
+	LLM  LLM
+	// Breaker defaults to NewCircuitBreaker(Name, 0, 0). Pass a long lived breaker when
+	// the chain is rebuilt per request so the circuit state survives.
+	Breaker *CircuitBreaker
+}
+
+// Fallback is an LLM that tries its backends in order, moving on to the next one when a
+// call fails with one of the fallback error classes. Embeddings always use the first
+// backend, or the LLM set with SetEmbedder, as vectors of different models can't be
+// compared. The backends should not retry themselves, except the last one, or a call
+// waits out their backoff before falling over.
+type Fallback struct {
+	backends []FallbackBackend
+	classes  map[string]bool
+	embedder LLM
+}
+
+var (
+	_ LLM       = (*Fallback)(nil)
+	_ Responder = (*Fallback)(nil)
+)
+
+// NewFallback creates a fallback chain, the first backend is the primary
+func NewFallback(backends ...FallbackBackend) *Fallback {
+	f := &Fallback{}
+	for i, backend := range backends {
+		if backend.Name == "" {
+			backend.Name = fmt.Sprintf("backend-%d", i)
+		}
+		if backend.Breaker == nil {
+			backend.Breaker = NewCircuitBreaker(backend.Name, 0, 0)
+		}
+		f.backends = append(f.backends, backend)
+	}
+	f.SetFallbackClasses(DefaultFallbackClasses)
+	return f
+}
+
+// SetFallbackClasses sets the error classes (ERR* constants) that move a call to the next backend
+func (f *Fallback) SetFallbackClasses(classes []string) {
+	f.classes = make(map[string]bool, len(classes))
+	for _, class := range classes {
+		f.classes[class] = true
+	}
+}
+
+// call runs fn on the backends in order until one succeeds or fails with an error that
+// should not fall over. retry reports whether the next backend may still be tried.
+func (f *Fallback) call(ctx context.Context, fn func(index int, llm LLM) error, retry func() bool) error {
+	var lastErr error
+	for i, backend := range f.backends {
+		if !backend.Breaker.Allow() {
+			continue
+		}
+		err := fn(i, backend.LLM)
+		class := ErrorClass(err)
+		switch {
+		case errors.Is(err, ErrResponsesUnsupported):
+			// the backend can't serve this call, which says nothing about its health
+			backend.Breaker.Release()
+			if lastErr == nil {
+				lastErr = err
+			}
+			continue
+		case err == nil:
+			backend.Breaker.Success()
+			return nil
+		case class == "":
+			// the request itself is at fault, or it was cancelled
+			backend.Breaker.Release()
+			return err
+		case unhealthyClasses[class]:
+			backend.Breaker.Failure()
+		default:
+			backend.Breaker.Release()
+		}
+		lastErr = err
+		if !f.classes[class] || ctx.Err() != nil || (retry != nil && !retry()) {
+			return err
+		}
+		if i < len(f.backends)-1 {
+			log.Printf("LLM backend %s failed (%v), falling back", backend.Name, err)
+		}
+	}
+	if lastErr == nil {
+		return ErrCircuitOpen
+	}
+	return lastErr
+}
+
+func (f *Fallback) GptQuery(systemPrompt string, message string, userContext string) (string, error) {
+	return f.GptQueryContext(context.Background(), systemPrompt, message, userContext)
+}
+
+func (f *Fallback) GptQueryContext(ctx context.Context, systemPrompt string, message string, userContext string) (string, error) {
+	var reply string
+	err := f.call(ctx, func(_ int, llm LLM) error {
+		var err error
+		reply, err = llm.GptQueryContext(ctx, systemPrompt, message, userContext)
+		return err
+	}, nil)
+	return reply, err
+}
+
+func (f *Fallback) Chat(messages []GPTmessage, opts *ChatOptions) (GPTmessage, error) {
+	return f.ChatContext(context.Background(), messages, opts)
+}
+
+// ChatContext sends the conversation to the first available backend. opts.Model is
+// cleared when falling back, as it names a model of the primary backend.
+func (f *Fallback) ChatContext(ctx context.Context, messages []GPTmessage, opts *ChatOptions) (GPTmessage, error) {
+	var reply GPTmessage
+	err := f.call(ctx, func(i int, llm LLM) error {
+		var err error
+		reply, err = llm.ChatContext(ctx, messages, fallbackOptions(opts, i))
+		return err
+	}, nil)
+	return reply, err
+}
+
+func (f *Fallback) ChatStream(messages []GPTmessage, opts *ChatOptions, onDelta func(delta string)) (GPTmessage, error) {
+	return f.ChatStreamContext(context.Background(), messages, opts, onDelta)
+}
+
+// ChatStreamContext streams from the first available backend. Once text was streamed
+// the call no longer falls back, as the partial reply was already shown.
+func (f *Fallback) ChatStreamContext(ctx context.Context, messages []GPTmessage, opts *ChatOptions, onDelta func(delta string)) (GPTmessage, error) {
+	var reply GPTmessage
+	streamed := false
+	err := f.call(ctx, func(i int, llm LLM) error {
+		var err error
+		reply, err = llm.ChatStreamContext(ctx, messages, fallbackOptions(opts, i), func(delta string) {
+			streamed = true
+			if onDelta != nil {
+				onDelta(delta)
+			}
+		})
+		return err
+	}, func() bool { return !streamed })
+	return reply, err
+}
+
+func (f *Fallback) Respond(input []GPTmessage, opts *ResponseOptions) (*Response, error) {
+	return f.RespondContext(context.Background(), input, opts)
+}
+
+// RespondContext sends a Responses call to the first available backend supporting it,
+// skipping the others. A call continuing a stored response with PreviousResponseID does
+// not fall back, as the other backends don't have the response.
+func (f *Fallback) RespondContext(ctx context.Context, input []GPTmessage, opts *ResponseOptions) (*Response, error) {
+	var response *Response
+	err := f.call(ctx, func(i int, llm LLM) error {
+		responder, ok := llm.(Responder)
+		if !ok {
+			return ErrResponsesUnsupported
+		}
+		var err error
+		response, err = responder.RespondContext(ctx, input, responseFallbackOptions(opts, i))
+		return err
+	}, func() bool { return opts == nil || opts.PreviousResponseID == "" })
+	return response, err
+}
+
+func (f *Fallback) GetEmbedding(text string) ([]float32, error) {
+	return f.GetEmbeddingContext(context.Background(), text)
+}
+
+func (f *Fallback) GetEmbeddingContext(ctx context.Context, text string) ([]float32, error) {
+	embedder := f.embeddingLLM()
+	if embedder == nil {
+		return nil, ErrCircuitOpen
+	}
+	return embedder.GetEmbeddingContext(ctx, text)
+}
+
+func (f *Fallback) GetEmbeddingsBatch(texts []string) ([][]float32, error) {
+	return f.GetEmbeddingsBatchContext(context.Background(), texts)
+}
+
+func (f *Fallback) GetEmbeddingsBatchContext(ctx context.Context, texts []string) ([][]float32, error) {
+	embedder := f.embeddingLLM()
+	if embedder == nil {
+		return nil, ErrCircuitOpen
+	}
+	return embedder.GetEmbeddingsBatchContext(ctx, texts)
+}
+
+// SetEmbedder sends the embeddings to llm instead of the first backend, e.g. a client of
+// the same model that keeps its retries
+func (f *Fallback) SetEmbedder(llm LLM) {
+	f.embedder = llm
+}
+
+func (f *Fallback) embeddingLLM() LLM {
+	if f.embedder != nil {
+		return f.embedder
+	}
+	if len(f.backends) == 0 {
+		return nil
+	}
+	return f.backends[0].LLM
+}
+
+// responseFallbackOptions drops the model override for the backends after the primary
+func responseFallbackOptions(opts *ResponseOptions, index int) *ResponseOptions {
+	if index == 0 || opts == nil || opts.Model == "" {
+		return opts
+	}
+	copied := *opts
+	copied.Model = ""
+	return &copied
+}
+
+// fallbackOptions drops the model override for the backends after the primary
+func fallbackOptions(opts *ChatOptions, index int) *ChatOptions {
+	if index == 0 || opts == nil || opts.Model == "" {
+		return opts
+	}
+	copied := *opts
+	copied.Model = ""
+	return &copied
+}
@@ -1,15 +1,14 @@
 package gpt
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
-
-	gpt "github.com/ayush6624/go-chatgpt"
+	"strings"
 )
 
 const (
@@ -23,14 +22,28 @@ const (
 )
 
 type GPTmessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	// Parts holds images (or more text) following Content, see MarshalJSON and UnmarshalJSON
+	Parts []ContentPart `json:"-"`
 }
 
 type OpenAI struct {
-	apiKey string
-	model  string
-	url    string
+	transport
+	apiKey          string
+	model           string
+	url             string
+	embedURL        string
+	embedModel      string
+	transcribeURL   string
+	transcribeModel string
+	moderateURL     string
+	responsesURL    string
+	azure           bool
+	// compatible is set for OpenAI compatible servers, which have no moderation endpoint
+	compatible bool
 }
 
 // embeddingResponse represents the OpenAI embedding API response
@@ -39,11 +52,15 @@ type embeddingResponse struct {
 		Embedding []float32 `json:"embedding"`
 		Index     int       `json:"index,omitempty"`
 	} `json:"data"`
+	Model string       `json:"model,omitempty"`
+	Usage *openAIUsage `json:"usage,omitempty"`
 	Error *struct {
 		Message string `json:"message"`
 	} `json:"error,omitempty"`
 }
 
+// SetURL sets the chat completions endpoint. Unless SetEmbeddingURL is used, the
+// embeddings endpoint is derived from it by replacing /chat/completions with /embeddings
 func (o *OpenAI) SetURL(url string) {
 	o.url = url
 }
@@ -60,7 +77,59 @@ func (o *OpenAI) SetModel(model string) {
 	o.model = model
 }
 
-func (o *OpenAI) GptQuery(systemPrompt string, message string, context string) (string, error) {
+// SetEmbeddingURL overrides the embeddings endpoint
+func (o *OpenAI) SetEmbeddingURL(url string) {
+	o.embedURL = url
+}
+
+// SetEmbeddingModel overrides the model used for embeddings
+func (o *OpenAI) SetEmbeddingModel(model string) {
+	o.embedModel = model
+}
+
+func (o *OpenAI) chatURL() string {
+	if o.url == "" {
+		return OPENAIURL
+	}
+	return o.url
+}
+
+func (o *OpenAI) embeddingURL() string {
+	if o.embedURL != "" {
+		return o.embedURL
+	}
+	if o.url != "" {
+		return strings.TrimSuffix(strings.TrimRight(o.url, "/"), chatCompletionsEP) + embeddingsEP
+	}
+	return OPENAIEMBEDURL
+}
+
+func (o *OpenAI) embeddingModel() string {
+	if o.embedModel == "" {
+		return MODELEMBEDDING
+	}
+	return o.embedModel
+}
+
+// setHeaders sets the content type and the authentication header expected by the endpoint
+func (o *OpenAI) setHeaders(req *http.Request) {
+	req.Header.Set("Content-Type", "application/json")
+	if o.apiKey == "" {
+		return
+	}
+	if o.azure {
+		req.Header.Set("api-key", o.apiKey)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+o.apiKey)
+}
+
+func (o *OpenAI) GptQuery(systemPrompt string, message string, userContext string) (string, error) {
+	return o.GptQueryContext(context.Background(), systemPrompt, message, userContext)
+}
+
+// GptQueryContext is GptQuery with a context to cancel the call or set a deadline
+func (o *OpenAI) GptQueryContext(ctx context.Context, systemPrompt string, message string, userContext string) (string, error) {
 
 	systemMessage := GPTmessage{
 		Role:    SYSTEMROLE,
@@ -74,14 +143,14 @@ func (o *OpenAI) GptQuery(systemPrompt string, message string, context string) (
 
 	var messages []interface{}
 
-	if context == "" {
+	if userContext == "" {
 		// Only include system and user messages if context is empty
 		messages = []interface{}{systemMessage, userMessage}
 	} else {
 		// Include all three messages if context is provided
 		contextMessage := GPTmessage{
 			Role:    USERROLE,
-			Content: context,
+			Content: userContext,
 		}
 		messages = []interface{}{systemMessage, userMessage, contextMessage}
 	}
@@ -89,115 +158,71 @@ func (o *OpenAI) GptQuery(systemPrompt string, message string, context string) (
 		"model":    o.model,
 		"messages": messages,
 	}
-	return o.gptSend(data)
+	return o.gptSend(ctx, data)
 
 }
 
-func (o *OpenAI) gptSend(data map[string]interface{}) (string, error) {
-
-	jsonData, _ := json.Marshal(data)
-
-	url := o.url
-	if url == "" {
-		url = OPENAIURL
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Println("Failed to create API request " + err.Error())
-		return "", err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+o.apiKey)
-
-	client := http.DefaultClient
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("Failed to make API request" + err.Error())
-		return "", err
-	}
-
-	defer resp.Body.Close()
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println("Failed to read API response " + err.Error())
-		return "", err
-	}
-
-	var gptMesg gpt.ChatResponse
-	err = json.Unmarshal(respBody, &gptMesg)
+func (o *OpenAI) gptSend(ctx context.Context, data map[string]interface{}) (string, error) {
+	reply, err := o.sendChat(ctx, data)
 	if err != nil {
-		fmt.Printf("Error unmarshalling JSON: %v\n", err)
+		log.Printf("GPT query failed: %v", err)
 		return "", err
 	}
-
-	var reply string
-	if len(gptMesg.Choices) > 0 {
-		reply = gptMesg.Choices[0].Message.Content
-	} else {
-		log.Printf("%v\n", gptMesg)
-		return "", errors.New("no reply")
-	}
-
-	return reply, nil
-
+	return reply.Content, nil
 }
 
 // GetEmbedding generates an embedding vector for the given text using OpenAI's embedding API
 func (o *OpenAI) GetEmbedding(text string) ([]float32, error) {
-	data := map[string]interface{}{
-		"model": MODELEMBEDDING,
-		"input": text,
-	}
-
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
+	return o.GetEmbeddingContext(context.Background(), text)
+}
 
-	req, err := http.NewRequest("POST", OPENAIEMBEDURL, bytes.NewBuffer(jsonData))
+// GetEmbeddingContext is GetEmbedding with a context to cancel the call or set a deadline
+func (o *OpenAI) GetEmbeddingContext(ctx context.Context, text string) ([]float32, error) {
+	embeddingResp, err := o.embed(ctx, text)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create API request: %w", err)
+		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+o.apiKey)
 
-	client := http.DefaultClient
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make API request: %w", err)
+	if len(embeddingResp.Data) == 0 || len(embeddingResp.Data[0].Embedding) == 0 {
+		return nil, errors.New("empty embedding response")
 	}
-	defer resp.Body.Close()
 
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read API response: %w", err)
-	}
+	return embeddingResp.Data[0].Embedding, nil
+}
 
-	// Parse the embedding response
-	var embeddingResp embeddingResponse
+// GetEmbeddingsBatch generates embedding vectors for multiple texts in a single API call
+func (o *OpenAI) GetEmbeddingsBatch(texts []string) ([][]float32, error) {
+	return o.GetEmbeddingsBatchContext(context.Background(), texts)
+}
 
-	err = json.Unmarshal(respBody, &embeddingResp)
+// GetEmbeddingsBatchContext is GetEmbeddingsBatch with a context to cancel the call or set a deadline
+func (o *OpenAI) GetEmbeddingsBatchContext(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddingResp, err := o.embed(ctx, texts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+		return nil, err
 	}
 
-	if embeddingResp.Error != nil {
-		return nil, fmt.Errorf("OpenAI API error: %s", embeddingResp.Error.Message)
+	if len(embeddingResp.Data) == 0 {
+		return nil, errors.New("empty embedding response")
 	}
 
-	if len(embeddingResp.Data) == 0 || len(embeddingResp.Data[0].Embedding) == 0 {
-		return nil, errors.New("empty embedding response")
+	// Sort by index to maintain order
+	embeddings := make([][]float32, len(embeddingResp.Data))
+	for _, item := range embeddingResp.Data {
+		if item.Index < 0 || item.Index >= len(embeddings) {
+			return nil, fmt.Errorf("embedding index %d out of range", item.Index)
+		}
+		embeddings[item.Index] = item.Embedding
 	}
 
-	return embeddingResp.Data[0].Embedding, nil
+	return embeddings, nil
 }
 
-// GetEmbeddingsBatch generates embedding vectors for multiple texts in a single API call
-func (o *OpenAI) GetEmbeddingsBatch(texts []string) ([][]float32, error) {
+// embed calls the embeddings endpoint with a single text or a batch of texts
+func (o *OpenAI) embed(ctx context.Context, input interface{}) (*embeddingResponse, error) {
 	data := map[string]interface{}{
-		"model": MODELEMBEDDING,
-		"input": texts,
+		"model": o.embeddingModel(),
+		"input": input,
 	}
 
 	jsonData, err := json.Marshal(data)
@@ -205,17 +230,9 @@ func (o *OpenAI) GetEmbeddingsBatch(texts []string) ([][]float32, error) {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", OPENAIEMBEDURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create API request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+o.apiKey)
-
-	client := http.DefaultClient
-	resp, err := client.Do(req)
+	resp, err := o.post(ctx, o.embeddingURL(), jsonData, o.setHeaders)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make API request: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
@@ -233,18 +250,11 @@ func (o *OpenAI) GetEmbeddingsBatch(texts []string) ([][]float32, error) {
 	}
 
 	if embeddingResp.Error != nil {
-		return nil, fmt.Errorf("OpenAI API error: %s", embeddingResp.Error.Message)
+		return nil, newAPIError(resp.StatusCode, resp.Header, respBody)
 	}
-
-	if len(embeddingResp.Data) == 0 {
-		return nil, errors.New("empty embedding response")
+	if embeddingResp.Usage != nil {
+		o.recordUsage(ctx, embeddingResp.Usage.toUsage(o.usageModel(embeddingResp.Model, data)))
 	}
 
-	// Sort by index to maintain order
-	embeddings := make([][]float32, len(embeddingResp.Data))
-	for _, item := range embeddingResp.Data {
-		embeddings[item.Index] = item.Embedding
-	}
-
-	return embeddings, nil
+	return &embeddingResp, nil
 }
@@ -0,0 +1,107 @@
+package gpttest
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/vtuson/slackagent/gpt"
+)
+
+// MalformedJSON is the body sent by Malformed replies
+const MalformedJSON = `{"choices": [{"message": {"role": "assistant", "content": "trunc`
+
+// Reply is a scripted answer of the fake server. A zero Status sends a successful reply
+// with Content and ToolCalls, or Embeddings on the embeddings endpoint.
+type Reply struct {
+	Content      string
+	ToolCalls    []gpt.ToolCall
+	FinishReason string
+	// Chunks are the content deltas of a streamed reply, defaults to Content split in words
+	Chunks []string
+	// Embeddings answer an embeddings request, one per input. Missing vectors are derived
+	// from the input text.
+	Embeddings [][]float32
+	// PromptTokens and CompletionTokens are reported as the usage of the call
+	PromptTokens     int
+	CompletionTokens int
+
+	// Status sends an API error with this status code and ErrorMessage
+	Status       int
+	ErrorType    string
+	ErrorCode    string
+	ErrorMessage string
+	// Header is added to the reply, e.g. Retry-After on a 429
+	Header http.Header
+	// Raw replaces the body, e.g. MalformedJSON
+	Raw string
+	// Delay holds the reply back, to test timeouts and cancellation
+	Delay time.Duration
+}
+
+// Text is a reply with content
+func Text(content string) Reply {
+	return Reply{Content: content}
+}
+
+// ToolCall is a reply calling the function name with args marshalled to JSON
+func ToolCall(name string, args interface{}) Reply {
+	return Reply{ToolCalls: []gpt.ToolCall{NewToolCall("call_"+name, name, args)}}
+}
+
+// NewToolCall builds a tool call, e.g. to script several calls in one reply
+func NewToolCall(id string, name string, args interface{}) gpt.ToolCall {
+	call := gpt.ToolCall{ID: id, Type: gpt.TOOLFUNCTION}
+	call.Function.Name = name
+	switch a := args.(type) {
+	case string:
+		call.Function.Arguments = a
+	case nil:
+		call.Function.Arguments = "{}"
+	default:
+		data, err := json.Marshal(a)
+		if err != nil {
+			panic("gpttest: failed to marshal tool call arguments: " + err.Error())
+		}
+		call.Function.Arguments = string(data)
+	}
+	return call
+}
+
+// Embedding is a reply to an embeddings request with the given vectors
+func Embedding(vectors ...[]float32) Reply {
+	return Reply{Embeddings: vectors}
+}
+
+// Error is an API error reply
+func Error(status int, message string) Reply {
+	return Reply{Status: status, ErrorMessage: message}
+}
+
+// RateLimited is a 429 reply asking to retry after retryAfter, which the client honors
+func RateLimited(retryAfter time.Duration) Reply {
+	r := Error(http.StatusTooManyRequests, "Rate limit reached")
+	r.ErrorType = "rate_limit_error"
+	r.Header = http.Header{"Retry-After-Ms": {formatMillis(retryAfter)}}
+	return r
+}
+
+// ServerError is a 500 reply
+func ServerError() Reply {
+	r := Error(http.StatusInternalServerError, "The server had an error while processing your request")
+	r.ErrorType = "server_error"
+	return r
+}
+
+// ContextLengthExceeded is the 400 reply to a prompt over the context window
+func ContextLengthExceeded() Reply {
+	r := Error(http.StatusBadRequest, "This model's maximum context length is 8192 tokens")
+	r.ErrorType = "invalid_request_error"
+	r.ErrorCode = "context_length_exceeded"
+	return r
+}
+
+// Malformed is a successful reply whose body is not valid JSON
+func Malformed() Reply {
+	return Reply{Raw: MalformedJSON}
+}
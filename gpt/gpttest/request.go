@@ -0,0 +1,211 @@
+package gpttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/vtuson/slackagent/gpt"
+)
+
+// Request is a request received by the fake server
+type Request struct {
+	Path   string
+	Header http.Header
+	// Body is the raw JSON body, use Decode for fields without an accessor
+	Body []byte
+
+	Model    string
+	Stream   bool
+	Messages []gpt.GPTmessage
+	Tools    []gpt.Tool
+	// Input are the texts of an embeddings request
+	Input []string
+}
+
+// requestBody is the part of chat and embeddings requests decoded into Request
+type requestBody struct {
+	Model    string            `json:"model"`
+	Stream   bool              `json:"stream"`
+	Messages []json.RawMessage `json:"messages"`
+	Tools    []gpt.Tool        `json:"tools"`
+	Input    json.RawMessage   `json:"input"`
+}
+
+func parseRequest(r *http.Request, body []byte) (Request, error) {
+	req := Request{Path: r.URL.Path, Header: r.Header.Clone(), Body: body}
+	var rb requestBody
+	if err := json.Unmarshal(body, &rb); err != nil {
+		return req, fmt.Errorf("failed to decode request body: %w", err)
+	}
+	req.Model, req.Stream, req.Tools = rb.Model, rb.Stream, rb.Tools
+	for _, raw := range rb.Messages {
+		m, err := parseMessage(raw)
+		if err != nil {
+			return req, err
+		}
+		req.Messages = append(req.Messages, m)
+	}
+	if len(rb.Input) > 0 {
+		var text string
+		if json.Unmarshal(rb.Input, &text) == nil {
+			req.Input = []string{text}
+		} else if err := json.Unmarshal(rb.Input, &req.Input); err != nil {
+			return req, fmt.Errorf("input is neither a string nor a list of strings: %w", err)
+		}
+	}
+	return req, nil
+}
+
+// parseMessage decodes a message whose content is a string or a list of content parts.
+// The text parts are joined into Content and the others kept in Parts.
+func parseMessage(raw json.RawMessage) (gpt.GPTmessage, error) {
+	var m struct {
+		Role       string          `json:"role"`
+		Content    json.RawMessage `json:"content"`
+		ToolCalls  []gpt.ToolCall  `json:"tool_calls"`
+		ToolCallID string          `json:"tool_call_id"`
+	}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return gpt.GPTmessage{}, fmt.Errorf("failed to decode message: %w", err)
+	}
+	message := gpt.GPTmessage{Role: m.Role, ToolCalls: m.ToolCalls, ToolCallID: m.ToolCallID}
+	if len(m.Content) == 0 || json.Unmarshal(m.Content, &message.Content) == nil {
+		return message, nil
+	}
+	var parts []gpt.ContentPart
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return gpt.GPTmessage{}, fmt.Errorf("message content is neither text nor parts: %w", err)
+	}
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		} else {
+			message.Parts = append(message.Parts, part)
+		}
+	}
+	message.Content = strings.Join(texts, "\n")
+	return message, nil
+}
+
+// Decode unmarshals the body into v, e.g. to check options such as temperature
+func (r Request) Decode(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// LastMessage returns the last message of a chat request
+func (r Request) LastMessage() gpt.GPTmessage {
+	if len(r.Messages) == 0 {
+		return gpt.GPTmessage{}
+	}
+	return r.Messages[len(r.Messages)-1]
+}
+
+// HasTool reports whether the request offers the function name to the model
+func (r Request) HasTool(name string) bool {
+	for _, tool := range r.Tools {
+		if tool.Function.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains reports whether a message with role contains text, any role when role is empty
+func (r Request) Contains(role string, text string) bool {
+	for _, m := range r.Messages {
+		if (role == "" || m.Role == role) && strings.Contains(m.Content, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Requests returns the requests received so far, in order
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// ChatRequests returns the chat completions requests received so far
+func (s *Server) ChatRequests() []Request {
+	return s.requestsTo(CHATPATH)
+}
+
+// EmbeddingRequests returns the embeddings requests received so far
+func (s *Server) EmbeddingRequests() []Request {
+	return s.requestsTo(EMBEDDINGPATH)
+}
+
+func (s *Server) requestsTo(path string) []Request {
+	var requests []Request
+	for _, r := range s.Requests() {
+		if r.Path == path {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+// LastChatRequest returns the last chat completions request, it fails the test when
+// there is none
+func (s *Server) LastChatRequest(t testing.TB) Request {
+	t.Helper()
+	requests := s.ChatRequests()
+	if len(requests) == 0 {
+		t.Fatalf("gpttest: no chat request received")
+	}
+	return requests[len(requests)-1]
+}
+
+// AssertChatCalls fails the test unless n chat requests were received
+func (s *Server) AssertChatCalls(t testing.TB, n int) {
+	t.Helper()
+	if got := len(s.ChatRequests()); got != n {
+		t.Errorf("gpttest: got %d chat requests, want %d", got, n)
+	}
+}
+
+// AssertEmbeddingCalls fails the test unless n embeddings requests were received
+func (s *Server) AssertEmbeddingCalls(t testing.TB, n int) {
+	t.Helper()
+	if got := len(s.EmbeddingRequests()); got != n {
+		t.Errorf("gpttest: got %d embeddings requests, want %d", got, n)
+	}
+}
+
+// AssertPrompt fails the test unless the last chat request has a message with role
+// containing text, any role when role is empty
+func (s *Server) AssertPrompt(t testing.TB, role string, text string) {
+	t.Helper()
+	if r := s.LastChatRequest(t); !r.Contains(role, text) {
+		t.Errorf("gpttest: no message%s contains %q in %s", roleSuffix(role), text, r.Body)
+	}
+}
+
+// AssertTool fails the test unless the last chat request offers the function name
+func (s *Server) AssertTool(t testing.TB, name string) {
+	t.Helper()
+	if r := s.LastChatRequest(t); !r.HasTool(name) {
+		t.Errorf("gpttest: tool %q not offered in %s", name, r.Body)
+	}
+}
+
+// AssertDone fails the test when scripted replies were not sent
+func (s *Server) AssertDone(t testing.TB) {
+	t.Helper()
+	if n := s.Pending(); n > 0 {
+		t.Errorf("gpttest: %d scripted replies not sent", n)
+	}
+}
+
+func roleSuffix(role string) string {
+	if role == "" {
+		return ""
+	}
+	return " with role " + role
+}
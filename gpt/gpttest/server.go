@@ -0,0 +1,386 @@
+package gpttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vtuson/slackagent/gpt"
+)
+
+// endpoints served under BaseURL
+const (
+	CHATPATH      = "/v1/chat/completions"
+	EMBEDDINGPATH = "/v1/embeddings"
+)
+
+const (
+	// TestKey is the API key of the clients returned by Client
+	TestKey = "test-key"
+	// DefaultDimensions is the size of the embeddings derived from the input text
+	DefaultDimensions = 8
+)
+
+// FastRetryPolicy retries like the default policy without the waits, so injected errors
+// don't slow tests down
+var FastRetryPolicy = gpt.RetryPolicy{MaxRetries: gpt.DefaultRetryPolicy.MaxRetries, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+// Server is a fake OpenAI compatible API for tests. Chat completions, streamed or not, are
+// answered with the scripted replies in order; embeddings with the scripted replies, then
+// with vectors derived from the input text. Every request is kept for assertions.
+type Server struct {
+	*httptest.Server
+	// Dimensions is the size of derived embeddings, defaults to DefaultDimensions
+	Dimensions int
+	// DefaultReply answers chat requests once the script is consumed. When nil such
+	// requests fail the test.
+	DefaultReply *Reply
+
+	t          testing.TB
+	mu         sync.Mutex
+	chat       []Reply
+	embeddings []Reply
+	requests   []Request
+}
+
+// NewServer starts a fake server closed at the end of the test
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	s := &Server{t: t, Dimensions: DefaultDimensions}
+	mux := http.NewServeMux()
+	mux.HandleFunc(CHATPATH, s.handleChat)
+	mux.HandleFunc(EMBEDDINGPATH, s.handleEmbeddings)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("gpttest: unexpected request %s %s", r.Method, r.URL.Path)
+		writeError(w, Error(http.StatusNotFound, "unknown endpoint "+r.URL.Path))
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// BaseURL is the API root to pass to gpt.NewOpenAICompatible or ProviderConfig.URL
+func (s *Server) BaseURL() string {
+	return s.URL + "/v1"
+}
+
+// Client returns an OpenAI client for model pointed at the server, with FastRetryPolicy
+func (s *Server) Client(model string) *gpt.OpenAI {
+	o := gpt.NewOpenAICompatible(s.BaseURL(), TestKey, model)
+	o.SetRetryPolicy(FastRetryPolicy)
+	return o
+}
+
+// Reply queues replies to chat requests
+func (s *Server) Reply(replies ...Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chat = append(s.chat, replies...)
+}
+
+// ReplyEmbeddings queues replies to embeddings requests
+func (s *Server) ReplyEmbeddings(replies ...Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.embeddings = append(s.embeddings, replies...)
+}
+
+// Pending returns the number of scripted replies not sent yet
+func (s *Server) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.chat) + len(s.embeddings)
+}
+
+func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.record(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	var reply Reply
+	switch {
+	case len(s.chat) > 0:
+		reply = s.chat[0]
+		s.chat = s.chat[1:]
+	case s.DefaultReply != nil:
+		reply = *s.DefaultReply
+	default:
+		s.mu.Unlock()
+		s.t.Errorf("gpttest: unexpected chat request, no reply scripted: %s", req.Body)
+		writeError(w, Error(http.StatusInternalServerError, "gpttest: no reply scripted"))
+		return
+	}
+	s.mu.Unlock()
+
+	if !wait(r, reply.Delay) {
+		return
+	}
+	switch {
+	case reply.Status != 0:
+		writeError(w, reply)
+	case reply.Raw != "":
+		writeRaw(w, reply)
+	case req.Stream:
+		writeStream(w, reply, req.Model)
+	default:
+		writeJSON(w, reply, chatCompletion(reply, req.Model))
+	}
+}
+
+func (s *Server) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.record(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	var reply Reply
+	if len(s.embeddings) > 0 {
+		reply = s.embeddings[0]
+		s.embeddings = s.embeddings[1:]
+	}
+	dimensions := s.Dimensions
+	s.mu.Unlock()
+
+	if !wait(r, reply.Delay) {
+		return
+	}
+	switch {
+	case reply.Status != 0:
+		writeError(w, reply)
+	case reply.Raw != "":
+		writeRaw(w, reply)
+	default:
+		data := make([]map[string]interface{}, len(req.Input))
+		tokens := 0
+		for i, text := range req.Input {
+			vector := deriveEmbedding(text, dimensions)
+			if i < len(reply.Embeddings) {
+				vector = reply.Embeddings[i]
+			}
+			data[i] = map[string]interface{}{"object": "embedding", "index": i, "embedding": vector}
+			tokens += len(strings.Fields(text))
+		}
+		if reply.PromptTokens > 0 {
+			tokens = reply.PromptTokens
+		}
+		writeJSON(w, reply, map[string]interface{}{
+			"object": "list",
+			"model":  req.Model,
+			"data":   data,
+			"usage":  map[string]int{"prompt_tokens": tokens, "total_tokens": tokens},
+		})
+	}
+}
+
+// record decodes and keeps a request, it answers 400 to bodies that are not JSON
+func (s *Server) record(w http.ResponseWriter, r *http.Request) (Request, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, Error(http.StatusBadRequest, "failed to read body"))
+		return Request{}, false
+	}
+	req, err := parseRequest(r, body)
+	if err != nil {
+		s.t.Errorf("gpttest: invalid request to %s: %v", r.URL.Path, err)
+		writeError(w, Error(http.StatusBadRequest, err.Error()))
+		return Request{}, false
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+	return req, true
+}
+
+// wait holds the reply back for delay, it returns false when the client went away
+func wait(r *http.Request, delay time.Duration) bool {
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func finishReason(reply Reply) string {
+	switch {
+	case reply.FinishReason != "":
+		return reply.FinishReason
+	case len(reply.ToolCalls) > 0:
+		return "tool_calls"
+	}
+	return "stop"
+}
+
+func chatCompletion(reply Reply, model string) map[string]interface{} {
+	message := map[string]interface{}{"role": gpt.ASSISTANTROLE, "content": reply.Content}
+	if len(reply.ToolCalls) > 0 {
+		message["tool_calls"] = reply.ToolCalls
+	}
+	return map[string]interface{}{
+		"id":      "chatcmpl-gpttest",
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []map[string]interface{}{{"index": 0, "message": message, "finish_reason": finishReason(reply)}},
+		"usage":   usage(reply),
+	}
+}
+
+// writeStream sends the reply as server-sent events: the content in chunks, each tool
+// call in two fragments, then the finish reason, the usage and [DONE]
+func writeStream(w http.ResponseWriter, reply Reply, model string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for name, values := range reply.Header {
+		w.Header()[name] = values
+	}
+	flusher, _ := w.(http.Flusher)
+	send := func(chunk map[string]interface{}) {
+		chunk["id"] = "chatcmpl-gpttest"
+		chunk["object"] = "chat.completion.chunk"
+		chunk["model"] = model
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	delta := func(d map[string]interface{}, finish interface{}) {
+		send(map[string]interface{}{"choices": []map[string]interface{}{{"index": 0, "delta": d, "finish_reason": finish}}})
+	}
+
+	delta(map[string]interface{}{"role": gpt.ASSISTANTROLE, "content": ""}, nil)
+	chunks := reply.Chunks
+	if len(chunks) == 0 {
+		chunks = splitWords(reply.Content)
+	}
+	for _, c := range chunks {
+		delta(map[string]interface{}{"content": c}, nil)
+	}
+	for i, call := range reply.ToolCalls {
+		args := call.Function.Arguments
+		half := len(args) / 2
+		delta(map[string]interface{}{"tool_calls": []map[string]interface{}{{
+			"index": i, "id": call.ID, "type": call.Type,
+			"function": map[string]string{"name": call.Function.Name, "arguments": args[:half]},
+		}}}, nil)
+		delta(map[string]interface{}{"tool_calls": []map[string]interface{}{{
+			"index": i, "function": map[string]string{"arguments": args[half:]},
+		}}}, nil)
+	}
+	delta(map[string]interface{}{}, finishReason(reply))
+	send(map[string]interface{}{"choices": []interface{}{}, "usage": usage(reply)})
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func writeJSON(w http.ResponseWriter, reply Reply, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	for name, values := range reply.Header {
+		w.Header()[name] = values
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeRaw(w http.ResponseWriter, reply Reply) {
+	w.Header().Set("Content-Type", "application/json")
+	for name, values := range reply.Header {
+		w.Header()[name] = values
+	}
+	status := reply.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	io.WriteString(w, reply.Raw)
+}
+
+// writeError sends an error in the OpenAI error envelope, or Raw when set
+func writeError(w http.ResponseWriter, reply Reply) {
+	if reply.Raw != "" {
+		writeRaw(w, reply)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	for name, values := range reply.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(reply.Status)
+	errorType := reply.ErrorType
+	if errorType == "" {
+		errorType = "invalid_request_error"
+	}
+	body := map[string]interface{}{"message": reply.ErrorMessage, "type": errorType}
+	if reply.ErrorCode != "" {
+		body["code"] = reply.ErrorCode
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": body})
+}
+
+func usage(reply Reply) map[string]int {
+	completion := reply.CompletionTokens
+	if completion == 0 {
+		completion = len(strings.Fields(reply.Content))
+	}
+	return map[string]int{
+		"prompt_tokens":     reply.PromptTokens,
+		"completion_tokens": completion,
+		"total_tokens":      reply.PromptTokens + completion,
+	}
+}
+
+// splitWords splits text in chunks of one word with its trailing spaces
+func splitWords(text string) []string {
+	var chunks []string
+	start := 0
+	for i := 1; i < len(text); i++ {
+		if text[i-1] == ' ' && text[i] != ' ' {
+			chunks = append(chunks, text[start:i])
+			start = i
+		}
+	}
+	if start < len(text) {
+		chunks = append(chunks, text[start:])
+	}
+	return chunks
+}
+
+// deriveEmbedding returns a unit vector that only depends on the text, so the same text
+// always gets the same embedding
+func deriveEmbedding(text string, dimensions int) []float32 {
+	if dimensions <= 0 {
+		dimensions = DefaultDimensions
+	}
+	vector := make([]float32, dimensions)
+	var norm float64
+	for i := range vector {
+		h := fnv.New32a()
+		h.Write([]byte(strconv.Itoa(i)))
+		h.Write([]byte(text))
+		v := float64(h.Sum32())/math.MaxUint32*2 - 1
+		vector[i] = float32(v)
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	for i := range vector {
+		vector[i] = float32(float64(vector[i]) / norm)
+	}
+	return vector
+}
+
+func formatMillis(d time.Duration) string {
+	return strconv.FormatInt(d.Milliseconds(), 10)
+}
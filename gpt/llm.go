@@ -0,0 +1,210 @@
+package gpt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	PROVIDEROPENAI    = "openai"
+	PROVIDERANTHROPIC = "anthropic"
+	PROVIDEROLLAMA    = "ollama"
+	PROVIDERAZURE     = "azure"
+
+	OLLAMAURL         = "http://localhost:11434/v1"
+	AZUREAPIVERSION   = "2024-06-01"
+	MODELOLLAMA       = "llama3.1"
+	MODELOLLAMAEMBED  = "nomic-embed-text"
+	MODELCLAUDE       = "claude-3-5-haiku-latest"
+	chatCompletionsEP = "/chat/completions"
+	embeddingsEP      = "/embeddings"
+)
+
+var (
+	_ LLM = (*OpenAI)(nil)
+	_ LLM = (*Anthropic)(nil)
+)
+
+// ErrEmbeddingsUnsupported is returned by providers that have no embeddings endpoint
+var ErrEmbeddingsUnsupported = errors.New("embeddings are not supported by this provider")
+
+// Embedder generates embedding vectors. It matches embedding.EmbeddingProvider.
+type Embedder interface {
+	GetEmbedding(text string) ([]float32, error)
+	GetEmbeddingsBatch(texts []string) ([][]float32, error)
+}
+
+// ContextEmbedder is an Embedder whose calls can be cancelled through a context
+type ContextEmbedder interface {
+	GetEmbeddingContext(ctx context.Context, text string) ([]float32, error)
+	GetEmbeddingsBatchContext(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// LLM is implemented by every chat model backend.
+// Tool calling goes through Chat and ChatStream with ChatOptions.Tools set;
+// requested calls come back in the ToolCalls of the returned message.
+// Every call has a ...Context variant that honors cancellation and deadlines.
+type LLM interface {
+	Embedder
+	ContextEmbedder
+	GptQuery(systemPrompt string, message string, userContext string) (string, error)
+	GptQueryContext(ctx context.Context, systemPrompt string, message string, userContext string) (string, error)
+	Chat(messages []GPTmessage, opts *ChatOptions) (GPTmessage, error)
+	ChatContext(ctx context.Context, messages []GPTmessage, opts *ChatOptions) (GPTmessage, error)
+	ChatStream(messages []GPTmessage, opts *ChatOptions, onDelta func(delta string)) (GPTmessage, error)
+	ChatStreamContext(ctx context.Context, messages []GPTmessage, opts *ChatOptions, onDelta func(delta string)) (GPTmessage, error)
+}
+
+// ProviderConfig selects and configures an LLM backend
+type ProviderConfig struct {
+	// Provider is one of openai (default), anthropic, ollama or azure.
+	// ollama also covers any other OpenAI compatible server.
+	Provider string
+	Key      string
+	// Model is the chat model, or the deployment name for azure
+	Model string
+	// URL is the base URL for ollama/compatible servers or the resource endpoint for azure
+	URL string
+	// APIVersion is the azure api-version query parameter
+	APIVersion string
+	// EmbeddingModel is the embeddings model, or the embeddings deployment name for azure
+	EmbeddingModel string
+	// Timeout bounds each API call, zero means DefaultTimeout
+	Timeout time.Duration
+	// MaxRetries overrides DefaultRetryPolicy.MaxRetries, a negative value disables retries
+	MaxRetries int
+	// HTTPClient replaces the default client, e.g. to inject a test transport
+	HTTPClient *http.Client
+	// Usage receives the token usage of every call and may refuse calls (see UsageLimiter)
+	Usage UsageRecorder
+}
+
+// apply sets the transport settings of the config
+func (cfg ProviderConfig) apply(t *transport) {
+	t.SetTimeout(cfg.Timeout)
+	t.SetHTTPClient(cfg.HTTPClient)
+	t.SetUsageRecorder(cfg.Usage)
+	if cfg.MaxRetries != 0 {
+		policy := DefaultRetryPolicy
+		policy.MaxRetries = cfg.MaxRetries
+		if policy.MaxRetries < 0 {
+			policy.MaxRetries = 0
+		}
+		t.SetRetryPolicy(policy)
+	}
+}
+
+// ValidProvider reports whether the provider name is supported
+func ValidProvider(provider string) bool {
+	switch strings.ToLower(provider) {
+	case "", PROVIDEROPENAI, PROVIDERANTHROPIC, PROVIDEROLLAMA, PROVIDERAZURE:
+		return true
+	}
+	return false
+}
+
+// DefaultModelFor returns the default chat model of a provider
+func DefaultModelFor(provider string) string {
+	switch strings.ToLower(provider) {
+	case PROVIDERANTHROPIC:
+		return MODELCLAUDE
+	case PROVIDEROLLAMA:
+		return MODELOLLAMA
+	}
+	return GetDefaultModel()
+}
+
+// New creates the LLM backend described by the config
+func New(cfg ProviderConfig) (LLM, error) {
+	model := cfg.Model
+	if model == "" {
+		model = DefaultModelFor(cfg.Provider)
+	}
+
+	switch strings.ToLower(cfg.Provider) {
+	case "", PROVIDEROPENAI:
+		o := NewOpenAI(cfg.Key, model)
+		if cfg.URL != "" {
+			o.setBaseURL(cfg.URL)
+		}
+		if cfg.EmbeddingModel != "" {
+			o.SetEmbeddingModel(cfg.EmbeddingModel)
+		}
+		cfg.apply(&o.transport)
+		return o, nil
+	case PROVIDEROLLAMA:
+		url := cfg.URL
+		if url == "" {
+			url = OLLAMAURL
+		}
+		o := NewOpenAICompatible(url, cfg.Key, model)
+		if cfg.EmbeddingModel != "" {
+			o.SetEmbeddingModel(cfg.EmbeddingModel)
+		}
+		cfg.apply(&o.transport)
+		return o, nil
+	case PROVIDERAZURE:
+		if cfg.URL == "" {
+			return nil, errors.New("azure provider requires the resource endpoint url")
+		}
+		o := NewAzureOpenAI(cfg.URL, cfg.Key, model, cfg.EmbeddingModel, cfg.APIVersion)
+		cfg.apply(&o.transport)
+		return o, nil
+	case PROVIDERANTHROPIC:
+		a := NewAnthropic(cfg.Key, model)
+		if cfg.URL != "" {
+			a.SetURL(cfg.URL)
+		}
+		cfg.apply(&a.transport)
+		return a, nil
+	}
+	return nil, fmt.Errorf("unsupported LLM provider: %q", cfg.Provider)
+}
+
+// NewOpenAI creates an OpenAI client for the given key and model
+func NewOpenAI(key string, model string) *OpenAI {
+	o := &OpenAI{}
+	o.SetApiKey(key)
+	o.SetModel(model)
+	return o
+}
+
+// NewOpenAICompatible creates a client for an OpenAI compatible server such as Ollama,
+// vLLM or LM Studio. baseURL is the API root, e.g. http://localhost:11434/v1
+func NewOpenAICompatible(baseURL string, key string, model string) *OpenAI {
+	o := NewOpenAI(key, model)
+	o.setBaseURL(baseURL)
+	o.SetEmbeddingModel(MODELOLLAMAEMBED)
+	o.compatible = true
+	return o
+}
+
+// NewAzureOpenAI creates a client for Azure OpenAI deployments. endpoint is the resource
+// URL, e.g. https://myresource.openai.azure.com, and the model is the chat deployment name.
+func NewAzureOpenAI(endpoint string, key string, deployment string, embeddingDeployment string, apiVersion string) *OpenAI {
+	if apiVersion == "" {
+		apiVersion = AZUREAPIVERSION
+	}
+	if embeddingDeployment == "" {
+		embeddingDeployment = MODELEMBEDDING
+	}
+	endpoint = strings.TrimRight(endpoint, "/")
+
+	o := NewOpenAI(key, deployment)
+	o.azure = true
+	o.SetURL(fmt.Sprintf("%s/openai/deployments/%s%s?api-version=%s", endpoint, deployment, chatCompletionsEP, apiVersion))
+	o.SetEmbeddingURL(fmt.Sprintf("%s/openai/deployments/%s%s?api-version=%s", endpoint, embeddingDeployment, embeddingsEP, apiVersion))
+	o.SetEmbeddingModel(embeddingDeployment)
+	return o
+}
+
+// setBaseURL points both the chat and the embeddings endpoints at an API root
+func (o *OpenAI) setBaseURL(baseURL string) {
+	baseURL = strings.TrimRight(baseURL, "/")
+	o.SetURL(baseURL + chatCompletionsEP)
+	o.SetEmbeddingURL(baseURL + embeddingsEP)
+}
@@ -0,0 +1,126 @@
+package gpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+const (
+	OPENAIMODERATIONURL = "https://api.openai.com/v1/moderations"
+	MODELMODERATION     = "omni-moderation-latest"
+	moderationsEP       = "/moderations"
+)
+
+// ErrModerationUnsupported is returned by providers without a moderation endpoint
+var ErrModerationUnsupported = errors.New("provider does not support moderation")
+
+// Moderator classifies text against a content policy
+type Moderator interface {
+	Moderate(text string) (*Moderation, error)
+	ModerateContext(ctx context.Context, text string) (*Moderation, error)
+}
+
+// Moderation is the verdict of a moderation call
+type Moderation struct {
+	Flagged bool
+	// Categories are the flagged policy categories, sorted
+	Categories []string
+	Scores     map[string]float64
+}
+
+// moderationResponse is the subset of the moderations response we decode
+type moderationResponse struct {
+	Results []struct {
+		Flagged        bool               `json:"flagged"`
+		Categories     map[string]bool    `json:"categories"`
+		CategoryScores map[string]float64 `json:"category_scores"`
+	} `json:"results"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+var _ Moderator = (*OpenAI)(nil)
+
+// SetModerationURL sets the moderations endpoint, also enabling moderation on OpenAI
+// compatible servers that provide one
+func (o *OpenAI) SetModerationURL(url string) {
+	o.moderateURL = url
+}
+
+// ModerationSupported reports whether Moderate can be called, i.e. the client is for
+// OpenAI or its moderation URL was set
+func (o *OpenAI) ModerationSupported() bool {
+	return o.moderationURL() != ""
+}
+
+func (o *OpenAI) moderationURL() string {
+	if o.moderateURL != "" {
+		return o.moderateURL
+	}
+	if o.azure || o.compatible {
+		// azure filters content on every call instead, and servers such as Ollama have
+		// no moderations endpoint
+		return ""
+	}
+	if o.url != "" {
+		return strings.TrimSuffix(strings.TrimRight(o.url, "/"), chatCompletionsEP) + moderationsEP
+	}
+	return OPENAIMODERATIONURL
+}
+
+// Moderate checks text with the moderations endpoint
+func (o *OpenAI) Moderate(text string) (*Moderation, error) {
+	return o.ModerateContext(context.Background(), text)
+}
+
+func (o *OpenAI) ModerateContext(ctx context.Context, text string) (*Moderation, error) {
+	url := o.moderationURL()
+	if url == "" {
+		return nil, ErrModerationUnsupported
+	}
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"model": MODELMODERATION,
+		"input": text,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := o.post(ctx, url, jsonData, o.setHeaders)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API response: %w", err)
+	}
+
+	var modResp moderationResponse
+	if err := json.Unmarshal(respBody, &modResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if modResp.Error != nil {
+		return nil, newAPIError(resp.StatusCode, resp.Header, respBody)
+	}
+	if len(modResp.Results) == 0 {
+		return nil, errors.New("empty moderation response")
+	}
+
+	result := modResp.Results[0]
+	moderation := &Moderation{Flagged: result.Flagged, Scores: result.CategoryScores}
+	for category, flagged := range result.Categories {
+		if flagged {
+			moderation.Categories = append(moderation.Categories, category)
+		}
+	}
+	sort.Strings(moderation.Categories)
+	return moderation, nil
+}
@@ -0,0 +1,61 @@
+package gpt
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestModerationNeedsOpenAIOrAnExplicitURL(t *testing.T) {
+	for _, cfg := range []ProviderConfig{
+		{Provider: PROVIDEROLLAMA},
+		{Provider: PROVIDERAZURE, URL: "https://example.openai.azure.com"},
+	} {
+		llm, err := New(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o := llm.(*OpenAI)
+		if o.ModerationSupported() {
+			t.Errorf("%s: moderation supported", cfg.Provider)
+		}
+		if _, err := o.ModerateContext(context.Background(), "hi"); !errors.Is(err, ErrModerationUnsupported) {
+			t.Errorf("%s: Moderate = %v, want ErrModerationUnsupported", cfg.Provider, err)
+		}
+	}
+
+	llm, err := New(ProviderConfig{Provider: PROVIDEROPENAI, URL: "https://proxy.example.com/v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url := llm.(*OpenAI).moderationURL(); url != "https://proxy.example.com/v1/moderations" {
+		t.Errorf("openai moderation url %q", url)
+	}
+}
+
+func TestModerateWithAnExplicitURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/moderations" {
+			t.Errorf("request to %s", r.URL.Path)
+		}
+		w.Write([]byte(`{"results":[{"flagged":true,
+			"categories":{"violence":true,"harassment":true,"hate":false},
+			"category_scores":{"violence":0.9,"harassment":0.7,"hate":0.1}}]}`))
+	}))
+	defer server.Close()
+
+	o := NewOpenAICompatible(server.URL+"/v1", "key", "llama3.1")
+	o.SetModerationURL(server.URL + "/moderations")
+	if !o.ModerationSupported() {
+		t.Fatal("moderation unsupported with an explicit url")
+	}
+	moderation, err := o.Moderate("text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !moderation.Flagged || len(moderation.Categories) != 2 || moderation.Categories[0] != "harassment" || moderation.Scores["violence"] != 0.9 {
+		t.Errorf("moderation %+v", moderation)
+	}
+}
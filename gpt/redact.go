@@ -0,0 +1,459 @@
+package gpt
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// built-in redaction rules
+const (
+	REDACTEMAIL      = "EMAIL"
+	REDACTPHONE      = "PHONE"
+	REDACTCREDITCARD = "CARD"
+)
+
+// DefaultRedactionVault bounds the placeholders a Redactor remembers for Restore
+const DefaultRedactionVault = 10000
+
+// RedactionRule masks the matches of Pattern accepted by Valid (when set) as [Name_<hash>]
+type RedactionRule struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Valid   func(match string) bool
+}
+
+// DefaultRedactionRules mask email addresses, credit-card-like numbers and phone numbers.
+// Earlier rules win when matches overlap.
+var DefaultRedactionRules = []RedactionRule{
+	{Name: REDACTEMAIL, Pattern: regexp.MustCompile(`(?i)\b[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}\b`)},
+	{Name: REDACTCREDITCARD, Pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), Valid: luhnValid},
+	{Name: REDACTPHONE, Pattern: regexp.MustCompile(`(?:\+|\b)\d[\d\s().-]{6,}\d\b`), Valid: phoneValid},
+}
+
+// reDate matches the dates of ISO date-times and log lines, e.g. 2024-05-12 or 12/05/2024
+var reDate = regexp.MustCompile(`\b(?:(?:19|20)\d\d[-/.](?:0?[1-9]|1[0-2])[-/.](?:0?[1-9]|[12]\d|3[01])|(?:0?[1-9]|[12]\d|3[01])[-/.](?:0?[1-9]|[12]\d|3[01])[-/.](?:19|20)\d\d)\b`)
+
+// reEpoch matches unix timestamps in seconds or milliseconds
+var reEpoch = regexp.MustCompile(`^1\d{9}(?:\d{3})?$`)
+
+// reDotted matches IP addresses and version numbers, e.g. 192.168.100.200 or 10.15.7.1234
+var reDotted = regexp.MustCompile(`^\d+(?:\.\d+){2,3}$`)
+
+var rePlaceholder = regexp.MustCompile(`\[[A-Z][A-Z0-9_]*_[0-9a-f]{8}\]`)
+
+// maxPlaceholder is the longest placeholder streamRestorer holds back
+const maxPlaceholder = 64
+
+// Redactor replaces sensitive entities with placeholders before text leaves the process
+// and puts them back in replies. Placeholders are keyed on the value, so the same email
+// gets the same placeholder in every message and document; set a salt to keep them
+// stable across restarts, e.g. for persisted embeddings.
+type Redactor struct {
+	rules []RedactionRule
+	salt  []byte
+
+	mu    sync.Mutex
+	vault map[string]string
+	order []string
+	max   int
+}
+
+// NewRedactor creates a redactor with the default rules. An empty salt is random.
+func NewRedactor(salt string) *Redactor {
+	r := &Redactor{
+		rules: append([]RedactionRule(nil), DefaultRedactionRules...),
+		vault: make(map[string]string),
+		max:   DefaultRedactionVault,
+	}
+	if salt != "" {
+		r.salt = []byte(salt)
+	} else {
+		r.salt = make([]byte, 16)
+		rand.Read(r.salt)
+	}
+	return r
+}
+
+// AddRule masks the matches of pattern as [name_<hash>]. name is uppercased.
+func (r *Redactor) AddRule(name string, pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid redaction pattern %s: %w", name, err)
+	}
+	name = strings.ToUpper(regexp.MustCompile(`[^A-Za-z0-9]+`).ReplaceAllString(name, "_"))
+	r.rules = append(r.rules, RedactionRule{Name: name, Pattern: re})
+	return nil
+}
+
+type redactionMatch struct {
+	start, end int
+	rule       string
+}
+
+// Redact replaces the entities of text with placeholders
+func (r *Redactor) Redact(text string) string {
+	if r == nil || text == "" {
+		return text
+	}
+	var matches []redactionMatch
+	for _, rule := range r.rules {
+		for _, loc := range rule.Pattern.FindAllStringIndex(text, -1) {
+			if rule.Valid != nil && !rule.Valid(text[loc[0]:loc[1]]) {
+				continue
+			}
+			matches = append(matches, redactionMatch{loc[0], loc[1], rule.Name})
+		}
+	}
+	// placeholders already in the text are kept as they are
+	for _, loc := range rePlaceholder.FindAllStringIndex(text, -1) {
+		matches = append([]redactionMatch{{loc[0], loc[1], ""}}, matches...)
+	}
+	if len(matches) == 0 {
+		return text
+	}
+
+	// keep the first rule matching a span, in rule order, dropping overlapping matches
+	var kept []redactionMatch
+	for _, m := range matches {
+		overlaps := false
+		for _, k := range kept {
+			if m.start < k.end && k.start < m.end {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			kept = append(kept, m)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].start < kept[j].start })
+
+	var b strings.Builder
+	last := 0
+	for _, m := range kept {
+		b.WriteString(text[last:m.start])
+		if m.rule == "" {
+			b.WriteString(text[m.start:m.end])
+		} else {
+			b.WriteString(r.placeholder(m.rule, text[m.start:m.end]))
+		}
+		last = m.end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// placeholder returns the placeholder of a value, remembering it for Restore
+func (r *Redactor) placeholder(rule string, value string) string {
+	mac := hmac.New(sha256.New, r.salt)
+	mac.Write([]byte(value))
+	placeholder := "[" + rule + "_" + hex.EncodeToString(mac.Sum(nil))[:8] + "]"
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.vault[placeholder]; !ok {
+		r.vault[placeholder] = value
+		r.order = append(r.order, placeholder)
+		if len(r.order) > r.max {
+			delete(r.vault, r.order[0])
+			r.order = r.order[1:]
+		}
+	}
+	return placeholder
+}
+
+// Restore replaces the placeholders of text with the original values. Unknown
+// placeholders are left as they are.
+func (r *Redactor) Restore(text string) string {
+	if r == nil || !strings.Contains(text, "[") {
+		return text
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return rePlaceholder.ReplaceAllStringFunc(text, func(placeholder string) string {
+		if value, ok := r.vault[placeholder]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+// RedactMessages returns a copy of the messages with their text and tool call arguments redacted
+func (r *Redactor) RedactMessages(messages []GPTmessage) []GPTmessage {
+	if r == nil {
+		return messages
+	}
+	redacted := make([]GPTmessage, len(messages))
+	for i, m := range messages {
+		m.Content = r.Redact(m.Content)
+		if len(m.Parts) > 0 {
+			parts := make([]ContentPart, len(m.Parts))
+			for j, part := range m.Parts {
+				part.Text = r.Redact(part.Text)
+				parts[j] = part
+			}
+			m.Parts = parts
+		}
+		m.ToolCalls = r.mapToolCalls(m.ToolCalls, r.Redact)
+		redacted[i] = m
+	}
+	return redacted
+}
+
+// RestoreMessage puts the original values back in a reply and its tool call arguments
+func (r *Redactor) RestoreMessage(m GPTmessage) GPTmessage {
+	if r == nil {
+		return m
+	}
+	m.Content = r.Restore(m.Content)
+	m.ToolCalls = r.mapToolCalls(m.ToolCalls, r.Restore)
+	return m
+}
+
+// RestoreResponse returns a copy of a Responses reply with the original values put back in
+// its text, reasoning summaries and function call arguments
+func (r *Redactor) RestoreResponse(response *Response) *Response {
+	if r == nil || response == nil {
+		return response
+	}
+	restored := *response
+	restored.Output = make([]ResponseOutput, len(response.Output))
+	for i, out := range response.Output {
+		out.Arguments = r.Restore(out.Arguments)
+		if len(out.Content) > 0 {
+			content := make([]ResponseContent, len(out.Content))
+			for j, c := range out.Content {
+				c.Text = r.Restore(c.Text)
+				content[j] = c
+			}
+			out.Content = content
+		}
+		if len(out.Summary) > 0 {
+			summary := append(out.Summary[:0:0], out.Summary...)
+			for j := range summary {
+				summary[j].Text = r.Restore(summary[j].Text)
+			}
+			out.Summary = summary
+		}
+		restored.Output[i] = out
+	}
+	return &restored
+}
+
+func (r *Redactor) mapToolCalls(calls []ToolCall, fn func(string) string) []ToolCall {
+	if len(calls) == 0 {
+		return calls
+	}
+	mapped := make([]ToolCall, len(calls))
+	for i, call := range calls {
+		call.Function.Arguments = fn(call.Function.Arguments)
+		mapped[i] = call
+	}
+	return mapped
+}
+
+// luhnValid reports whether the digits of a number pass the Luhn checksum of card numbers
+func luhnValid(number string) bool {
+	sum, digits := 0, 0
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
+
+// phoneValid accepts numbers of 9 to 15 digits, skipping dates, timestamps, short ids,
+// IP addresses and versions. Dots only separate the digits of numbers starting with + or
+// a parenthesised area code, and groups of four digits only (1234 5678 9012) are taken
+// for order or account numbers.
+func phoneValid(number string) bool {
+	if reDate.MatchString(number) || reEpoch.MatchString(number) || reDotted.MatchString(number) {
+		return false
+	}
+	international := strings.HasPrefix(number, "+") || strings.Contains(number, ")")
+	if !international {
+		if strings.Contains(number, ".") {
+			return false
+		}
+		groups := strings.FieldsFunc(number, func(r rune) bool { return r == ' ' || r == '-' })
+		fours := 0
+		for _, group := range groups {
+			if len(group) == 4 {
+				fours++
+			}
+		}
+		if len(groups) >= 3 && fours == len(groups) {
+			return false
+		}
+	}
+	digits := 0
+	for _, c := range number {
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+	return digits >= 9 && digits <= 15
+}
+
+// RedactingLLM redacts every message and embedding input sent to the wrapped LLM and
+// restores the placeholders in its replies, unless KeepPlaceholders is set
+type RedactingLLM struct {
+	llm      LLM
+	redactor *Redactor
+	// KeepPlaceholders returns replies with the placeholders, e.g. to restore them later
+	// with Redactor.Restore
+	KeepPlaceholders bool
+}
+
+var (
+	_ LLM       = (*RedactingLLM)(nil)
+	_ Responder = (*RedactingLLM)(nil)
+)
+
+// NewRedactingLLM wraps llm with the redactor
+func NewRedactingLLM(llm LLM, redactor *Redactor) *RedactingLLM {
+	return &RedactingLLM{llm: llm, redactor: redactor}
+}
+
+func (r *RedactingLLM) restore(text string) string {
+	if r.KeepPlaceholders {
+		return text
+	}
+	return r.redactor.Restore(text)
+}
+
+func (r *RedactingLLM) restoreMessage(m GPTmessage) GPTmessage {
+	if r.KeepPlaceholders {
+		return m
+	}
+	return r.redactor.RestoreMessage(m)
+}
+
+func (r *RedactingLLM) GptQuery(systemPrompt string, message string, userContext string) (string, error) {
+	return r.GptQueryContext(context.Background(), systemPrompt, message, userContext)
+}
+
+func (r *RedactingLLM) GptQueryContext(ctx context.Context, systemPrompt string, message string, userContext string) (string, error) {
+	reply, err := r.llm.GptQueryContext(ctx, r.redactor.Redact(systemPrompt), r.redactor.Redact(message), r.redactor.Redact(userContext))
+	return r.restore(reply), err
+}
+
+func (r *RedactingLLM) Chat(messages []GPTmessage, opts *ChatOptions) (GPTmessage, error) {
+	return r.ChatContext(context.Background(), messages, opts)
+}
+
+func (r *RedactingLLM) ChatContext(ctx context.Context, messages []GPTmessage, opts *ChatOptions) (GPTmessage, error) {
+	reply, err := r.llm.ChatContext(ctx, r.redactor.RedactMessages(messages), opts)
+	return r.restoreMessage(reply), err
+}
+
+func (r *RedactingLLM) ChatStream(messages []GPTmessage, opts *ChatOptions, onDelta func(delta string)) (GPTmessage, error) {
+	return r.ChatStreamContext(context.Background(), messages, opts, onDelta)
+}
+
+// ChatStreamContext restores placeholders in the deltas too, holding back text that may be
+// the start of a placeholder split across deltas
+func (r *RedactingLLM) ChatStreamContext(ctx context.Context, messages []GPTmessage, opts *ChatOptions, onDelta func(delta string)) (GPTmessage, error) {
+	if r.KeepPlaceholders || onDelta == nil {
+		reply, err := r.llm.ChatStreamContext(ctx, r.redactor.RedactMessages(messages), opts, onDelta)
+		return r.restoreMessage(reply), err
+	}
+	restorer := &streamRestorer{redactor: r.redactor, onDelta: onDelta}
+	reply, err := r.llm.ChatStreamContext(ctx, r.redactor.RedactMessages(messages), opts, restorer.write)
+	restorer.flush()
+	return r.restoreMessage(reply), err
+}
+
+// Respond redacts the input and instructions of a Responses call and restores the reply.
+// It returns ErrResponsesUnsupported when the wrapped LLM is not a Responder.
+func (r *RedactingLLM) Respond(input []GPTmessage, opts *ResponseOptions) (*Response, error) {
+	return r.RespondContext(context.Background(), input, opts)
+}
+
+func (r *RedactingLLM) RespondContext(ctx context.Context, input []GPTmessage, opts *ResponseOptions) (*Response, error) {
+	responder, ok := r.llm.(Responder)
+	if !ok {
+		return nil, ErrResponsesUnsupported
+	}
+	if opts != nil {
+		redacted := *opts
+		redacted.Instructions = r.redactor.Redact(opts.Instructions)
+		opts = &redacted
+	}
+	response, err := responder.RespondContext(ctx, r.redactor.RedactMessages(input), opts)
+	if err != nil || response == nil || r.KeepPlaceholders {
+		return response, err
+	}
+	return r.redactor.RestoreResponse(response), nil
+}
+
+func (r *RedactingLLM) GetEmbedding(text string) ([]float32, error) {
+	return r.GetEmbeddingContext(context.Background(), text)
+}
+
+func (r *RedactingLLM) GetEmbeddingContext(ctx context.Context, text string) ([]float32, error) {
+	return r.llm.GetEmbeddingContext(ctx, r.redactor.Redact(text))
+}
+
+func (r *RedactingLLM) GetEmbeddingsBatch(texts []string) ([][]float32, error) {
+	return r.GetEmbeddingsBatchContext(context.Background(), texts)
+}
+
+func (r *RedactingLLM) GetEmbeddingsBatchContext(ctx context.Context, texts []string) ([][]float32, error) {
+	redacted := make([]string, len(texts))
+	for i, text := range texts {
+		redacted[i] = r.redactor.Redact(text)
+	}
+	return r.llm.GetEmbeddingsBatchContext(ctx, redacted)
+}
+
+// streamRestorer restores placeholders in streamed deltas
+type streamRestorer struct {
+	redactor *Redactor
+	onDelta  func(delta string)
+	pending  string
+}
+
+func (s *streamRestorer) write(delta string) {
+	s.pending += delta
+	// hold back an unterminated "[..." that may still become a placeholder
+	hold := len(s.pending)
+	if open := strings.LastIndex(s.pending, "["); open >= 0 && !strings.Contains(s.pending[open:], "]") &&
+		len(s.pending)-open < maxPlaceholder {
+		hold = open
+	}
+	if hold == 0 {
+		return
+	}
+	out := s.redactor.Restore(s.pending[:hold])
+	s.pending = s.pending[hold:]
+	if out != "" {
+		s.onDelta(out)
+	}
+}
+
+func (s *streamRestorer) flush() {
+	if s.pending != "" {
+		s.onDelta(s.redactor.Restore(s.pending))
+		s.pending = ""
+	}
+}
@@ -0,0 +1,93 @@
+package gpt
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRedactPhoneSkipsDatesAndTimestamps(t *testing.T) {
+	r := NewRedactor("salt")
+	for _, text := range []string{
+		"deployed at 2024-05-12T10:30:00Z",
+		"deployed at 2024-05-12 10:30:00",
+		"logged 12/05/2024 10:30",
+		"slack ts 1718000000.123456",
+		"epoch 1718000000",
+		"epoch ms 1718000000123",
+	} {
+		if got := r.Redact(text); got != text {
+			t.Errorf("Redact(%q) = %q, want it unchanged", text, got)
+		}
+	}
+	for _, text := range []string{
+		"server 192.168.100.200 is down",
+		"upgraded to 10.15.7.1234",
+		"order 1234 5678 9012 shipped",
+		"order 1234-5678-9012 shipped",
+		"build 555.123.4567",
+	} {
+		if got := r.Redact(text); got != text {
+			t.Errorf("Redact(%q) = %q, want it unchanged", text, got)
+		}
+	}
+	for _, text := range []string{
+		"call +44 20 7946 0958",
+		"call (555) 123-4567",
+		"call 555-123-4567",
+		"call +1.555.123.4567",
+		"call (555) 123.4567",
+	} {
+		if got := r.Redact(text); !strings.Contains(got, "[PHONE_") {
+			t.Errorf("Redact(%q) = %q, want the phone masked", text, got)
+		}
+	}
+}
+
+// responderLLM records the input of a Responses call and echoes it back
+type responderLLM struct {
+	LLM
+	input []GPTmessage
+	opts  *ResponseOptions
+	calls int
+}
+
+func (l *responderLLM) Respond(input []GPTmessage, opts *ResponseOptions) (*Response, error) {
+	return l.RespondContext(context.Background(), input, opts)
+}
+
+func (l *responderLLM) RespondContext(ctx context.Context, input []GPTmessage, opts *ResponseOptions) (*Response, error) {
+	l.input, l.opts = input, opts
+	l.calls++
+	last := input[len(input)-1].Content
+	return &Response{Output: []ResponseOutput{
+		{Type: OUTPUTMESSAGE, Content: []ResponseContent{{Type: "output_text", Text: "you said " + last}}},
+		{Type: OUTPUTFUNCTIONCALL, Name: "lookup", Arguments: `{"email":"` + last + `"}`},
+	}}, nil
+}
+
+func TestRedactingLLMRespond(t *testing.T) {
+	backend := &responderLLM{}
+	llm := NewRedactingLLM(backend, NewRedactor("salt"))
+	opts := &ResponseOptions{Instructions: "owner is bob@example.com"}
+	response, err := llm.Respond([]GPTmessage{{Role: USERROLE, Content: "alice@example.com"}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(backend.input[0].Content, "alice") || strings.Contains(backend.opts.Instructions, "bob") {
+		t.Errorf("sent unredacted input %q and instructions %q", backend.input[0].Content, backend.opts.Instructions)
+	}
+	if opts.Instructions != "owner is bob@example.com" {
+		t.Errorf("caller options changed to %q", opts.Instructions)
+	}
+	if got := response.OutputText(); got != "you said alice@example.com" {
+		t.Errorf("OutputText = %q, want the email restored", got)
+	}
+	if got := response.FunctionCalls()[0].Function.Arguments; got != `{"email":"alice@example.com"}` {
+		t.Errorf("arguments = %q, want the email restored", got)
+	}
+
+	if _, err := NewRedactingLLM(nil, nil).Respond(nil, nil); err != ErrResponsesUnsupported {
+		t.Errorf("Respond without a Responder = %v, want ErrResponsesUnsupported", err)
+	}
+}
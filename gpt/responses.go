@@ -0,0 +1,453 @@
+package gpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	OPENAIRESPONSESURL = "https://api.openai.com/v1/responses"
+	responsesEP        = "/responses"
+)
+
+// reasoning effort of reasoning models, see ResponseOptions.ReasoningEffort
+const (
+	EFFORTMINIMAL = "minimal"
+	EFFORTLOW     = "low"
+	EFFORTMEDIUM  = "medium"
+	EFFORTHIGH    = "high"
+)
+
+// output item types of a Response
+const (
+	OUTPUTMESSAGE         = "message"
+	OUTPUTREASONING       = "reasoning"
+	OUTPUTFUNCTIONCALL    = "function_call"
+	OUTPUTWEBSEARCH       = "web_search_call"
+	OUTPUTFILESEARCH      = "file_search_call"
+	OUTPUTCODEINTERPRETER = "code_interpreter_call"
+)
+
+// hosted tool types of the Responses API
+const (
+	TOOLWEBSEARCH       = "web_search"
+	TOOLFILESEARCH      = "file_search"
+	TOOLCODEINTERPRETER = "code_interpreter"
+)
+
+// ErrResponsesUnsupported is returned when the endpoint has no Responses API
+var ErrResponsesUnsupported = errors.New("provider does not support the responses API")
+
+// Responder is implemented by the LLMs that support the Responses API: OpenAI and the
+// wrappers forwarding to it
+type Responder interface {
+	Respond(input []GPTmessage, opts *ResponseOptions) (*Response, error)
+	RespondContext(ctx context.Context, input []GPTmessage, opts *ResponseOptions) (*Response, error)
+}
+
+var _ Responder = (*OpenAI)(nil)
+
+// ResponseTool is a tool of a Responses request: a function or a tool hosted by OpenAI
+type ResponseTool struct {
+	Type string `json:"type"`
+	// function tools
+	Name        string          `json:"name,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	// file_search
+	VectorStoreIDs []string `json:"vector_store_ids,omitempty"`
+	MaxNumResults  int      `json:"max_num_results,omitempty"`
+	// web_search: low, medium or high
+	SearchContextSize string `json:"search_context_size,omitempty"`
+	// code_interpreter
+	Container interface{} `json:"container,omitempty"`
+}
+
+// WebSearchTool lets the model search the web, citations are returned as annotations
+func WebSearchTool() ResponseTool {
+	return ResponseTool{Type: TOOLWEBSEARCH}
+}
+
+// FileSearchTool lets the model search the given OpenAI vector stores
+func FileSearchTool(vectorStoreIDs ...string) ResponseTool {
+	return ResponseTool{Type: TOOLFILESEARCH, VectorStoreIDs: vectorStoreIDs}
+}
+
+// CodeInterpreterTool lets the model run Python in a container created for the call
+func CodeInterpreterTool() ResponseTool {
+	return ResponseTool{Type: TOOLCODEINTERPRETER, Container: map[string]string{"type": "auto"}}
+}
+
+// FunctionResponseTool converts a chat function tool, e.g. from MCPToolsToGPT
+func FunctionResponseTool(tool Tool) ResponseTool {
+	return ResponseTool{
+		Type:        TOOLFUNCTION,
+		Name:        tool.Function.Name,
+		Description: tool.Function.Description,
+		Parameters:  tool.Function.Parameters,
+	}
+}
+
+// ResponseOptions are the optional parameters of a Responses call
+type ResponseOptions struct {
+	Model string
+	// Instructions is the system prompt, it is not carried over by PreviousResponseID
+	Instructions string
+	// PreviousResponseID continues the conversation of a stored response, so only the new
+	// turns (e.g. function call outputs) need to be sent
+	PreviousResponseID string
+	// ReasoningEffort is one of the EFFORT* constants, for reasoning models only
+	ReasoningEffort string
+	// ReasoningSummary asks for a summary of the reasoning: auto, concise or detailed
+	ReasoningSummary string
+	MaxOutputTokens  int
+	Temperature      *float64
+	Tools            []ResponseTool
+	// Schema constrains the reply to JSON, see StructuredChat
+	Schema *JSONSchemaFormat
+	// Store keeps the response on the server for PreviousResponseID, defaults to true
+	Store    *bool
+	Metadata map[string]string
+}
+
+// Response is the typed result of a Responses call
+type Response struct {
+	ID     string           `json:"id"`
+	Model  string           `json:"model"`
+	Status string           `json:"status"`
+	Output []ResponseOutput `json:"output"`
+	Usage  *responsesUsage  `json:"usage,omitempty"`
+	Error  *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+	IncompleteDetails *struct {
+		Reason string `json:"reason"`
+	} `json:"incomplete_details,omitempty"`
+}
+
+// ResponseOutput is an output item. Type tells which fields are set.
+type ResponseOutput struct {
+	Type   string `json:"type"`
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status,omitempty"`
+	// message
+	Role    string            `json:"role,omitempty"`
+	Content []ResponseContent `json:"content,omitempty"`
+	// reasoning
+	Summary []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"summary,omitempty"`
+	// function_call
+	CallID    string `json:"call_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+	// file_search_call
+	Queries []string `json:"queries,omitempty"`
+	// web_search_call
+	Action json.RawMessage `json:"action,omitempty"`
+	// code_interpreter_call
+	Code        string          `json:"code,omitempty"`
+	ContainerID string          `json:"container_id,omitempty"`
+	Outputs     json.RawMessage `json:"outputs,omitempty"`
+}
+
+// ResponseContent is a part of a message output
+type ResponseContent struct {
+	// output_text or refusal
+	Type        string       `json:"type"`
+	Text        string       `json:"text,omitempty"`
+	Refusal     string       `json:"refusal,omitempty"`
+	Annotations []Annotation `json:"annotations,omitempty"`
+}
+
+// Annotation cites a source of the text: url_citation, file_citation or container_file_citation
+type Annotation struct {
+	Type       string `json:"type"`
+	URL        string `json:"url,omitempty"`
+	Title      string `json:"title,omitempty"`
+	FileID     string `json:"file_id,omitempty"`
+	Filename   string `json:"filename,omitempty"`
+	StartIndex int    `json:"start_index,omitempty"`
+	EndIndex   int    `json:"end_index,omitempty"`
+}
+
+type responsesUsage struct {
+	InputTokens         int `json:"input_tokens"`
+	OutputTokens        int `json:"output_tokens"`
+	TotalTokens         int `json:"total_tokens"`
+	OutputTokensDetails struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"output_tokens_details"`
+}
+
+// OutputText returns the text of the message outputs
+func (r *Response) OutputText() string {
+	var b strings.Builder
+	for _, out := range r.Output {
+		if out.Type != OUTPUTMESSAGE {
+			continue
+		}
+		for _, c := range out.Content {
+			b.WriteString(c.Text)
+		}
+	}
+	return b.String()
+}
+
+// Refusal returns the refusal of the model, if it declined to answer
+func (r *Response) Refusal() string {
+	for _, out := range r.Output {
+		for _, c := range out.Content {
+			if c.Refusal != "" {
+				return c.Refusal
+			}
+		}
+	}
+	return ""
+}
+
+// FunctionCalls returns the function calls the model requested, as ToolCalls so the
+// results can be sent back with ToolResultMessage
+func (r *Response) FunctionCalls() []ToolCall {
+	var calls []ToolCall
+	for _, out := range r.Output {
+		if out.Type != OUTPUTFUNCTIONCALL {
+			continue
+		}
+		call := ToolCall{ID: out.CallID, Type: TOOLFUNCTION}
+		call.Function.Name = out.Name
+		call.Function.Arguments = out.Arguments
+		calls = append(calls, call)
+	}
+	return calls
+}
+
+// Citations returns the annotations of the message outputs, e.g. web search sources
+func (r *Response) Citations() []Annotation {
+	var citations []Annotation
+	for _, out := range r.Output {
+		for _, c := range out.Content {
+			citations = append(citations, c.Annotations...)
+		}
+	}
+	return citations
+}
+
+// ReasoningSummary returns the reasoning summary, when requested with ReasoningSummary
+func (r *Response) ReasoningSummary() string {
+	var parts []string
+	for _, out := range r.Output {
+		if out.Type != OUTPUTREASONING {
+			continue
+		}
+		for _, s := range out.Summary {
+			parts = append(parts, s.Text)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// Message returns the response as an assistant message, for code written against Chat
+func (r *Response) Message() GPTmessage {
+	return GPTmessage{Role: ASSISTANTROLE, Content: r.OutputText(), ToolCalls: r.FunctionCalls()}
+}
+
+// SetResponsesURL sets the responses endpoint
+func (o *OpenAI) SetResponsesURL(url string) {
+	o.responsesURL = url
+}
+
+func (o *OpenAI) responsesEndpoint() string {
+	if o.responsesURL != "" {
+		return o.responsesURL
+	}
+	if o.azure {
+		// azure serves the responses API under its own v1 path
+		return ""
+	}
+	if o.url != "" {
+		return strings.TrimSuffix(strings.TrimRight(o.url, "/"), chatCompletionsEP) + responsesEP
+	}
+	return OPENAIRESPONSESURL
+}
+
+// Respond sends input to the Responses API. input holds the conversation as for Chat;
+// system messages become instructions, tool results become function call outputs.
+// An incomplete response (e.g. max_output_tokens reached) is returned without error,
+// check Status.
+func (o *OpenAI) Respond(input []GPTmessage, opts *ResponseOptions) (*Response, error) {
+	return o.RespondContext(context.Background(), input, opts)
+}
+
+func (o *OpenAI) RespondContext(ctx context.Context, input []GPTmessage, opts *ResponseOptions) (*Response, error) {
+	url := o.responsesEndpoint()
+	if url == "" {
+		return nil, ErrResponsesUnsupported
+	}
+	if len(input) == 0 {
+		return nil, errors.New("no input to send")
+	}
+
+	data := o.responsesRequest(input, opts)
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := o.post(ctx, url, jsonData, o.setHeaders)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API response: %w", err)
+	}
+
+	var response Response
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if response.Usage != nil {
+		o.recordUsage(ctx, Usage{
+			Model:            requestModel(data),
+			PromptTokens:     response.Usage.InputTokens,
+			CompletionTokens: response.Usage.OutputTokens,
+			TotalTokens:      response.Usage.TotalTokens,
+		})
+	}
+
+	if response.Error != nil {
+		return nil, newAPIError(resp.StatusCode, resp.Header, respBody)
+	}
+	if response.IncompleteDetails != nil && response.IncompleteDetails.Reason == "content_filter" && response.OutputText() == "" {
+		return nil, contentFilterError()
+	}
+	return &response, nil
+}
+
+// responsesRequest builds the responses request body
+func (o *OpenAI) responsesRequest(input []GPTmessage, opts *ResponseOptions) map[string]interface{} {
+	if opts == nil {
+		opts = &ResponseOptions{}
+	}
+	instructions := opts.Instructions
+	items := make([]interface{}, 0, len(input))
+	for _, m := range input {
+		if m.Role == SYSTEMROLE && len(m.Parts) == 0 {
+			if instructions != "" {
+				instructions += "\n\n"
+			}
+			instructions += m.Content
+			continue
+		}
+		items = append(items, responseItems(m)...)
+	}
+
+	data := map[string]interface{}{
+		"model": o.model,
+		"input": items,
+	}
+	if opts.Model != "" {
+		data["model"] = opts.Model
+	}
+	if instructions != "" {
+		data["instructions"] = instructions
+	}
+	if opts.PreviousResponseID != "" {
+		data["previous_response_id"] = opts.PreviousResponseID
+	}
+	if opts.ReasoningEffort != "" || opts.ReasoningSummary != "" {
+		reasoning := map[string]string{}
+		if opts.ReasoningEffort != "" {
+			reasoning["effort"] = opts.ReasoningEffort
+		}
+		if opts.ReasoningSummary != "" {
+			reasoning["summary"] = opts.ReasoningSummary
+		}
+		data["reasoning"] = reasoning
+	}
+	if opts.MaxOutputTokens > 0 {
+		data["max_output_tokens"] = opts.MaxOutputTokens
+	}
+	if opts.Temperature != nil {
+		data["temperature"] = *opts.Temperature
+	}
+	if len(opts.Tools) > 0 {
+		data["tools"] = opts.Tools
+	}
+	if opts.Schema != nil {
+		data["text"] = map[string]interface{}{
+			"format": map[string]interface{}{
+				"type":   RESPONSEJSONSCHEMA,
+				"name":   opts.Schema.Name,
+				"schema": opts.Schema.Schema,
+				"strict": opts.Schema.Strict,
+			},
+		}
+	}
+	if opts.Store != nil {
+		data["store"] = *opts.Store
+	}
+	if len(opts.Metadata) > 0 {
+		data["metadata"] = opts.Metadata
+	}
+	return data
+}
+
+// responseItems converts a chat message to input items of the Responses API
+func responseItems(m GPTmessage) []interface{} {
+	switch {
+	case m.Role == TOOLROLE:
+		return []interface{}{map[string]interface{}{
+			"type":    "function_call_output",
+			"call_id": m.ToolCallID,
+			"output":  m.Content,
+		}}
+	case m.Role == ASSISTANTROLE:
+		var items []interface{}
+		if m.Content != "" {
+			items = append(items, map[string]interface{}{
+				"role":    ASSISTANTROLE,
+				"content": []interface{}{map[string]interface{}{"type": "output_text", "text": m.Content}},
+			})
+		}
+		for _, call := range m.ToolCalls {
+			items = append(items, map[string]interface{}{
+				"type":      OUTPUTFUNCTIONCALL,
+				"call_id":   call.ID,
+				"name":      call.Function.Name,
+				"arguments": call.Function.Arguments,
+			})
+		}
+		return items
+	}
+
+	content := []interface{}{}
+	if m.Content != "" {
+		content = append(content, map[string]interface{}{"type": "input_text", "text": m.Content})
+	}
+	for _, part := range m.Parts {
+		switch part.Type {
+		case PARTTEXT:
+			content = append(content, map[string]interface{}{"type": "input_text", "text": part.Text})
+		case PARTIMAGEURL:
+			if part.ImageURL == nil {
+				continue
+			}
+			image := map[string]interface{}{"type": "input_image", "image_url": part.ImageURL.URL}
+			if part.ImageURL.Detail != "" {
+				image["detail"] = part.ImageURL.Detail
+			}
+			content = append(content, image)
+		}
+	}
+	return []interface{}{map[string]interface{}{"role": m.Role, "content": content}}
+}
@@ -0,0 +1,47 @@
+package gpt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFallbackRespondSkipsBackendsWithoutResponses(t *testing.T) {
+	responder := &responderLLM{}
+	f := NewFallback(
+		FallbackBackend{Name: "anthropic", LLM: NewRedactingLLM(nil, nil)},
+		FallbackBackend{Name: "openai", LLM: responder},
+	)
+	response, err := f.Respond([]GPTmessage{{Role: USERROLE, Content: "hi"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.OutputText() != "you said hi" || responder.calls != 1 {
+		t.Errorf("got %q after %d calls, want the answer of the second backend", response.OutputText(), responder.calls)
+	}
+	if state := f.backends[0].Breaker.State(); state != BREAKERCLOSED {
+		t.Errorf("unsupported backend circuit %s, want closed", state)
+	}
+
+	unsupported := NewFallback(FallbackBackend{LLM: NewRedactingLLM(nil, nil)})
+	if _, err := unsupported.Respond([]GPTmessage{{Role: USERROLE, Content: "hi"}}, nil); err != ErrResponsesUnsupported {
+		t.Errorf("Respond without a Responder = %v, want ErrResponsesUnsupported", err)
+	}
+}
+
+func TestCachedLLMRespond(t *testing.T) {
+	responder := &responderLLM{}
+	cached := NewCachedLLM(responder, NewResponseCache(NewMemoryCache(10), time.Minute), "test")
+	input := []GPTmessage{{Role: USERROLE, Content: "hi"}}
+	for i := 0; i < 2; i++ {
+		response, err := cached.Respond(input, &ResponseOptions{Instructions: "be brief"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.OutputText() != "you said hi" {
+			t.Errorf("call %d answered %q", i, response.OutputText())
+		}
+	}
+	if responder.calls != 1 {
+		t.Errorf("backend called %d times, want 1", responder.calls)
+	}
+}
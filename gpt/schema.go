@@ -0,0 +1,324 @@
+package gpt
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is the subset of JSON Schema generated from Go types and checked by Validate.
+// AdditionalProperties is either false or a *Schema for map values. A Nullable schema also
+// accepts null, its type is written as [Type, "null"].
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Nullable             bool               `json:"-"`
+	Description          string             `json:"description,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"`
+}
+
+// MarshalJSON writes the type of a nullable schema as a list with "null"
+func (s Schema) MarshalJSON() ([]byte, error) {
+	type plain Schema
+	if !s.Nullable || s.Type == "" {
+		return json.Marshal(plain(s))
+	}
+	return json.Marshal(struct {
+		Type []string `json:"type"`
+		plain
+	}{Type: []string{s.Type, "null"}, plain: plain(s)})
+}
+
+// SchemaError reports where a value does not match its schema
+type SchemaError struct {
+	Path    string
+	Message string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// SchemaFor derives a JSON Schema from a Go value or type, following encoding/json field rules.
+// Fields tagged omitempty are optional, pointers are nullable; the description and enum
+// struct tags document fields, enum values are parsed as the field type:
+//
+//	Label string `json:"label" description:"email category" enum:"spam,billing,support"`
+//	Priority int `json:"priority" enum:"1,2,3"`
+func SchemaFor(v any) (*Schema, error) {
+	t, ok := v.(reflect.Type)
+	if !ok {
+		t = reflect.TypeOf(v)
+	}
+	if t == nil {
+		return nil, fmt.Errorf("cannot derive a schema from nil")
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return schemaForType(t, map[reflect.Type]bool{})
+}
+
+func schemaForType(t reflect.Type, seen map[reflect.Type]bool) (*Schema, error) {
+	if t.Kind() == reflect.Ptr {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		s, err := schemaForType(t, seen)
+		if err != nil {
+			return nil, err
+		}
+		s.Nullable = true
+		return s, nil
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}, nil
+	case t == rawMessageType:
+		return &Schema{}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}, nil
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}, nil
+	case reflect.Interface:
+		return &Schema{}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json writes byte slices as base64 strings
+			return &Schema{Type: "string"}, nil
+		}
+		items, err := schemaForType(t.Elem(), seen)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", t.Key())
+		}
+		values, err := schemaForType(t.Elem(), seen)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "object", AdditionalProperties: values}, nil
+	case reflect.Struct:
+		if seen[t] {
+			return nil, fmt.Errorf("recursive type %s is not supported", t)
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: false}
+		if err := addStructFields(s, t, seen); err != nil {
+			return nil, err
+		}
+		sort.Strings(s.Required)
+		return s, nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+// addStructFields adds the JSON visible fields of t, flattening embedded structs
+func addStructFields(s *Schema, t reflect.Type, seen map[reflect.Type]bool) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			if err := addStructFields(s, ft, seen); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		fs, err := schemaForType(f.Type, seen)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", f.Name, err)
+		}
+		if desc := f.Tag.Get("description"); desc != "" {
+			fs.Description = desc
+		}
+		if enum := f.Tag.Get("enum"); enum != "" {
+			for _, e := range strings.Split(enum, ",") {
+				value, err := parseEnumValue(ft.Kind(), strings.TrimSpace(e))
+				if err != nil {
+					return fmt.Errorf("field %s: %w", f.Name, err)
+				}
+				fs.Enum = append(fs.Enum, value)
+			}
+			if fs.Nullable {
+				fs.Enum = append(fs.Enum, nil)
+			}
+		}
+		s.Properties[name] = fs
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return nil
+}
+
+// parseEnumValue parses an enum tag value as a value of the field kind
+func parseEnumValue(kind reflect.Kind, value string) (any, error) {
+	var (
+		v   any
+		err error
+	)
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err = strconv.ParseInt(value, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err = strconv.ParseUint(value, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		v, err = strconv.ParseFloat(value, 64)
+	case reflect.Bool:
+		v, err = strconv.ParseBool(value)
+	default:
+		return value, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid enum value %q for %s", value, kind)
+	}
+	return v, nil
+}
+
+// Validate checks a value decoded by encoding/json into an interface{} against the schema
+func (s *Schema) Validate(v any) error {
+	return s.validate("$", v)
+}
+
+func (s *Schema) validate(path string, v any) error {
+	if s == nil {
+		return nil
+	}
+	if v == nil && s.Nullable {
+		return nil
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		return &SchemaError{Path: path, Message: fmt.Sprintf("value %v is not one of %v", v, s.Enum)}
+	}
+
+	switch s.Type {
+	case "":
+		return nil
+	case "string":
+		if _, ok := v.(string); !ok {
+			return typeError(path, s.Type, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return typeError(path, s.Type, v)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return typeError(path, s.Type, v)
+		}
+	case "integer":
+		n, ok := v.(float64)
+		if !ok || n != math.Trunc(n) {
+			return typeError(path, s.Type, v)
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return typeError(path, s.Type, v)
+		}
+		for i, item := range items {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return typeError(path, s.Type, v)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return &SchemaError{Path: path, Message: fmt.Sprintf("missing required property %q", name)}
+			}
+		}
+		for name, value := range obj {
+			if ps, ok := s.Properties[name]; ok {
+				if err := ps.validate(path+"."+name, value); err != nil {
+					return err
+				}
+				continue
+			}
+			switch extra := s.AdditionalProperties.(type) {
+			case bool:
+				if !extra {
+					return &SchemaError{Path: path, Message: fmt.Sprintf("unexpected property %q", name)}
+				}
+			case *Schema:
+				if err := extra.validate(path+"."+name, value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func typeError(path string, want string, v any) error {
+	got := "null"
+	switch v.(type) {
+	case string:
+		got = "string"
+	case bool:
+		got = "boolean"
+	case float64:
+		got = "number"
+	case []any:
+		got = "array"
+	case map[string]any:
+		got = "object"
+	}
+	return &SchemaError{Path: path, Message: fmt.Sprintf("expected %s, got %s", want, got)}
+}
+
+func inEnum(enum []any, v any) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package gpt
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSchemaForTypedEnumsAndNullablePointers(t *testing.T) {
+	type ticket struct {
+		Label    string   `json:"label" enum:"spam,billing"`
+		Priority int      `json:"priority" enum:"1,2,3"`
+		Score    float64  `json:"score" enum:"0.5,1"`
+		Owner    *string  `json:"owner"`
+		Severity *int     `json:"severity" enum:"1,2"`
+		Tags     []string `json:"tags,omitempty"`
+	}
+	s, err := SchemaFor(&ticket{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(s.Properties)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"label":{"type":"string","enum":["spam","billing"]},` +
+		`"owner":{"type":["string","null"]},` +
+		`"priority":{"type":"integer","enum":[1,2,3]},` +
+		`"score":{"type":"number","enum":[0.5,1]},` +
+		`"severity":{"type":["integer","null"],"enum":[1,2,null]},` +
+		`"tags":{"type":"array","items":{"type":"string"}}}`
+	if string(data) != want {
+		t.Errorf("schema properties\n got %s\nwant %s", data, want)
+	}
+
+	var valid, invalid any
+	json.Unmarshal([]byte(`{"label":"spam","priority":2,"score":0.5,"owner":null,"severity":null}`), &valid)
+	json.Unmarshal([]byte(`{"label":"spam","priority":4,"score":0.5,"owner":null,"severity":1}`), &invalid)
+	if err := s.Validate(valid); err != nil {
+		t.Errorf("Validate(valid) = %v", err)
+	}
+	if err := s.Validate(invalid); err == nil {
+		t.Error("Validate accepted a priority outside the enum")
+	}
+
+	type bad struct {
+		Priority int `json:"priority" enum:"high"`
+	}
+	if _, err := SchemaFor(bad{}); err == nil {
+		t.Error("SchemaFor accepted a non integer enum of an int field")
+	}
+}
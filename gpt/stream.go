@@ -0,0 +1,151 @@
+package gpt
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	sseDataPrefix = "data:"
+	sseDone       = "[DONE]"
+)
+
+// chatStreamChunk is a single server-sent event of a streamed chat completion
+type chatStreamChunk struct {
+	Choices []struct {
+		Index int `json:"index"`
+		Delta struct {
+			Role      string `json:"role"`
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				Index    int    `json:"index"`
+				ID       string `json:"id"`
+				Type     string `json:"type"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage,omitempty"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// ChatStream sends the conversation with streaming enabled and calls onDelta with every
+// content fragment as it arrives. It returns the complete assistant message once the
+// stream ends, including any tool calls assembled from the stream.
+func (o *OpenAI) ChatStream(messages []GPTmessage, opts *ChatOptions, onDelta func(delta string)) (GPTmessage, error) {
+	return o.ChatStreamContext(context.Background(), messages, opts, onDelta)
+}
+
+// ChatStreamContext is ChatStream with a context; cancelling it stops reading the stream
+func (o *OpenAI) ChatStreamContext(ctx context.Context, messages []GPTmessage, opts *ChatOptions, onDelta func(delta string)) (GPTmessage, error) {
+	if len(messages) == 0 {
+		return GPTmessage{}, errors.New("no messages to send")
+	}
+	data := o.chatRequest(messages, opts)
+	data["stream"] = true
+	if !o.azure {
+		// the final chunk then carries the usage of the call
+		data["stream_options"] = map[string]interface{}{"include_usage": true}
+	}
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return GPTmessage{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := o.postStream(ctx, o.chatURL(), jsonData, func(req *http.Request) {
+		o.setHeaders(req)
+		req.Header.Set("Accept", "text/event-stream")
+	})
+	if err != nil {
+		return GPTmessage{}, err
+	}
+	defer resp.Body.Close()
+
+	reply, usage, err := readChatStream(resp.Body, onDelta)
+	if usage != nil {
+		o.recordUsage(ctx, usage.toUsage(requestModel(data)))
+	}
+	return reply, err
+}
+
+// readChatStream consumes server-sent events until [DONE] or EOF and assembles the reply
+func readChatStream(body io.Reader, onDelta func(delta string)) (GPTmessage, *openAIUsage, error) {
+	reply := GPTmessage{Role: ASSISTANTROLE}
+	var usage *openAIUsage
+	var content strings.Builder
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, sseDataPrefix) {
+			continue
+		}
+		payload := strings.TrimSpace(strings.TrimPrefix(line, sseDataPrefix))
+		if payload == sseDone {
+			break
+		}
+
+		var chunk chatStreamChunk
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			return reply, usage, fmt.Errorf("failed to unmarshal stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return reply, usage, classifyAPIError(&APIError{StatusCode: http.StatusOK, Message: chunk.Error.Message})
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		if chunk.Choices[0].FinishReason == "content_filter" && content.Len() == 0 {
+			return reply, usage, contentFilterError()
+		}
+		delta := chunk.Choices[0].Delta
+		if delta.Content != "" {
+			content.WriteString(delta.Content)
+			if onDelta != nil {
+				onDelta(delta.Content)
+			}
+		}
+		// tool calls arrive in fragments keyed by index
+		for _, tc := range delta.ToolCalls {
+			for len(reply.ToolCalls) <= tc.Index {
+				reply.ToolCalls = append(reply.ToolCalls, ToolCall{Type: TOOLFUNCTION})
+			}
+			call := &reply.ToolCalls[tc.Index]
+			if tc.ID != "" {
+				call.ID = tc.ID
+			}
+			if tc.Type != "" {
+				call.Type = tc.Type
+			}
+			call.Function.Name += tc.Function.Name
+			call.Function.Arguments += tc.Function.Arguments
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return reply, usage, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	reply.Content = content.String()
+	if reply.Content == "" && len(reply.ToolCalls) == 0 {
+		return reply, usage, errors.New("no reply")
+	}
+	return reply, usage, nil
+}
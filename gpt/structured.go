@@ -0,0 +1,150 @@
+package gpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+const (
+	RESPONSEJSONSCHEMA = "json_schema"
+	// DefaultStructuredRetries is how many times the model is asked to fix an invalid reply
+	DefaultStructuredRetries = 2
+)
+
+// JSONSchemaFormat is the json_schema variant of ResponseFormat
+type JSONSchemaFormat struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+	Strict      bool    `json:"strict,omitempty"`
+}
+
+// StructuredOptions configures StructuredChat
+type StructuredOptions struct {
+	ChatOptions
+	// Name identifies the schema to the model. Defaults to the Go type name.
+	Name string
+	// Retries is the number of correction rounds after an invalid reply.
+	// Zero means DefaultStructuredRetries, a negative value disables retries.
+	Retries int
+}
+
+var (
+	reSchemaName = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+	reJSONFence  = regexp.MustCompile("(?s)^```[a-zA-Z]*\\s*(.*?)\\s*```$")
+)
+
+// ErrStructuredOutput is wrapped by the error returned when no valid reply was produced
+var ErrStructuredOutput = errors.New("model did not return valid structured output")
+
+// StructuredChat asks the model for a JSON reply matching the schema of out, validates it
+// and decodes it into out, which must be a pointer. Invalid replies are sent back to the
+// model with the validation error until it complies or the retries are used up.
+func StructuredChat(llm LLM, messages []GPTmessage, out any, opts *StructuredOptions) error {
+	return StructuredChatContext(context.Background(), llm, messages, out, opts)
+}
+
+// StructuredChatContext is StructuredChat with a context to cancel the calls or set a deadline
+func StructuredChatContext(ctx context.Context, llm LLM, messages []GPTmessage, out any, opts *StructuredOptions) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("structured output target must be a non-nil pointer")
+	}
+	schema, err := SchemaFor(rv.Type().Elem())
+	if err != nil {
+		return fmt.Errorf("failed to derive schema: %w", err)
+	}
+
+	if opts == nil {
+		opts = &StructuredOptions{}
+	}
+	name := opts.Name
+	if name == "" {
+		name = rv.Type().Elem().Name()
+	}
+	name = reSchemaName.ReplaceAllString(name, "_")
+	if name == "" {
+		name = "response"
+	}
+	retries := opts.Retries
+	if retries == 0 {
+		retries = DefaultStructuredRetries
+	} else if retries < 0 {
+		retries = 0
+	}
+
+	chatOpts := opts.ChatOptions
+	chatOpts.ResponseFormat = &ResponseFormat{
+		Type:       RESPONSEJSONSCHEMA,
+		JSONSchema: &JSONSchemaFormat{Name: name, Schema: schema},
+	}
+
+	schemaJSON, err := json.Marshal(schema)
+	if err != nil {
+		return fmt.Errorf("failed to marshal schema: %w", err)
+	}
+	// the instruction keeps providers without response_format support on track
+	conversation := append([]GPTmessage{{
+		Role:    SYSTEMROLE,
+		Content: "Reply only with a JSON value that matches this JSON Schema:\n" + string(schemaJSON),
+	}}, messages...)
+
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		reply, err := llm.ChatContext(ctx, conversation, &chatOpts)
+		if err != nil {
+			return err
+		}
+
+		lastErr = decodeStructured(reply.Content, schema, out)
+		if lastErr == nil {
+			return nil
+		}
+
+		conversation = append(conversation,
+			GPTmessage{Role: ASSISTANTROLE, Content: reply.Content},
+			GPTmessage{Role: USERROLE, Content: "Your reply is not valid: " + lastErr.Error() +
+				". Reply again with only the corrected JSON."},
+		)
+	}
+	return fmt.Errorf("%w: %v", ErrStructuredOutput, lastErr)
+}
+
+// QueryJSON is the structured counterpart of GptQuery
+func QueryJSON(llm LLM, systemPrompt string, message string, out any) error {
+	return QueryJSONContext(context.Background(), llm, systemPrompt, message, out)
+}
+
+// QueryJSONContext is QueryJSON with a context to cancel the calls or set a deadline
+func QueryJSONContext(ctx context.Context, llm LLM, systemPrompt string, message string, out any) error {
+	messages := []GPTmessage{
+		{Role: SYSTEMROLE, Content: systemPrompt},
+		{Role: USERROLE, Content: message},
+	}
+	return StructuredChatContext(ctx, llm, messages, out, nil)
+}
+
+// decodeStructured validates the reply against the schema and unmarshals it into out
+func decodeStructured(reply string, schema *Schema, out any) error {
+	reply = strings.TrimSpace(reply)
+	if m := reJSONFence.FindStringSubmatch(reply); m != nil {
+		reply = m[1]
+	}
+
+	var generic any
+	if err := json.Unmarshal([]byte(reply), &generic); err != nil {
+		return fmt.Errorf("reply is not JSON: %v", err)
+	}
+	if err := schema.Validate(generic); err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(reply), out); err != nil {
+		return fmt.Errorf("reply does not fit the target type: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package gpt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sugarme/tokenizer"
+	"github.com/sugarme/tokenizer/pretrained"
+)
+
+const (
+	ENCODINGCL100K = "cl100k_base"
+	ENCODINGO200K  = "o200k_base"
+	// DefaultContextWindow is assumed for models missing from ModelInfos
+	DefaultContextWindow = 8192
+	// messageTokenOverhead is the per message framing of the chat format (role, separators)
+	messageTokenOverhead = 4
+	// replyTokenOverhead primes the assistant reply
+	replyTokenOverhead = 3
+	// maxTokenizerFileSize bounds a downloaded tokenizer.json
+	maxTokenizerFileSize = 64 << 20
+)
+
+// TokenizerURLs are the Hugging Face tokenizer.json exports of the OpenAI encodings fetched
+// by DownloadTokenizers
+var TokenizerURLs = map[string]string{
+	ENCODINGCL100K: "https://huggingface.co/Xenova/gpt-4/resolve/main/tokenizer.json",
+	ENCODINGO200K:  "https://huggingface.co/Xenova/gpt-4o/resolve/main/tokenizer.json",
+}
+
+// ModelInfo describes the limits of a model
+type ModelInfo struct {
+	// ContextWindow is the number of tokens shared by the prompt and the reply
+	ContextWindow int
+	// MaxOutput is the maximum number of tokens of a reply
+	MaxOutput int
+	// Encoding is the BPE encoding of OpenAI models, empty for other providers
+	Encoding string
+}
+
+// ModelInfos maps model families to their limits. Models are matched by longest prefix.
+var ModelInfos = map[string]ModelInfo{
+	"gpt-3.5-turbo":          {ContextWindow: 16385, MaxOutput: 4096, Encoding: ENCODINGCL100K},
+	"gpt-4":                  {ContextWindow: 8192, MaxOutput: 8192, Encoding: ENCODINGCL100K},
+	"gpt-4-32k":              {ContextWindow: 32768, MaxOutput: 8192, Encoding: ENCODINGCL100K},
+	"gpt-4-turbo":            {ContextWindow: 128000, MaxOutput: 4096, Encoding: ENCODINGCL100K},
+	"gpt-4o":                 {ContextWindow: 128000, MaxOutput: 16384, Encoding: ENCODINGO200K},
+	"gpt-4.1":                {ContextWindow: 1047576, MaxOutput: 32768, Encoding: ENCODINGO200K},
+	"o1":                     {ContextWindow: 200000, MaxOutput: 100000, Encoding: ENCODINGO200K},
+	"o1-mini":                {ContextWindow: 128000, MaxOutput: 65536, Encoding: ENCODINGO200K},
+	"o3":                     {ContextWindow: 200000, MaxOutput: 100000, Encoding: ENCODINGO200K},
+	"o4-mini":                {ContextWindow: 200000, MaxOutput: 100000, Encoding: ENCODINGO200K},
+	"text-embedding-3":       {ContextWindow: 8191, Encoding: ENCODINGCL100K},
+	"text-embedding-ada-002": {ContextWindow: 8191, Encoding: ENCODINGCL100K},
+	"claude-3":               {ContextWindow: 200000, MaxOutput: 4096},
+	"claude-3-5":             {ContextWindow: 200000, MaxOutput: 8192},
+	"claude-3-7":             {ContextWindow: 200000, MaxOutput: 64000},
+	"claude-sonnet-4":        {ContextWindow: 200000, MaxOutput: 64000},
+	"claude-opus-4":          {ContextWindow: 200000, MaxOutput: 32000},
+	"llama3.1":               {ContextWindow: 131072, MaxOutput: 4096},
+	"nomic-embed-text":       {ContextWindow: 8192},
+}
+
+// ModelInfoFor returns the limits of a model and whether it is known
+func ModelInfoFor(model string) (ModelInfo, bool) {
+	var best string
+	for name := range ModelInfos {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelInfo{ContextWindow: DefaultContextWindow}, false
+	}
+	return ModelInfos[best], true
+}
+
+// Tokenizer counts the tokens of a text
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// TokenizerFunc adapts a function to the Tokenizer interface
+type TokenizerFunc func(text string) int
+
+func (f TokenizerFunc) CountTokens(text string) int {
+	return f(text)
+}
+
+// EstimateTokenizer approximates 4 characters per token, it is used when no BPE
+// tokenizer was loaded for the encoding of a model
+var EstimateTokenizer Tokenizer = TokenizerFunc(func(text string) int {
+	return (len(text) + 3) / 4
+})
+
+// bpeTokenizer counts tokens with a Hugging Face tokenizer.json
+type bpeTokenizer struct {
+	tk *tokenizer.Tokenizer
+}
+
+func (b *bpeTokenizer) CountTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	enc, err := b.tk.EncodeSingle(text, false)
+	if err != nil {
+		return EstimateTokenizer.CountTokens(text)
+	}
+	return enc.Len()
+}
+
+var (
+	tokenizersMu sync.RWMutex
+	// tokenizers maps an encoding to its tokenizer
+	tokenizers = map[string]Tokenizer{}
+)
+
+// LoadTokenizer loads a BPE tokenizer from a Hugging Face tokenizer.json file, e.g. the
+// cl100k_base or o200k_base exports. Nothing is downloaded, the file must be local.
+func LoadTokenizer(path string) (Tokenizer, error) {
+	tk, err := pretrained.FromFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tokenizer %s: %w", path, err)
+	}
+	return &bpeTokenizer{tk: tk}, nil
+}
+
+// RegisterTokenizer sets the tokenizer used for an encoding (see ModelInfo.Encoding)
+func RegisterTokenizer(encoding string, t Tokenizer) {
+	tokenizersMu.Lock()
+	defer tokenizersMu.Unlock()
+	tokenizers[encoding] = t
+}
+
+// LoadTokenizers registers the tokenizers found in dir as <encoding>.json,
+// e.g. cl100k_base.json and o200k_base.json
+func LoadTokenizers(dir string) error {
+	for _, encoding := range []string{ENCODINGCL100K, ENCODINGO200K} {
+		path := filepath.Join(dir, encoding+".json")
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		t, err := LoadTokenizer(path)
+		if err != nil {
+			return err
+		}
+		RegisterTokenizer(encoding, t)
+		log.Printf("Loaded %s tokenizer from %s", encoding, path)
+	}
+	return nil
+}
+
+// DownloadTokenizers saves the TokenizerURLs exports missing from dir as <encoding>.json,
+// to be loaded with LoadTokenizers. Token counting never downloads on its own, call it at
+// startup when the host may reach Hugging Face.
+func DownloadTokenizers(ctx context.Context, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create tokenizer dir: %w", err)
+	}
+	for _, encoding := range []string{ENCODINGCL100K, ENCODINGO200K} {
+		path := filepath.Join(dir, encoding+".json")
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		if err := downloadFile(ctx, TokenizerURLs[encoding], path); err != nil {
+			return fmt.Errorf("failed to download %s tokenizer: %w", encoding, err)
+		}
+		log.Printf("Downloaded %s tokenizer to %s", encoding, path)
+	}
+	return nil
+}
+
+// downloadFile writes url to path through a temporary file, so a failed download never
+// leaves a partial tokenizer behind
+func downloadFile(ctx context.Context, url string, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, io.LimitReader(resp.Body, maxTokenizerFileSize)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// TokenizerFor returns the tokenizer of a model, falling back to EstimateTokenizer
+func TokenizerFor(model string) Tokenizer {
+	info, _ := ModelInfoFor(model)
+	tokenizersMu.RLock()
+	defer tokenizersMu.RUnlock()
+	if t, ok := tokenizers[info.Encoding]; ok && info.Encoding != "" {
+		return t
+	}
+	return EstimateTokenizer
+}
+
+// CountTokens counts the tokens of a text for a model
+func CountTokens(model string, text string) int {
+	return TokenizerFor(model).CountTokens(text)
+}
+
+// CountMessageTokens counts the prompt tokens of a conversation for a model,
+// including the framing the chat format adds to every message
+func CountMessageTokens(model string, messages []GPTmessage) int {
+	return countMessages(TokenizerFor(model), messages)
+}
+
+func countMessages(t Tokenizer, messages []GPTmessage) int {
+	total := replyTokenOverhead
+	for _, m := range messages {
+		total += countMessage(t, m)
+	}
+	return total
+}
+
+func countMessage(t Tokenizer, m GPTmessage) int {
+	n := messageTokenOverhead + t.CountTokens(m.Content)
+	for _, part := range m.Parts {
+		if part.Type == PARTIMAGEURL {
+			n += imageTokens
+		} else {
+			n += t.CountTokens(part.Text)
+		}
+	}
+	for _, tc := range m.ToolCalls {
+		n += t.CountTokens(tc.Function.Name) + t.CountTokens(tc.Function.Arguments)
+	}
+	return n
+}
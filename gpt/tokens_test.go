@@ -0,0 +1,86 @@
+package gpt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testTokenizer is a byte level BPE tokenizer.json merging "ab", then "abc"
+const testTokenizer = `{
+	"version": "1.0",
+	"added_tokens": [],
+	"normalizer": null,
+	"pre_tokenizer": {"type": "ByteLevel", "add_prefix_space": false, "trim_offsets": true, "use_regex": true},
+	"post_processor": null,
+	"decoder": {"type": "ByteLevel", "add_prefix_space": false, "trim_offsets": true, "use_regex": true},
+	"model": {
+		"type": "BPE",
+		"dropout": null,
+		"unk_token": null,
+		"continuing_subword_prefix": null,
+		"end_of_word_suffix": null,
+		"fuse_unk": false,
+		"vocab": {"a": 0, "b": 1, "c": 2, "Ġ": 3, "ab": 4, "abc": 5, "Ġa": 6},
+		"merges": ["a b", "ab c", "Ġ a"]
+	}
+}`
+
+func TestLoadTokenizersCountsWithTheExport(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ENCODINGCL100K+".json"), []byte(testTokenizer), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadTokenizers(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		tokenizersMu.Lock()
+		delete(tokenizers, ENCODINGCL100K)
+		tokenizersMu.Unlock()
+	}()
+	// "abc" is one token, " abc" is " " and "abc" as "ab" ranks before " a"
+	for text, want := range map[string]int{"abc": 1, "abc abc": 3, " a": 1, "": 0} {
+		if got := CountTokens("gpt-4", text); got != want {
+			t.Errorf("CountTokens(%q) = %d, want %d", text, got, want)
+		}
+	}
+	if got := CountTokens("gpt-4o", "abc abc"); got != 2 {
+		t.Errorf("CountTokens = %d, want the estimate of 2 without an o200k tokenizer", got)
+	}
+	if got := CountTokens("claude-3-5-sonnet", "abc abc"); got != 2 {
+		t.Errorf("CountTokens = %d, want the estimate of 2 for a model without encoding", got)
+	}
+}
+
+func TestDownloadTokenizersSkipsExistingFiles(t *testing.T) {
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		w.Write([]byte(testTokenizer))
+	}))
+	defer server.Close()
+	urls := TokenizerURLs
+	TokenizerURLs = map[string]string{ENCODINGCL100K: server.URL + "/cl100k", ENCODINGO200K: server.URL + "/o200k"}
+	defer func() { TokenizerURLs = urls }()
+
+	dir := filepath.Join(t.TempDir(), "tokenizers")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ENCODINGCL100K+".json"), []byte(testTokenizer), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := DownloadTokenizers(context.Background(), dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(requested) != 1 || requested[0] != "/o200k" {
+		t.Errorf("downloaded %v, want only the missing o200k tokenizer", requested)
+	}
+	if _, err := LoadTokenizer(filepath.Join(dir, ENCODINGO200K+".json")); err != nil {
+		t.Errorf("downloaded tokenizer does not load: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package gpt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	TOOLROLE     = "tool"
+	TOOLFUNCTION = "function"
+)
+
+// Tool describes a function the model is allowed to call
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction is the function definition advertised to the model.
+// Parameters holds the JSON Schema of the function arguments.
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// ToolCall is a function call requested by the model in an assistant message
+type ToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// NewFunctionTool builds a function tool from a name, description and JSON Schema
+func NewFunctionTool(name string, description string, parameters json.RawMessage) Tool {
+	return Tool{
+		Type: TOOLFUNCTION,
+		Function: ToolFunction{
+			Name:        name,
+			Description: description,
+			Parameters:  parameters,
+		},
+	}
+}
+
+// ToolResultMessage builds the message that returns a tool result to the model
+func ToolResultMessage(callID string, content string) GPTmessage {
+	return GPTmessage{
+		Role:       TOOLROLE,
+		Content:    content,
+		ToolCallID: callID,
+	}
+}
+
+// ParseArguments decodes the JSON arguments of a tool call
+func (t ToolCall) ParseArguments() (map[string]any, error) {
+	args := map[string]any{}
+	if t.Function.Arguments == "" {
+		return args, nil
+	}
+	if err := json.Unmarshal([]byte(t.Function.Arguments), &args); err != nil {
+		return nil, fmt.Errorf("invalid arguments for tool %q: %w", t.Function.Name, err)
+	}
+	return args, nil
+}
+
+// ChatWithTools sends the conversation with the given tools and returns the assistant message,
+// which either carries a final answer in Content or the tool calls the model wants to run
+func (o *OpenAI) ChatWithTools(messages []GPTmessage, tools []Tool) (GPTmessage, error) {
+	return o.ChatContext(context.Background(), messages, &ChatOptions{Tools: tools})
+}
+
+// ChatWithToolsContext is ChatWithTools with a context to cancel the call or set a deadline
+func (o *OpenAI) ChatWithToolsContext(ctx context.Context, messages []GPTmessage, tools []Tool) (GPTmessage, error) {
+	return o.ChatContext(ctx, messages, &ChatOptions{Tools: tools})
+}
@@ -0,0 +1,185 @@
+package gpt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTimeout bounds a whole API call. Streamed calls are only bounded until the
+	// response headers arrive, the stream itself runs until the context is done.
+	DefaultTimeout = 2 * time.Minute
+)
+
+// RetryPolicy controls the exponential backoff applied to 429 and 5xx replies
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt, zero disables retries
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// DefaultRetryPolicy is used when no policy was set
+var DefaultRetryPolicy = RetryPolicy{MaxRetries: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 30 * time.Second}
+
+// transport holds the HTTP behaviour shared by the providers: timeouts, retries and error decoding
+type transport struct {
+	client  *http.Client
+	timeout time.Duration
+	retry   *RetryPolicy
+	onRetry func(attempt int, delay time.Duration, err error)
+	usage   UsageRecorder
+}
+
+// SetTimeout sets the maximum duration of an API call, or of the wait for the response
+// headers of a streamed call
+func (t *transport) SetTimeout(timeout time.Duration) {
+	t.timeout = timeout
+}
+
+// SetRetryPolicy replaces DefaultRetryPolicy for this client
+func (t *transport) SetRetryPolicy(policy RetryPolicy) {
+	t.retry = &policy
+}
+
+// SetRetryNotify registers a callback run before every retry, e.g. to tell users
+// that the model is overloaded and the request is being retried
+func (t *transport) SetRetryNotify(fn func(attempt int, delay time.Duration, err error)) {
+	t.onRetry = fn
+}
+
+// SetHTTPClient sets the client used for API calls, e.g. to inject a test transport.
+// The client's own Timeout applies instead of SetTimeout.
+func (t *transport) SetHTTPClient(client *http.Client) {
+	t.client = client
+}
+
+func (t *transport) httpClient() *http.Client {
+	if t.client != nil {
+		return t.client
+	}
+	timeout := t.timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return &http.Client{Timeout: timeout}
+}
+
+// streamTransports are the transports of streamed calls by header timeout
+var streamTransports sync.Map
+
+// streamClient returns the client of streamed calls. A client Timeout would cut long
+// streams, so only the dial, TLS handshake and response headers are bounded.
+func (t *transport) streamClient() *http.Client {
+	if t.client != nil {
+		return t.client
+	}
+	timeout := t.timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	v, ok := streamTransports.Load(timeout)
+	if !ok {
+		rt := http.DefaultTransport.(*http.Transport).Clone()
+		rt.ResponseHeaderTimeout = timeout
+		v, _ = streamTransports.LoadOrStore(timeout, rt)
+	}
+	return &http.Client{Transport: v.(*http.Transport)}
+}
+
+func (t *transport) retryPolicy() RetryPolicy {
+	if t.retry == nil {
+		return DefaultRetryPolicy
+	}
+	return *t.retry
+}
+
+// post sends a JSON body, retrying temporary failures. It returns the response only for
+// 2xx status codes; error replies are decoded into the typed errors of this package.
+func (t *transport) post(ctx context.Context, url string, body []byte, setHeaders func(req *http.Request)) (*http.Response, error) {
+	return t.send(ctx, t.httpClient(), url, body, setHeaders)
+}
+
+// postStream is post for streamed replies, whose body is read for longer than the timeout
+func (t *transport) postStream(ctx context.Context, url string, body []byte, setHeaders func(req *http.Request)) (*http.Response, error) {
+	return t.send(ctx, t.streamClient(), url, body, setHeaders)
+}
+
+func (t *transport) send(ctx context.Context, client *http.Client, url string, body []byte, setHeaders func(req *http.Request)) (*http.Response, error) {
+	if err := t.allowUsage(ctx); err != nil {
+		return nil, err
+	}
+	policy := t.retryPolicy()
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create API request: %w", err)
+		}
+		setHeaders(req)
+
+		resp, err := client.Do(req)
+		var delay time.Duration
+		if err != nil {
+			err = fmt.Errorf("failed to make API request: %w", err)
+			var netErr net.Error
+			if ctx.Err() != nil || !errors.As(err, &netErr) || attempt >= policy.MaxRetries {
+				return nil, err
+			}
+		} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			respBody, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			err = newAPIError(resp.StatusCode, resp.Header, respBody)
+			if !IsRetryable(err) || attempt >= policy.MaxRetries {
+				return nil, err
+			}
+			var apiErr *APIError
+			if errors.As(err, &apiErr) {
+				delay = apiErr.RetryAfter
+			}
+			if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+				// a server asking for minutes would hold the caller past any deadline
+				delay = policy.MaxDelay
+			}
+		} else {
+			return resp, nil
+		}
+
+		if delay == 0 {
+			delay = backoff(policy, attempt)
+		}
+		log.Printf("LLM request failed (%v), retrying in %s", err, delay)
+		if t.onRetry != nil {
+			t.onRetry(attempt+1, delay, err)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the exponential delay for an attempt with jitter in [delay/2, delay]
+func backoff(policy RetryPolicy, attempt int) time.Duration {
+	delay := policy.BaseDelay << uint(attempt)
+	if delay <= 0 || (policy.MaxDelay > 0 && delay > policy.MaxDelay) {
+		delay = policy.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
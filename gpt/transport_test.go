@@ -0,0 +1,61 @@
+package gpt
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryAfterIsClampedToMaxDelay(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error":{"message":"slow down","type":"rate_limit_error"}}`))
+			return
+		}
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"hi"}}]}`))
+	}))
+	defer server.Close()
+
+	o := NewOpenAICompatible(server.URL, "key", "gpt-4o")
+	o.SetRetryPolicy(RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond})
+	var delays []time.Duration
+	o.SetRetryNotify(func(attempt int, delay time.Duration, err error) {
+		delays = append(delays, delay)
+	})
+	if _, err := o.Chat([]GPTmessage{{Role: USERROLE, Content: "hello"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(delays) != 1 || delays[0] != 10*time.Millisecond {
+		t.Errorf("retried after %v, want the 10ms max delay", delays)
+	}
+}
+
+func TestStreamOutlivesTheTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, word := range []string{"one ", "two ", "three"} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", word)
+			w.(http.Flusher).Flush()
+			time.Sleep(40 * time.Millisecond)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	o := NewOpenAICompatible(server.URL, "key", "gpt-4o")
+	o.SetTimeout(50 * time.Millisecond)
+	reply, err := o.ChatStreamContext(context.Background(), []GPTmessage{{Role: USERROLE, Content: "count"}}, nil, func(string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Content != "one two three" {
+		t.Errorf("streamed %q, want the whole reply", reply.Content)
+	}
+}
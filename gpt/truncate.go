@@ -0,0 +1,250 @@
+package gpt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	// TRUNCATEOLDEST drops the oldest turns, or keeps the end of a text
+	TRUNCATEOLDEST = "oldest"
+	// TRUNCATEMIDDLE keeps the first and the newest turns, or the start and end of a text
+	TRUNCATEMIDDLE = "middle"
+	// TRUNCATESUMMARIZE replaces the dropped turns with a summary written by the Summarizer
+	TRUNCATESUMMARIZE = "summarize"
+
+	// DefaultReplyReserve is the part of the context window kept free for the reply
+	DefaultReplyReserve = 1024
+	// TruncationMarker replaces the text cut out by TRUNCATEMIDDLE
+	TruncationMarker = "\n[...]\n"
+
+	// summaryTokens is the budget kept for the summary of TRUNCATESUMMARIZE
+	summaryTokens   = 256
+	summarizePrompt = "Summarize the following conversation in a few sentences, keeping names, decisions, open questions and any facts needed to continue it."
+)
+
+// TruncateOptions configures FitMessages and TruncateText
+type TruncateOptions struct {
+	// Model selects the tokenizer and context window
+	Model string
+	// MaxTokens is the prompt budget, it defaults to the context window of the model minus Reserve
+	MaxTokens int
+	// Reserve is kept free for the reply when MaxTokens is not set, defaults to DefaultReplyReserve
+	Reserve int
+	// Strategy is one of TRUNCATEOLDEST (default), TRUNCATEMIDDLE or TRUNCATESUMMARIZE
+	Strategy string
+	// Summarizer writes the summary of TRUNCATESUMMARIZE
+	Summarizer LLM
+	// Tokenizer overrides the tokenizer of the model
+	Tokenizer Tokenizer
+}
+
+func (opts *TruncateOptions) tokenizer() Tokenizer {
+	if opts.Tokenizer != nil {
+		return opts.Tokenizer
+	}
+	return TokenizerFor(opts.Model)
+}
+
+func (opts *TruncateOptions) maxTokens() int {
+	if opts.MaxTokens > 0 {
+		return opts.MaxTokens
+	}
+	info, _ := ModelInfoFor(opts.Model)
+	reserve := opts.Reserve
+	if reserve <= 0 {
+		reserve = DefaultReplyReserve
+		if info.MaxOutput > 0 && info.MaxOutput < reserve {
+			reserve = info.MaxOutput
+		}
+	}
+	return info.ContextWindow - reserve
+}
+
+// FitMessages drops turns until the conversation fits the token budget. System messages
+// and the latest turn are always kept; a latest turn that is too long on its own is cut
+// in the middle. An assistant turn calling tools is kept or dropped with its results.
+func FitMessages(ctx context.Context, messages []GPTmessage, opts *TruncateOptions) ([]GPTmessage, error) {
+	if opts == nil {
+		opts = &TruncateOptions{}
+	}
+	t := opts.tokenizer()
+	limit := opts.maxTokens()
+	if countMessages(t, messages) <= limit {
+		return messages, nil
+	}
+
+	var system, turns []GPTmessage
+	for _, m := range messages {
+		if m.Role == SYSTEMROLE {
+			system = append(system, m)
+		} else {
+			turns = append(turns, m)
+		}
+	}
+	if len(turns) == 0 {
+		return nil, fmt.Errorf("system prompt alone exceeds %d tokens", limit)
+	}
+
+	budget := limit - countMessages(t, system)
+	units := groupTurns(turns)
+	last := units[len(units)-1]
+	if cost := countUnit(t, last); cost > budget {
+		return append(system, truncateUnit(t, last, budget)...), nil
+	}
+	budget -= countUnit(t, last)
+
+	var head []GPTmessage
+	if opts.Strategy == TRUNCATEMIDDLE && len(units) > 1 {
+		if cost := countUnit(t, units[0]); cost <= budget && units[0][0].Role != TOOLROLE {
+			head = units[0]
+			units = units[1:]
+			budget -= cost
+		}
+	}
+
+	summarize := opts.Strategy == TRUNCATESUMMARIZE && opts.Summarizer != nil
+	if summarize {
+		budget -= summaryTokens
+	}
+	start := keepNewest(t, units, budget)
+	out := append([]GPTmessage{}, system...)
+	if summarize && start > 0 {
+		var dropped []GPTmessage
+		for _, unit := range units[:start] {
+			dropped = append(dropped, unit...)
+		}
+		summary, err := summarizeMessages(ctx, opts.Summarizer, dropped)
+		if err != nil {
+			return nil, fmt.Errorf("failed to summarize conversation: %w", err)
+		}
+		out = append(out, GPTmessage{Role: SYSTEMROLE, Content: "Summary of the earlier conversation: " + summary})
+	}
+	out = append(out, head...)
+	for _, unit := range units[start:] {
+		out = append(out, unit...)
+	}
+	return out, nil
+}
+
+// groupTurns splits turns into the units dropped together: an assistant turn calling tools
+// with the results that follow it, or a single message
+func groupTurns(turns []GPTmessage) [][]GPTmessage {
+	var units [][]GPTmessage
+	for i := 0; i < len(turns); {
+		end := i + 1
+		if len(turns[i].ToolCalls) > 0 || turns[i].Role == TOOLROLE {
+			for end < len(turns) && turns[end].Role == TOOLROLE {
+				end++
+			}
+		}
+		units = append(units, turns[i:end])
+		i = end
+	}
+	return units
+}
+
+// countUnit counts the tokens of the messages of a unit
+func countUnit(t Tokenizer, unit []GPTmessage) int {
+	n := 0
+	for _, m := range unit {
+		n += countMessage(t, m)
+	}
+	return n
+}
+
+// truncateUnit cuts the messages of a unit too long for the budget in the middle, sharing
+// the budget left by the tool calls and framing between their texts
+func truncateUnit(t Tokenizer, unit []GPTmessage, budget int) []GPTmessage {
+	fixed := 0
+	for _, m := range unit {
+		fixed += countMessage(t, GPTmessage{ToolCalls: m.ToolCalls})
+	}
+	share := (budget - fixed) / len(unit)
+	out := make([]GPTmessage, len(unit))
+	for i, m := range unit {
+		m.Content = TruncateText(m.Content, share, &TruncateOptions{Strategy: TRUNCATEMIDDLE, Tokenizer: t})
+		out[i] = m
+	}
+	return out
+}
+
+// keepNewest returns the index of the oldest unit kept when filling the budget from the end.
+// The last unit is always kept and was already subtracted from the budget.
+func keepNewest(t Tokenizer, units [][]GPTmessage, budget int) int {
+	start := len(units) - 1
+	for start > 0 {
+		cost := countUnit(t, units[start-1])
+		if cost > budget {
+			break
+		}
+		budget -= cost
+		start--
+	}
+	// tool results whose call was already missing from the conversation are dropped
+	for start < len(units)-1 && units[start][0].Role == TOOLROLE {
+		start++
+	}
+	return start
+}
+
+func summarizeMessages(ctx context.Context, llm LLM, messages []GPTmessage) (string, error) {
+	var transcript strings.Builder
+	for _, m := range messages {
+		if m.Content == "" {
+			continue
+		}
+		transcript.WriteString(m.Role)
+		transcript.WriteString(": ")
+		transcript.WriteString(m.Content)
+		transcript.WriteString("\n")
+	}
+	reply, err := llm.ChatContext(ctx, []GPTmessage{
+		{Role: SYSTEMROLE, Content: summarizePrompt},
+		{Role: USERROLE, Content: transcript.String()},
+	}, &ChatOptions{MaxTokens: summaryTokens})
+	if err != nil {
+		return "", err
+	}
+	return reply.Content, nil
+}
+
+// TruncateText cuts a text to maxTokens. TRUNCATEOLDEST keeps the end of the text,
+// any other strategy keeps its start and end around TruncationMarker.
+func TruncateText(text string, maxTokens int, opts *TruncateOptions) string {
+	if opts == nil {
+		opts = &TruncateOptions{}
+	}
+	t := opts.tokenizer()
+	if maxTokens <= 0 {
+		return ""
+	}
+	if t.CountTokens(text) <= maxTokens {
+		return text
+	}
+
+	runes := []rune(text)
+	cut := func(n int) string {
+		if opts.Strategy == TRUNCATEOLDEST {
+			return string(runes[len(runes)-n:])
+		}
+		headLen := n / 2
+		return string(runes[:headLen]) + TruncationMarker + string(runes[len(runes)-(n-headLen):])
+	}
+
+	// binary search the longest cut that fits
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if t.CountTokens(cut(mid)) <= maxTokens {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	if lo == 0 {
+		return ""
+	}
+	return cut(lo)
+}
@@ -0,0 +1,79 @@
+package gpt
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// assertToolPairs fails unless every tool call is followed by its result and every result
+// follows its call
+func assertToolPairs(t *testing.T, messages []GPTmessage) {
+	t.Helper()
+	pending := map[string]bool{}
+	for _, m := range messages {
+		if m.Role == TOOLROLE {
+			if !pending[m.ToolCallID] {
+				t.Errorf("tool result %s without its call in %+v", m.ToolCallID, messages)
+			}
+			delete(pending, m.ToolCallID)
+			continue
+		}
+		if len(pending) > 0 {
+			t.Errorf("tool calls %v without results in %+v", pending, messages)
+		}
+		for _, call := range m.ToolCalls {
+			pending[call.ID] = true
+		}
+	}
+	if len(pending) > 0 {
+		t.Errorf("tool calls %v without results in %+v", pending, messages)
+	}
+}
+
+func toolCallMessage(id string) GPTmessage {
+	call := ToolCall{ID: id, Type: TOOLFUNCTION}
+	call.Function.Name = "lookup"
+	call.Function.Arguments = "{}"
+	return GPTmessage{Role: ASSISTANTROLE, ToolCalls: []ToolCall{call}}
+}
+
+func TestFitMessagesMiddleDropsToolCallsWithResults(t *testing.T) {
+	messages := []GPTmessage{
+		{Role: SYSTEMROLE, Content: "sys"},
+		toolCallMessage("call_1"),
+		ToolResultMessage("call_1", strings.Repeat("x", 400)),
+		{Role: USERROLE, Content: "question one"},
+		{Role: ASSISTANTROLE, Content: "answer one"},
+		{Role: USERROLE, Content: "question two"},
+	}
+	fitted, err := FitMessages(context.Background(), messages, &TruncateOptions{
+		MaxTokens: 60, Strategy: TRUNCATEMIDDLE, Tokenizer: EstimateTokenizer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertToolPairs(t, fitted)
+	if len(fitted) != 4 || fitted[1].Content != "question one" {
+		t.Errorf("kept %+v, want the system prompt and the last three turns", fitted)
+	}
+}
+
+func TestFitMessagesKeepsTheCallOfALongLastResult(t *testing.T) {
+	messages := []GPTmessage{
+		{Role: USERROLE, Content: "question one"},
+		toolCallMessage("call_1"),
+		ToolResultMessage("call_1", strings.Repeat("x", 400)),
+	}
+	fitted, err := FitMessages(context.Background(), messages, &TruncateOptions{MaxTokens: 40, Tokenizer: EstimateTokenizer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertToolPairs(t, fitted)
+	if len(fitted) != 2 || !strings.Contains(fitted[1].Content, TruncationMarker) {
+		t.Errorf("kept %+v, want the call and its result cut in the middle", fitted)
+	}
+	if n := CountMessageTokens("", fitted); n > 40 {
+		t.Errorf("fitted conversation has %d tokens, want at most 40", n)
+	}
+}
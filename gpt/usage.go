@@ -0,0 +1,129 @@
+package gpt
+
+import (
+	"context"
+	"strings"
+)
+
+// Usage is the token usage of a single API call
+type Usage struct {
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+}
+
+// UsageRecorder receives the usage of every call made by a client, together with the
+// context of the call so recorders can attribute it (e.g. to a Slack user or channel)
+type UsageRecorder interface {
+	RecordUsage(ctx context.Context, usage Usage)
+}
+
+// UsageLimiter is optionally implemented by a UsageRecorder to refuse calls before they
+// are made, e.g. when a spend budget is exhausted. The returned error aborts the call.
+type UsageLimiter interface {
+	AllowUsage(ctx context.Context) error
+}
+
+// ModelPrice is the price in USD per million tokens
+type ModelPrice struct {
+	Prompt     float64
+	Completion float64
+}
+
+// ModelPrices is used to estimate the cost of calls. Models are matched by longest prefix,
+// so dated snapshots share the price of their family. Unknown (e.g. local) models are free.
+var ModelPrices = map[string]ModelPrice{
+	"gpt-3.5-turbo":          {Prompt: 0.50, Completion: 1.50},
+	"gpt-4":                  {Prompt: 30, Completion: 60},
+	"gpt-4-turbo":            {Prompt: 10, Completion: 30},
+	"gpt-4o":                 {Prompt: 2.50, Completion: 10},
+	"gpt-4o-mini":            {Prompt: 0.15, Completion: 0.60},
+	"gpt-4.1":                {Prompt: 2, Completion: 8},
+	"gpt-4.1-mini":           {Prompt: 0.40, Completion: 1.60},
+	"gpt-4.1-nano":           {Prompt: 0.10, Completion: 0.40},
+	"o1":                     {Prompt: 15, Completion: 60},
+	"o1-mini":                {Prompt: 1.10, Completion: 4.40},
+	"o3":                     {Prompt: 2, Completion: 8},
+	"o3-mini":                {Prompt: 1.10, Completion: 4.40},
+	"o4-mini":                {Prompt: 1.10, Completion: 4.40},
+	"text-embedding-3-small": {Prompt: 0.02},
+	"text-embedding-3-large": {Prompt: 0.13},
+	"text-embedding-ada-002": {Prompt: 0.10},
+	"claude-3-haiku":         {Prompt: 0.25, Completion: 1.25},
+	"claude-3-5-haiku":       {Prompt: 0.80, Completion: 4},
+	"claude-3-5-sonnet":      {Prompt: 3, Completion: 15},
+	"claude-3-7-sonnet":      {Prompt: 3, Completion: 15},
+	"claude-sonnet-4":        {Prompt: 3, Completion: 15},
+	"claude-3-opus":          {Prompt: 15, Completion: 75},
+	"claude-opus-4":          {Prompt: 15, Completion: 75},
+}
+
+// PriceFor returns the price of a model and whether it is known
+func PriceFor(model string) (ModelPrice, bool) {
+	var best string
+	for name := range ModelPrices {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelPrice{}, false
+	}
+	return ModelPrices[best], true
+}
+
+// Cost returns the estimated cost of the usage in USD
+func (u Usage) Cost() float64 {
+	price, _ := PriceFor(u.Model)
+	return (float64(u.PromptTokens)*price.Prompt + float64(u.CompletionTokens)*price.Completion) / 1e6
+}
+
+// openAIUsage is the usage block of OpenAI compatible responses
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (u *openAIUsage) toUsage(model string) Usage {
+	return Usage{Model: model, PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens, TotalTokens: u.TotalTokens}
+}
+
+// SetUsageRecorder registers the recorder that receives the usage of every call
+func (t *transport) SetUsageRecorder(recorder UsageRecorder) {
+	t.usage = recorder
+}
+
+// allowUsage asks the recorder, if it is a UsageLimiter, whether a call may be made
+func (t *transport) allowUsage(ctx context.Context) error {
+	if limiter, ok := t.usage.(UsageLimiter); ok {
+		return limiter.AllowUsage(ctx)
+	}
+	return nil
+}
+
+func (t *transport) recordUsage(ctx context.Context, usage Usage) {
+	if t.usage == nil {
+		return
+	}
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	t.usage.RecordUsage(ctx, usage)
+}
+
+// requestModel returns the model of a request body built by chatRequest or embed
+func requestModel(data map[string]interface{}) string {
+	model, _ := data["model"].(string)
+	return model
+}
+
+// usageModel returns the model a call is priced by. Azure requests name a deployment, so
+// the model of the response is used instead.
+func (o *OpenAI) usageModel(responseModel string, data map[string]interface{}) string {
+	if o.azure && responseModel != "" {
+		return responseModel
+	}
+	return requestModel(data)
+}
@@ -0,0 +1,36 @@
+package gpt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type usageLog []Usage
+
+func (l *usageLog) RecordUsage(ctx context.Context, usage Usage) {
+	*l = append(*l, usage)
+}
+
+func TestAzureUsageIsPricedByTheResponseModel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model":"gpt-4o-2024-08-06","choices":[{"message":{"role":"assistant","content":"hi"}}],
+			"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}`))
+	}))
+	defer server.Close()
+
+	var usage usageLog
+	o := NewAzureOpenAI(server.URL, "key", "my-chat-deployment", "", "")
+	o.SetUsageRecorder(&usage)
+	if _, err := o.Chat([]GPTmessage{{Role: USERROLE, Content: "hello"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 1 || usage[0].Model != "gpt-4o-2024-08-06" {
+		t.Fatalf("recorded %+v, want the response model", usage)
+	}
+	if usage[0].Cost() == 0 {
+		t.Error("Azure call recorded at $0")
+	}
+}
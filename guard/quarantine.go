@@ -0,0 +1,48 @@
+package guard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// QuarantineStore keeps quarantined inputs for review
+type QuarantineStore interface {
+	Store(ctx context.Context, result Result, text string) error
+}
+
+// QuarantinedItem is a quarantined input as written by DirQuarantine
+type QuarantinedItem struct {
+	Time   time.Time `json:"time"`
+	Result Result    `json:"result"`
+	Text   string    `json:"text"`
+}
+
+// DirQuarantine writes every quarantined input as a JSON file in a directory
+type DirQuarantine struct {
+	dir string
+}
+
+// NewDirQuarantine creates a quarantine in dir, creating it if needed
+func NewDirQuarantine(dir string) (*DirQuarantine, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create quarantine dir: %w", err)
+	}
+	return &DirQuarantine{dir: dir}, nil
+}
+
+func (d *DirQuarantine) Store(_ context.Context, result Result, text string) error {
+	item := QuarantinedItem{Time: time.Now(), Result: result, Text: text}
+	data, err := json.MarshalIndent(item, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal quarantined input: %w", err)
+	}
+	name := fmt.Sprintf("%s-%s-%s.json", item.Time.Format("20060102T150405"), result.Source, newID())
+	if err := os.WriteFile(filepath.Join(d.dir, name), data, 0600); err != nil {
+		return fmt.Errorf("failed to write quarantined input: %w", err)
+	}
+	return nil
+}
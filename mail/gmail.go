@@ -121,7 +121,8 @@ func Connect(pathToSecret string, code string) (*gmail.Service, error) {
 	return srv, nil
 }
 
-// GetEmails retrieves emails from Gmail with the specified parameters and processes them using the provided callback
+// GetEmails retrieves emails from Gmail with the specified parameters and processes them using the provided callback.
+// The callback is called in order on the calling goroutine, hand slow work to a pool such as agent.Dispatcher.
 func GetEmails(srv *gmail.Service, maxId string, filterLabel string, processor func(email Email)) (error, string) {
 	maxReached := false
 	msgid := maxId
@@ -224,7 +225,7 @@ func GetEmails(srv *gmail.Service, maxId string, filterLabel string, processor f
 				email.EmbeddedAddresses = GetEmbeddedAddress(email.Text())
 			}
 			if email.From != nil {
-				go processor(email)
+				processor(email)
 			} else {
 				log.Printf("Error processing address\n")
 			}
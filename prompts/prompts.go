@@ -0,0 +1,372 @@
+package prompts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/vtuson/slackagent/gpt"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// PROMPTPREFIX marks a config value as the name of a prompt, e.g. "prompt:triage"
+	PROMPTPREFIX = "prompt:"
+	// DefaultReloadInterval is how often Watch checks the prompt files for changes
+	DefaultReloadInterval = 5 * time.Second
+	frontMatterDelim      = "---"
+)
+
+// Extensions are the file extensions loaded as prompts, the name of a prompt is its
+// file name without the extension
+var Extensions = []string{".tmpl", ".txt", ".md"}
+
+// ErrNotFound is returned for prompts missing from the library
+var ErrNotFound = errors.New("prompt not found")
+
+// Vars are the variables available to prompt templates, e.g. {{.User}} or
+// {{.Date.Format "Monday 2 January 2006"}}. Values holds processor specific variables,
+// used as {{.Values.ticket}}.
+type Vars struct {
+	User    string
+	Channel string
+	Date    time.Time
+	// Context is retrieved context, e.g. RAG search results or an email body
+	Context string
+	Values  map[string]interface{}
+}
+
+// Prompt is a template loaded from a file. The optional YAML front-matter sets the
+// options of the calls made with it:
+//
+//	---
+//	model: gpt-4o-mini
+//	temperature: 0.2
+//	max_tokens: 500
+//	schema:
+//	  type: object
+//	  properties:
+//	    label: {type: string, enum: [spam, billing, support]}
+//	  required: [label]
+//	---
+//	You triage emails for {{.User}}. Today is {{.Date.Format "2006-01-02"}}.
+type Prompt struct {
+	Name        string
+	Description string
+	Model       string
+	Temperature *float64
+	MaxTokens   int
+	// Schema constrains the reply to JSON, see gpt.StructuredChat
+	Schema *gpt.Schema
+	Path   string
+
+	tmpl *template.Template
+}
+
+// frontMatter is the YAML header of a prompt file
+type frontMatter struct {
+	Description string      `yaml:"description,omitempty"`
+	Model       string      `yaml:"model,omitempty"`
+	Temperature *float64    `yaml:"temperature,omitempty"`
+	MaxTokens   int         `yaml:"max_tokens,omitempty"`
+	Schema      interface{} `yaml:"schema,omitempty"`
+}
+
+// Render executes the template with the variables. A zero Date is set to now.
+func (p *Prompt) Render(vars Vars) (string, error) {
+	if vars.Date.IsZero() {
+		vars.Date = time.Now()
+	}
+	var buf bytes.Buffer
+	if err := p.tmpl.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("failed to render prompt %s: %w", p.Name, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// ChatOptions returns the options of the front-matter. A schema becomes a json_schema
+// response format.
+func (p *Prompt) ChatOptions() *gpt.ChatOptions {
+	opts := &gpt.ChatOptions{
+		Model:       p.Model,
+		Temperature: p.Temperature,
+		MaxTokens:   p.MaxTokens,
+	}
+	if p.Schema != nil {
+		opts.ResponseFormat = &gpt.ResponseFormat{
+			Type:       gpt.RESPONSEJSONSCHEMA,
+			JSONSchema: &gpt.JSONSchemaFormat{Name: p.Name, Schema: p.Schema},
+		}
+	}
+	return opts
+}
+
+// Library holds the prompts of a directory. Templates can include each other by name,
+// e.g. {{template "tone" .}}.
+type Library struct {
+	dir string
+
+	mu        sync.RWMutex
+	prompts   map[string]*Prompt
+	signature string
+}
+
+// NewLibrary loads the prompts of dir
+func NewLibrary(dir string) (*Library, error) {
+	l := &Library{dir: dir, prompts: make(map[string]*Prompt)}
+	if err := l.Load(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Load (re)loads every prompt of the directory. On error the loaded prompts are kept,
+// so a typo in a file being edited doesn't break a running agent.
+func (l *Library) Load() error {
+	files, signature, err := l.files()
+	if err != nil {
+		return err
+	}
+
+	root := template.New("")
+	prompts := make(map[string]*Prompt, len(files))
+	for _, path := range files {
+		prompt, err := loadPrompt(root, path)
+		if err != nil {
+			return err
+		}
+		prompts[prompt.Name] = prompt
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prompts = prompts
+	l.signature = signature
+	return nil
+}
+
+// files lists the prompt files of the directory, with a signature of their names,
+// sizes and modification times to detect changes
+func (l *Library) files() ([]string, string, error) {
+	entries, err := ioutil.ReadDir(l.dir)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read prompts dir: %w", err)
+	}
+	var files []string
+	var signature strings.Builder
+	for _, entry := range entries {
+		if entry.IsDir() || !isPromptFile(entry.Name()) {
+			continue
+		}
+		files = append(files, filepath.Join(l.dir, entry.Name()))
+		fmt.Fprintf(&signature, "%s:%d:%d;", entry.Name(), entry.Size(), entry.ModTime().UnixNano())
+	}
+	return files, signature.String(), nil
+}
+
+func isPromptFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range Extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// loadPrompt parses a prompt file into the template set of root
+func loadPrompt(root *template.Template, path string) (*Prompt, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prompt: %w", err)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	header, body := splitFrontMatter(string(data))
+
+	var meta frontMatter
+	if err := yaml.Unmarshal([]byte(header), &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse front-matter of prompt %s: %w", name, err)
+	}
+	prompt := &Prompt{
+		Name:        name,
+		Description: meta.Description,
+		Model:       meta.Model,
+		Temperature: meta.Temperature,
+		MaxTokens:   meta.MaxTokens,
+		Path:        path,
+	}
+	if meta.Schema != nil {
+		prompt.Schema, err = toSchema(meta.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema in prompt %s: %w", name, err)
+		}
+	}
+
+	prompt.tmpl, err = root.New(name).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse prompt %s: %w", name, err)
+	}
+	return prompt, nil
+}
+
+// splitFrontMatter separates the YAML header delimited by --- lines from the template
+func splitFrontMatter(data string) (string, string) {
+	data = strings.ReplaceAll(strings.TrimPrefix(data, "\ufeff"), "\r\n", "\n")
+	if !strings.HasPrefix(data, frontMatterDelim+"\n") {
+		return "", data
+	}
+	rest := data[len(frontMatterDelim)+1:]
+	if strings.HasPrefix(rest, frontMatterDelim+"\n") {
+		return "", rest[len(frontMatterDelim)+1:]
+	}
+	end := strings.Index(rest, "\n"+frontMatterDelim+"\n")
+	if end < 0 {
+		if strings.HasSuffix(rest, "\n"+frontMatterDelim) {
+			return strings.TrimSuffix(rest, "\n"+frontMatterDelim), ""
+		}
+		// no closing delimiter, the whole file is the template
+		return "", data
+	}
+	return rest[:end], rest[end+len(frontMatterDelim)+2:]
+}
+
+// toSchema converts a YAML schema into a gpt.Schema through JSON, as yaml.v2 decodes
+// maps with interface{} keys
+func toSchema(v interface{}) (*gpt.Schema, error) {
+	data, err := json.Marshal(jsonValue(v))
+	if err != nil {
+		return nil, err
+	}
+	var schema gpt.Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+func jsonValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			m[fmt.Sprint(k)] = jsonValue(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range value {
+			value[i] = jsonValue(item)
+		}
+	}
+	return v
+}
+
+// Get returns the named prompt
+func (l *Library) Get(name string) (*Prompt, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	prompt, ok := l.prompts[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return prompt, nil
+}
+
+// Names returns the names of the loaded prompts, sorted
+func (l *Library) Names() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	names := make([]string, 0, len(l.prompts))
+	for name := range l.prompts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render renders the named prompt
+func (l *Library) Render(name string, vars Vars) (string, error) {
+	prompt, err := l.Get(name)
+	if err != nil {
+		return "", err
+	}
+	return prompt.Render(vars)
+}
+
+// Resolve renders a prompt referenced from a config value such as agent_config: values
+// starting with PROMPTPREFIX name a prompt of the library, other values are returned as
+// they are with nil options. A nil library only resolves literal values.
+func (l *Library) Resolve(value string, vars Vars) (string, *gpt.ChatOptions, error) {
+	name, ok := PromptName(value)
+	if !ok {
+		return value, nil, nil
+	}
+	if l == nil {
+		return "", nil, fmt.Errorf("%w: %s (no prompts dir configured)", ErrNotFound, name)
+	}
+	prompt, err := l.Get(name)
+	if err != nil {
+		return "", nil, err
+	}
+	text, err := prompt.Render(vars)
+	if err != nil {
+		return "", nil, err
+	}
+	return text, prompt.ChatOptions(), nil
+}
+
+// PromptName returns the prompt name of a "prompt:<name>" config value
+func PromptName(value string) (string, bool) {
+	if !strings.HasPrefix(value, PROMPTPREFIX) {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(value, PROMPTPREFIX)), true
+}
+
+// Watch reloads the prompts when a file of the directory changes, until ctx is cancelled.
+// A non positive interval selects DefaultReloadInterval.
+func (l *Library) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		_, signature, err := l.files()
+		if err != nil {
+			log.Printf("Failed to check prompts: %v", err)
+			continue
+		}
+		l.mu.RLock()
+		changed := signature != l.signature
+		l.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := l.Load(); err != nil {
+			// keep the previous prompts until the file is fixed
+			log.Printf("Failed to reload prompts: %v", err)
+			l.mu.Lock()
+			l.signature = signature
+			l.mu.Unlock()
+			continue
+		}
+		log.Printf("Reloaded %d prompts from %s", len(l.Names()), l.dir)
+	}
+}
@@ -0,0 +1,170 @@
+package prompts
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vtuson/slackagent/gpt"
+	"gopkg.in/yaml.v2"
+)
+
+func writePrompt(t *testing.T, dir string, name string, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitFrontMatter(t *testing.T) {
+	for _, tc := range []struct {
+		name, data, header, body string
+	}{
+		{"no header", "Hello {{.User}}", "", "Hello {{.User}}"},
+		{"header", "---\nmodel: gpt-4o\n---\nHello", "model: gpt-4o", "Hello"},
+		{"bom and crlf", "\ufeff---\r\nmodel: gpt-4o\r\n---\r\nHello\r\n", "model: gpt-4o", "Hello\n"},
+		{"empty header", "---\n---\nHello", "", "Hello"},
+		{"no closing delimiter", "---\nmodel: gpt-4o\nHello", "", "---\nmodel: gpt-4o\nHello"},
+		{"delimiter at eof", "---\nmodel: gpt-4o\n---", "model: gpt-4o", ""},
+	} {
+		header, body := splitFrontMatter(tc.data)
+		if header != tc.header || body != tc.body {
+			t.Errorf("%s: splitFrontMatter = %q, %q, want %q, %q", tc.name, header, body, tc.header, tc.body)
+		}
+	}
+}
+
+func TestToSchemaConvertsNestedYAMLMaps(t *testing.T) {
+	var v interface{}
+	err := yaml.Unmarshal([]byte(`
+type: object
+properties:
+  label: {type: string, enum: [spam, billing]}
+  tags:
+    type: array
+    items: {type: object, properties: {name: {type: string}}}
+required: [label]
+`), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := toSchema(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema.Type != "object" || len(schema.Required) != 1 || schema.Required[0] != "label" {
+		t.Errorf("schema %+v", schema)
+	}
+	if label := schema.Properties["label"]; label == nil || len(label.Enum) != 2 || label.Enum[0] != "spam" {
+		t.Errorf("label schema %+v", label)
+	}
+	tags := schema.Properties["tags"]
+	if tags == nil || tags.Items == nil || tags.Items.Properties["name"] == nil || tags.Items.Properties["name"].Type != "string" {
+		t.Errorf("tags schema %+v", tags)
+	}
+}
+
+func TestLibraryRendersWithOptionsAndIncludes(t *testing.T) {
+	dir := t.TempDir()
+	writePrompt(t, dir, "tone.tmpl", "Be brief.")
+	writePrompt(t, dir, "triage.md", `---
+model: gpt-4o-mini
+temperature: 0.2
+max_tokens: 50
+schema:
+  type: object
+  properties:
+    label: {type: string}
+---
+Triage for {{.User}}. {{template "tone" .}} {{.Values.ticket}}`)
+	writePrompt(t, dir, "notes.json", "not a prompt")
+
+	lib, err := NewLibrary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := lib.Names(); len(names) != 2 || names[0] != "tone" || names[1] != "triage" {
+		t.Errorf("Names = %v, want tone and triage", names)
+	}
+	text, opts, err := lib.Resolve("prompt:triage", Vars{User: "alice", Values: map[string]interface{}{"ticket": "T-1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "Triage for alice. Be brief. T-1" {
+		t.Errorf("rendered %q", text)
+	}
+	if opts.Model != "gpt-4o-mini" || opts.Temperature == nil || *opts.Temperature != 0.2 || opts.MaxTokens != 50 {
+		t.Errorf("options %+v", opts)
+	}
+	if opts.ResponseFormat == nil || opts.ResponseFormat.Type != gpt.RESPONSEJSONSCHEMA || opts.ResponseFormat.JSONSchema.Name != "triage" {
+		t.Errorf("response format %+v", opts.ResponseFormat)
+	}
+
+	if text, opts, err := lib.Resolve("plain text", Vars{}); err != nil || text != "plain text" || opts != nil {
+		t.Errorf("Resolve of a literal = %q, %v, %v", text, opts, err)
+	}
+	if _, _, err := lib.Resolve("prompt:missing", Vars{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve of an unknown prompt = %v, want ErrNotFound", err)
+	}
+}
+
+func TestResolveWithoutLibrary(t *testing.T) {
+	var lib *Library
+	if text, _, err := lib.Resolve("literal", Vars{}); err != nil || text != "literal" {
+		t.Errorf("Resolve of a literal = %q, %v", text, err)
+	}
+	if _, _, err := lib.Resolve("prompt:triage", Vars{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve without library = %v, want ErrNotFound", err)
+	}
+}
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWatchReloadsAndKeepsPromptsOnError(t *testing.T) {
+	dir := t.TempDir()
+	writePrompt(t, dir, "greet.txt", "Hello {{.User}}")
+	lib, err := NewLibrary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go lib.Watch(ctx, 10*time.Millisecond)
+
+	render := func() string {
+		text, _ := lib.Render("greet", Vars{User: "bob"})
+		return text
+	}
+	writePrompt(t, dir, "greet.txt", "Hi there {{.User}}")
+	waitFor(t, "the edited prompt", func() bool { return render() == "Hi there bob" })
+
+	writePrompt(t, dir, "greet.txt", "Broken {{.User")
+	_, signature, err := lib.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the broken edit to be seen", func() bool {
+		lib.mu.RLock()
+		defer lib.mu.RUnlock()
+		return lib.signature == signature
+	})
+	if got := render(); got != "Hi there bob" {
+		t.Errorf("after a broken edit rendered %q, want the previous prompt", got)
+	}
+
+	writePrompt(t, dir, "greet.txt", "Fixed {{.User}}")
+	waitFor(t, "the fixed prompt", func() bool { return render() == "Fixed bob" })
+}
@@ -0,0 +1,208 @@
+package slack
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/slack-go/slack"
+)
+
+// HELPARG is the argument that shows the usage of a command instead of running it
+const HELPARG = "help"
+
+// CommandHandler runs a slash command. The command is acknowledged before the handler
+// runs off the event loop, see Client.SetRunner, so it answers with Reply or
+// ReplyInChannel, which can be called up to 5 times within 30 minutes. A returned error is
+// reported to the user privately.
+type CommandHandler func(cmd *Command) error
+
+// Command is a slash command invocation with helpers to answer it
+type Command struct {
+	slack.SlashCommand
+
+	client *Client
+	ctx    context.Context
+}
+
+// Context returns the context given by the runner, e.g. with the deadline of the command
+func (cmd *Command) Context() context.Context {
+	if cmd.ctx == nil {
+		return context.Background()
+	}
+	return cmd.ctx
+}
+
+// Args returns the text typed after the command, trimmed
+func (cmd *Command) Args() string {
+	return strings.TrimSpace(cmd.Text)
+}
+
+// Reply answers privately to the user who typed the command
+func (cmd *Command) Reply(text string) error {
+	return cmd.respond(slack.ResponseTypeEphemeral, text)
+}
+
+// ReplyInChannel answers in the channel, visible to everyone with the command
+func (cmd *Command) ReplyInChannel(text string) error {
+	return cmd.respond(slack.ResponseTypeInChannel, text)
+}
+
+// ReplyBlocks answers with Block Kit blocks, in the channel when inChannel is set. text is
+// the notification fallback.
+func (cmd *Command) ReplyBlocks(inChannel bool, text string, blocks ...slack.Block) error {
+	responseType := slack.ResponseTypeEphemeral
+	if inChannel {
+		responseType = slack.ResponseTypeInChannel
+	}
+	return cmd.respond(responseType, text, blocks...)
+}
+
+func (cmd *Command) respond(responseType string, text string, blocks ...slack.Block) error {
+	msg := &slack.WebhookMessage{Text: ToMrkdwn(text), ResponseType: responseType}
+	if len(blocks) > 0 {
+		msg.Blocks = &slack.Blocks{BlockSet: blocks}
+	}
+	if err := slack.PostWebhookContext(cmd.Context(), cmd.ResponseURL, msg); err != nil {
+		return fmt.Errorf("error responding to %s: %v", cmd.SlashCommand.Command, err)
+	}
+	return nil
+}
+
+// OpenModal opens a modal with the trigger of the command, within 3 seconds of it
+func (cmd *Command) OpenModal(view slack.ModalViewRequest) (*slack.View, error) {
+	return cmd.client.OpenModal(cmd.TriggerID, view)
+}
+
+// slashCommand is a registered command
+type slashCommand struct {
+	name    string
+	usage   string
+	handler CommandHandler
+}
+
+// CommandRouter runs the handler registered for a slash command. "/cmd help" and
+// commands without a handler are answered with the usage of the commands.
+type CommandRouter struct {
+	// ErrorReply turns a handler error into the message shown to the user, defaults to
+	// the error text
+	ErrorReply func(err error) string
+
+	mu       sync.RWMutex
+	commands map[string]slashCommand
+}
+
+// NewCommandRouter creates a router without commands
+func NewCommandRouter() *CommandRouter {
+	return &CommandRouter{commands: make(map[string]slashCommand)}
+}
+
+// OnCommand registers the handler of the command name, e.g. "/ask". usage describes the
+// arguments and is shown by "/ask help", e.g. "<question> - ask the knowledge base".
+func (r *CommandRouter) OnCommand(name string, usage string, handler CommandHandler) {
+	name = "/" + strings.TrimPrefix(name, "/")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands[name] = slashCommand{name: name, usage: usage, handler: handler}
+}
+
+// Help returns the usage of the registered commands, one per line
+func (r *CommandRouter) Help() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.commands))
+	for name := range r.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = usageLine(r.commands[name])
+	}
+	return strings.Join(lines, "\n")
+}
+
+func usageLine(command slashCommand) string {
+	if command.usage == "" {
+		return "`" + command.name + "`"
+	}
+	return "`" + command.name + "` " + command.usage
+}
+
+// Ack returns the payload acknowledging a command: the usage for help and unknown
+// commands, nil for commands run by Dispatch
+func (r *CommandRouter) Ack(command slack.SlashCommand) interface{} {
+	r.mu.RLock()
+	registered, ok := r.commands[command.Command]
+	r.mu.RUnlock()
+	switch {
+	case !ok:
+		text := fmt.Sprintf("Sorry, I don't know `%s`.", command.Command)
+		if help := r.Help(); help != "" {
+			text += " Available commands:\n" + help
+		}
+		return ephemeral(text)
+	case strings.EqualFold(strings.TrimSpace(command.Text), HELPARG):
+		return ephemeral("Usage: " + usageLine(registered))
+	}
+	return nil
+}
+
+// Dispatch runs the handler of a command acknowledged with a nil payload, reporting its
+// error to the user
+func (r *CommandRouter) Dispatch(client *Client, command slack.SlashCommand) {
+	r.DispatchContext(context.Background(), client, command)
+}
+
+// DispatchContext is Dispatch with the context of the Command
+func (r *CommandRouter) DispatchContext(ctx context.Context, client *Client, command slack.SlashCommand) {
+	r.mu.RLock()
+	registered, ok := r.commands[command.Command]
+	r.mu.RUnlock()
+	if !ok {
+		return
+	}
+	cmd := &Command{SlashCommand: command, client: client, ctx: ctx}
+	err := registered.handler(cmd)
+	if err == nil {
+		return
+	}
+	log.Printf("Slash command %s failed: %v", command.Command, err)
+	text := err.Error()
+	if r.ErrorReply != nil {
+		text = r.ErrorReply(err)
+	}
+	if err := cmd.Reply(text); err != nil {
+		log.Printf("Failed to report %s error: %v", command.Command, err)
+	}
+}
+
+// ephemeral is an acknowledgement payload shown only to the user
+func ephemeral(text string) map[string]string {
+	return map[string]string{"response_type": slack.ResponseTypeEphemeral, "text": text}
+}
+
+// Commands returns the router of the slash commands received by Start
+func (c *Client) Commands() *CommandRouter {
+	return c.commands
+}
+
+// SetCommands replaces the router of the slash commands received by Start
+func (c *Client) SetCommands(router *CommandRouter) {
+	c.commands = router
+}
+
+// handleCommand acknowledges a slash command within Slack's 3 seconds, then runs its handler
+// with the runner, off the event loop
+func (c *Client) handleCommand(command slack.SlashCommand, ack func(payload interface{})) {
+	payload := c.commands.Ack(command)
+	ack(payload)
+	if payload == nil {
+		c.run("command:"+command.ChannelID+":"+command.UserID, func(ctx context.Context) {
+			c.commands.DispatchContext(ctx, c, command)
+		})
+	}
+}
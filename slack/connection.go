@@ -0,0 +1,276 @@
+package slack
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// connection states reported by ConnectionStats
+const (
+	// STATECONNECTING is the state while the first connection is opened or a connection
+	// closed by Slack is reopened
+	STATECONNECTING = "connecting"
+	// STATECONNECTED is the state while events are received
+	STATECONNECTED = "connected"
+	// STATEDEGRADED is the state after a failed connection attempt, until a reconnection
+	// succeeds. Events are not received meanwhile, the Web API may still work.
+	STATEDEGRADED = "degraded"
+)
+
+// ReconnectPolicy controls the exponential backoff between the reconnections of StartContext.
+// Zero values use those of DefaultReconnectPolicy, so the delay is always bounded.
+type ReconnectPolicy struct {
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultReconnectPolicy is used when no policy was set
+var DefaultReconnectPolicy = ReconnectPolicy{BaseDelay: time.Second, MaxDelay: 2 * time.Minute}
+
+// ConnectionStats describes the connection to Slack and counts its changes
+type ConnectionStats struct {
+	State string
+	// Since is when the connection entered State
+	Since time.Time
+	// Connects counts the connections opened, the first one included
+	Connects int
+	// Disconnects counts the connections lost or closed by Slack
+	Disconnects int
+	// Failures counts the failed connection attempts, Attempts those since the last connection
+	Failures int
+	Attempts int
+	// LastError is the error of the last failed attempt
+	LastError     string
+	LastConnected time.Time
+	// Downtime is the time spent degraded, the current period included
+	Downtime time.Duration
+}
+
+// ConnectionStats returns the state of the connection and its counters
+func (c *Client) ConnectionStats() ConnectionStats {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	stats := c.conn
+	if stats.State == STATEDEGRADED {
+		stats.Downtime += time.Since(stats.Since)
+	}
+	return stats
+}
+
+// Connected reports whether events are being received
+func (c *Client) Connected() bool {
+	return c.ConnectionStats().State == STATECONNECTED
+}
+
+// SetReconnectPolicy replaces DefaultReconnectPolicy for this client
+func (c *Client) SetReconnectPolicy(policy ReconnectPolicy) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.reconnect = &policy
+}
+
+// SetConnectionNotify registers a callback run on every change of the connection state or
+// its counters, e.g. to export the counters as metrics or alert when Slack stays degraded
+func (c *Client) SetConnectionNotify(fn func(stats ConnectionStats)) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.onConnection = fn
+}
+
+// StartContext connects to Slack with Socket Mode and passes events to Processor until
+// ctx is cancelled. Processor runs on the event loop, so it should hand slow work over.
+// Connections that fail or are lost are reopened with exponential backoff, so it only
+// returns an error when the client has no app token.
+func (c *Client) StartContext(ctx context.Context, Processor func(event interface{})) error {
+	log.Println("Starting Slack client...")
+	if c.socketClient == nil {
+		return fmt.Errorf("error starting slack client: no app token, use StartHTTP")
+	}
+	c.setState(STATECONNECTING, nil)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case evt := <-c.socketClient.Events:
+				c.handleSocketEvent(evt, Processor)
+			}
+		}
+	}()
+
+	run := c.runSocket
+	if run == nil {
+		run = c.socketClient.RunContext
+	}
+	log.Println("Running socket mode client...")
+	for {
+		err := run(ctx)
+		if ctx.Err() != nil {
+			log.Println("Slack client stopped")
+			return nil
+		}
+		if err == nil {
+			err = fmt.Errorf("socket mode client stopped")
+		}
+		c.setState(STATEDEGRADED, err)
+
+		// Attempts counts the failures since the last connection, so the backoff starts
+		// over once a connection was opened
+		delay := reconnectDelay(c.reconnectPolicy(), c.ConnectionStats().Attempts-1)
+		log.Printf("Error running socket mode client: %v, reconnecting in %v", err, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Println("Slack client stopped")
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// handleSocketEvent tracks the connection events and dispatches the requests from Slack
+func (c *Client) handleSocketEvent(evt socketmode.Event, Processor func(event interface{})) {
+	switch evt.Type {
+	case socketmode.EventTypeConnecting:
+		log.Println("Connecting to Slack with Socket Mode...")
+		// the first attempt of a later connection follows a connection closed by Slack
+		if connecting, ok := evt.Data.(*slack.ConnectingEvent); ok && connecting.Attempt == 1 && connecting.ConnectionCount > 0 {
+			c.setState(STATECONNECTING, nil)
+		}
+	case socketmode.EventTypeConnectionError:
+		log.Printf("Connection failed: %+v", evt.Data)
+		err := fmt.Errorf("connection failed")
+		if failed, ok := evt.Data.(*slack.ConnectionErrorEvent); ok && failed.ErrorObj != nil {
+			err = failed.ErrorObj
+		}
+		c.setState(STATEDEGRADED, err)
+	case socketmode.EventTypeInvalidAuth:
+		log.Println("Slack rejected the app token")
+	case socketmode.EventTypeConnected:
+		log.Println("Connected to Slack with Socket Mode.")
+		c.setState(STATECONNECTED, nil)
+	case socketmode.EventTypeEventsAPI:
+		eventsAPIEvent, ok := evt.Data.(slackevents.EventsAPIEvent)
+		if !ok {
+			log.Printf("Ignored %+v\n", evt)
+			return
+		}
+		log.Printf("I got an event: %v\n", eventsAPIEvent)
+
+		c.socketClient.Ack(*evt.Request)
+		c.processEvent(eventsAPIEvent, Processor)
+	case socketmode.EventTypeInteractive:
+		callback, ok := evt.Data.(slack.InteractionCallback)
+		if !ok {
+			log.Printf("Ignored %+v\n", evt)
+			return
+		}
+		log.Printf("I got an interaction: %s\n", callback.Type)
+
+		request := *evt.Request
+		ack := func(payload interface{}) {
+			c.socketClient.Ack(request, payload)
+		}
+		if callback.Type == slack.InteractionTypeViewSubmission {
+			// the handler returns the acknowledgement, so it runs before it, off the event loop
+			go c.handleInteraction(callback, ack)
+			return
+		}
+		c.handleInteraction(callback, ack)
+	case socketmode.EventTypeSlashCommand:
+		command, ok := evt.Data.(slack.SlashCommand)
+		if !ok {
+			log.Printf("Ignored %+v\n", evt)
+			return
+		}
+		log.Printf("I got a slash command: %s %s\n", command.Command, command.Text)
+
+		request := *evt.Request
+		c.handleCommand(command, func(payload interface{}) {
+			c.socketClient.Ack(request, payload)
+		})
+	}
+}
+
+// setState records a connection state change, err is the cause of STATEDEGRADED
+func (c *Client) setState(state string, err error) {
+	c.connMu.Lock()
+	now := time.Now()
+	previous := c.conn.State
+	if previous != state {
+		if previous == STATEDEGRADED {
+			c.conn.Downtime += now.Sub(c.conn.Since)
+		}
+		c.conn.Since = now
+	}
+	switch state {
+	case STATECONNECTING:
+		if previous == STATECONNECTED {
+			c.conn.Disconnects++
+		}
+	case STATECONNECTED:
+		c.conn.Connects++
+		c.conn.Attempts = 0
+		c.conn.LastConnected = now
+	case STATEDEGRADED:
+		if previous == STATECONNECTED {
+			c.conn.Disconnects++
+		}
+		c.conn.Failures++
+		c.conn.Attempts++
+		if err != nil {
+			c.conn.LastError = err.Error()
+		}
+	}
+	c.conn.State = state
+	stats := c.conn
+	notify := c.onConnection
+	c.connMu.Unlock()
+
+	if previous != state {
+		log.Printf("Slack connection %s -> %s (connects %d, disconnects %d, failures %d)",
+			previous, state, stats.Connects, stats.Disconnects, stats.Failures)
+	}
+	if notify != nil {
+		notify(stats)
+	}
+}
+
+func (c *Client) reconnectPolicy() ReconnectPolicy {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.reconnect == nil {
+		return DefaultReconnectPolicy
+	}
+	return *c.reconnect
+}
+
+// reconnectDelay returns the exponential delay for an attempt with jitter in [delay/2, delay]
+func reconnectDelay(policy ReconnectPolicy, attempt int) time.Duration {
+	base, maxDelay := policy.BaseDelay, policy.MaxDelay
+	if base <= 0 {
+		base = DefaultReconnectPolicy.BaseDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultReconnectPolicy.MaxDelay
+	}
+	if attempt < 0 {
+		attempt = 0
+	}
+	delay := maxDelay
+	// shifting past the cap would overflow into a negative delay
+	if attempt < 63 && base <= maxDelay>>uint(attempt) {
+		delay = base << uint(attempt)
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,152 @@
+package slack
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/socketmode"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		policy  ReconnectPolicy
+		attempt int
+		max     time.Duration
+	}{
+		{"first", ReconnectPolicy{BaseDelay: time.Second, MaxDelay: time.Minute}, 0, time.Second},
+		{"doubles", ReconnectPolicy{BaseDelay: time.Second, MaxDelay: time.Minute}, 3, 8 * time.Second},
+		{"capped", ReconnectPolicy{BaseDelay: time.Second, MaxDelay: time.Minute}, 10, time.Minute},
+		{"past overflow", ReconnectPolicy{BaseDelay: time.Second, MaxDelay: time.Minute}, 40, time.Minute},
+		{"huge attempt", ReconnectPolicy{BaseDelay: time.Second, MaxDelay: time.Minute}, 1000, time.Minute},
+		{"unbounded uses the default cap", ReconnectPolicy{BaseDelay: time.Second}, 40, DefaultReconnectPolicy.MaxDelay},
+		{"zero policy uses the defaults", ReconnectPolicy{}, 0, DefaultReconnectPolicy.BaseDelay},
+		{"base over the cap", ReconnectPolicy{BaseDelay: time.Hour, MaxDelay: time.Minute}, 0, time.Minute},
+	} {
+		for i := 0; i < 20; i++ {
+			if got := reconnectDelay(tc.policy, tc.attempt); got < tc.max/2 || got > tc.max {
+				t.Errorf("%s: reconnectDelay = %v, want in [%v, %v]", tc.name, got, tc.max/2, tc.max)
+				break
+			}
+		}
+	}
+}
+
+// newSocketTestClient returns a client whose Socket Mode connection is run by run
+func newSocketTestClient(run func(ctx context.Context, events chan socketmode.Event) error) *Client {
+	api := slack.New("xoxb-test", slack.OptionAppLevelToken("xapp-test"))
+	c := &Client{api: api, socketClient: socketmode.New(api)}
+	c.runSocket = func(ctx context.Context) error {
+		return run(ctx, c.socketClient.Events)
+	}
+	return c
+}
+
+// stateLog records the states notified by SetConnectionNotify
+type stateLog struct {
+	mu     sync.Mutex
+	states []string
+}
+
+func (l *stateLog) notify(stats ConnectionStats) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n := len(l.states); n == 0 || l.states[n-1] != stats.State || stats.State == STATEDEGRADED {
+		l.states = append(l.states, stats.State)
+	}
+}
+
+func TestStartContextReconnectsAndResetsTheBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	var c *Client
+	c = newSocketTestClient(func(ctx context.Context, events chan socketmode.Event) error {
+		calls++
+		switch calls {
+		case 1, 2:
+			return errors.New("dial failed")
+		case 3:
+			if got := c.ConnectionStats().Attempts; got != 2 {
+				t.Errorf("attempts before connecting = %d, want 2", got)
+			}
+			events <- socketmode.Event{Type: socketmode.EventTypeConnected}
+			for !c.Connected() {
+				time.Sleep(time.Millisecond)
+			}
+			return errors.New("connection lost")
+		}
+		cancel()
+		return ctx.Err()
+	})
+	c.SetReconnectPolicy(ReconnectPolicy{BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond})
+	states := &stateLog{}
+	c.SetConnectionNotify(states.notify)
+
+	done := make(chan error, 1)
+	go func() { done <- c.StartContext(ctx, func(event interface{}) {}) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("StartContext = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("StartContext did not stop")
+	}
+
+	stats := c.ConnectionStats()
+	if stats.Connects != 1 || stats.Disconnects != 1 || stats.Failures != 3 || stats.LastError != "connection lost" {
+		t.Errorf("stats %+v", stats)
+	}
+	// the failure after the connection is the first attempt of a new backoff
+	if stats.Attempts != 1 || stats.State != STATEDEGRADED {
+		t.Errorf("after reconnecting attempts = %d in %s, want 1 degraded", stats.Attempts, stats.State)
+	}
+	want := []string{STATECONNECTING, STATEDEGRADED, STATEDEGRADED, STATECONNECTED, STATEDEGRADED}
+	if len(states.states) != len(want) {
+		t.Fatalf("states %v, want %v", states.states, want)
+	}
+	for i := range want {
+		if states.states[i] != want[i] {
+			t.Fatalf("states %v, want %v", states.states, want)
+		}
+	}
+}
+
+func TestStartContextStopsWhileWaitingToReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newSocketTestClient(func(ctx context.Context, events chan socketmode.Event) error {
+		return errors.New("dial failed")
+	})
+	c.SetReconnectPolicy(ReconnectPolicy{BaseDelay: time.Hour, MaxDelay: time.Hour})
+	c.SetConnectionNotify(func(stats ConnectionStats) {
+		if stats.State == STATEDEGRADED {
+			cancel()
+		}
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- c.StartContext(ctx, func(event interface{}) {}) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("StartContext = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("StartContext kept waiting after the context was cancelled")
+	}
+	if stats := c.ConnectionStats(); stats.Downtime <= 0 || stats.LastError != "dial failed" {
+		t.Errorf("stats %+v, want the degraded downtime and error", stats)
+	}
+}
+
+func TestStartContextNeedsAnAppToken(t *testing.T) {
+	c := &Client{api: slack.New("xoxb-test")}
+	if err := c.StartContext(context.Background(), func(event interface{}) {}); err == nil {
+		t.Error("StartContext without an app token succeeded")
+	}
+}
@@ -0,0 +1,142 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+const (
+	// MaxDownloadSize bounds the files downloaded by DownloadFile
+	MaxDownloadSize = 20 << 20
+	// MaxAudioSize is the upload limit of Whisper compatible transcription APIs
+	MaxAudioSize = 25 << 20
+)
+
+// ErrFileTooLarge is returned for files over MaxDownloadSize
+var ErrFileTooLarge = errors.New("file is too large to download")
+
+// DownloadFile downloads a file shared in Slack, authenticated with the bot token.
+// The bot needs the files:read scope.
+func (c *Client) DownloadFile(ctx context.Context, file slack.File) ([]byte, error) {
+	return c.downloadFile(ctx, file, MaxDownloadSize)
+}
+
+// DownloadAudio downloads a voice clip or recording for transcription. It returns the audio
+// with a file name whose extension tells the transcription API the audio format.
+func (c *Client) DownloadAudio(ctx context.Context, file slack.File) ([]byte, string, error) {
+	if !IsAudio(file) {
+		return nil, "", fmt.Errorf("file %s is not audio (%s)", file.Name, file.Mimetype)
+	}
+	data, err := c.downloadFile(ctx, file, MaxAudioSize)
+	if err != nil {
+		return nil, "", err
+	}
+
+	name := file.Name
+	if name == "" {
+		name = file.ID
+	}
+	if ext := audioExtension(file); ext != "" && !strings.HasSuffix(strings.ToLower(name), "."+ext) {
+		name += "." + ext
+	}
+	return data, name, nil
+}
+
+func (c *Client) downloadFile(ctx context.Context, file slack.File, limit int) ([]byte, error) {
+	if file.Size > limit {
+		return nil, ErrFileTooLarge
+	}
+	url := file.URLPrivateDownload
+	if url == "" {
+		url = file.URLPrivate
+	}
+
+	var buf bytes.Buffer
+	w := &limitedWriter{w: &buf, remaining: limit}
+	if err := c.api.GetFileContext(ctx, url, w); err != nil {
+		if errors.Is(err, ErrFileTooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error downloading file %s: %v", file.Name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// MessageFiles returns the files of a message, e.g. of an app_mention event which does not
+// carry them. timestamp is the message timestamp, which may be a thread reply.
+func (c *Client) MessageFiles(channel string, timestamp string) ([]slack.File, error) {
+	msgs, _, _, err := c.api.GetConversationRepliesContext(
+		context.Background(),
+		&slack.GetConversationRepliesParameters{
+			ChannelID: channel,
+			Timestamp: timestamp,
+			Latest:    timestamp,
+			Oldest:    timestamp,
+			Inclusive: true,
+			Limit:     1,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range msgs {
+		if msg.Timestamp == timestamp {
+			return msg.Files, nil
+		}
+	}
+	return nil, nil
+}
+
+// IsImage reports whether a file is an image models can read
+func IsImage(file slack.File) bool {
+	switch strings.ToLower(file.Mimetype) {
+	case "image/png", "image/jpeg", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// IsAudio reports whether a file is a voice clip, an audio file or a recording with sound
+func IsAudio(file slack.File) bool {
+	mime := strings.ToLower(file.Mimetype)
+	return strings.HasPrefix(mime, "audio/") || mime == "video/mp4" || mime == "video/webm"
+}
+
+// audioExtension returns the extension of the audio format of a file
+func audioExtension(file slack.File) string {
+	switch strings.ToLower(file.Mimetype) {
+	case "audio/webm", "video/webm":
+		return "webm"
+	case "audio/mp4", "audio/x-m4a", "audio/m4a":
+		return "m4a"
+	case "video/mp4":
+		return "mp4"
+	case "audio/mpeg", "audio/mp3":
+		return "mp3"
+	case "audio/wav", "audio/x-wav", "audio/wave":
+		return "wav"
+	case "audio/ogg":
+		return "ogg"
+	}
+	return strings.ToLower(file.Filetype)
+}
+
+// limitedWriter fails once more than remaining bytes are written
+type limitedWriter struct {
+	w         io.Writer
+	remaining int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > l.remaining {
+		return 0, ErrFileTooLarge
+	}
+	l.remaining -= len(p)
+	return l.w.Write(p)
+}
@@ -0,0 +1,77 @@
+package slack
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+// newFilesServer serves the files by path, checking the bot token
+func newFilesServer(t *testing.T, files map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "Bearer xoxb-test" {
+			t.Errorf("download with Authorization %q", auth)
+		}
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDownloadFile(t *testing.T) {
+	server := newFilesServer(t, map[string]string{"/logo.png": "PNG", "/big.bin": strings.Repeat("x", 64)})
+	c := &Client{api: slack.New("xoxb-test")}
+	ctx := context.Background()
+
+	data, err := c.DownloadFile(ctx, slack.File{Name: "logo.png", URLPrivate: server.URL + "/old", URLPrivateDownload: server.URL + "/logo.png"})
+	if err != nil || string(data) != "PNG" {
+		t.Errorf("DownloadFile = %q, %v", data, err)
+	}
+	if _, err := c.DownloadFile(ctx, slack.File{Name: "missing", URLPrivate: server.URL + "/missing"}); err == nil {
+		t.Error("downloaded a missing file")
+	}
+	// the announced size is checked before the download, the bytes received while downloading
+	if _, err := c.DownloadFile(ctx, slack.File{Name: "huge", Size: MaxDownloadSize + 1, URLPrivate: server.URL + "/none"}); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("announced size over the limit = %v, want ErrFileTooLarge", err)
+	}
+	if _, err := c.downloadFile(ctx, slack.File{Name: "big.bin", URLPrivate: server.URL + "/big.bin"}, 16); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("content over the limit = %v, want ErrFileTooLarge", err)
+	}
+}
+
+func TestDownloadAudio(t *testing.T) {
+	server := newFilesServer(t, map[string]string{"/clip": "OGG"})
+	c := &Client{api: slack.New("xoxb-test")}
+
+	data, name, err := c.DownloadAudio(context.Background(), slack.File{ID: "F1", Mimetype: "audio/webm", URLPrivate: server.URL + "/clip"})
+	if err != nil || string(data) != "OGG" || name != "F1.webm" {
+		t.Errorf("DownloadAudio = %q, %q, %v", data, name, err)
+	}
+	if _, name, _ := c.DownloadAudio(context.Background(), slack.File{Name: "memo.MP3", Mimetype: "audio/mpeg", URLPrivate: server.URL + "/clip"}); name != "memo.MP3" {
+		t.Errorf("name %q, want the extension kept", name)
+	}
+	if _, _, err := c.DownloadAudio(context.Background(), slack.File{Name: "report.pdf", Mimetype: "application/pdf", URLPrivate: server.URL + "/clip"}); err == nil {
+		t.Error("downloaded a pdf as audio")
+	}
+}
+
+func TestIsImage(t *testing.T) {
+	for mime, want := range map[string]bool{
+		"image/png": true, "IMAGE/JPEG": true, "image/gif": true, "image/webp": true,
+		"image/svg+xml": false, "image/tiff": false, "application/pdf": false, "": false,
+	} {
+		if got := IsImage(slack.File{Mimetype: mime}); got != want {
+			t.Errorf("IsImage(%q) = %v", mime, got)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package slack
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+const (
+	// DefaultHTTPAddr is the listen address of StartHTTP when none is given
+	DefaultHTTPAddr = ":3000"
+	// DefaultHTTPPath is the request URL path of the events, interactivity and slash commands
+	DefaultHTTPPath = "/slack/events"
+	// MaxRequestAge bounds the age of a signed request, older ones are rejected as replays
+	MaxRequestAge = 5 * time.Minute
+	// maxBodySize bounds the body of a request from Slack
+	maxBodySize = 1 << 20
+	// eventIDWindow is how long event IDs are remembered to skip retried deliveries
+	eventIDWindow = 15 * time.Minute
+)
+
+// signature headers of Slack requests
+const (
+	HEADERSIGNATURE  = "X-Slack-Signature"
+	HEADERTIMESTAMP  = "X-Slack-Request-Timestamp"
+	signatureVersion = "v0"
+)
+
+var (
+	// ErrInvalidSignature is returned for requests not signed with the signing secret
+	ErrInvalidSignature = errors.New("invalid slack request signature")
+	// ErrStaleRequest is returned for requests whose timestamp is older than MaxRequestAge
+	ErrStaleRequest = errors.New("slack request timestamp too old")
+)
+
+// Sign returns the X-Slack-Signature of a body sent at timestamp (unix seconds), e.g. to
+// build signed fixtures in tests
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s:%d:", signatureVersion, timestamp)
+	mac.Write(body)
+	return signatureVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verifier checks the signature and the timestamp of requests from Slack
+type Verifier struct {
+	Secret string
+	// MaxAge defaults to MaxRequestAge
+	MaxAge time.Duration
+	// Now defaults to time.Now, set it to verify fixtures signed at a fixed time
+	Now func() time.Time
+}
+
+// Verify returns ErrInvalidSignature or ErrStaleRequest when the request was not signed
+// with the secret or was signed too long ago
+func (v Verifier) Verify(header http.Header, body []byte) error {
+	timestamp, err := strconv.ParseInt(header.Get(HEADERTIMESTAMP), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: missing timestamp", ErrInvalidSignature)
+	}
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+	maxAge := v.MaxAge
+	if maxAge <= 0 {
+		maxAge = MaxRequestAge
+	}
+	age := now().Sub(time.Unix(timestamp, 0))
+	if age > maxAge || age < -maxAge {
+		return ErrStaleRequest
+	}
+	expected := Sign(v.Secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(header.Get(HEADERSIGNATURE))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// NewHTTP creates a Slack client receiving events over HTTP, see StartHTTP, with the bot
+// token and the signing secret of the app
+func NewHTTP(botToken string, signingSecret string, channelID string) *Client {
+	c := newClient(slack.New(botToken), channelID)
+	c.signingSecret = signingSecret
+	return c
+}
+
+// HTTPHandler returns the handler of the Events API, interactivity and slash command
+// requests, which can share one request URL. It verifies the signatures, answers the URL
+// verification challenge, acknowledges requests before handling them and passes events to
+// Processor as Start does. Interactions and commands go to the routers of the client.
+func (c *Client) HTTPHandler(Processor func(event interface{})) http.Handler {
+	return &httpReceiver{
+		client:    c,
+		processor: Processor,
+		verifier:  Verifier{Secret: c.signingSecret},
+		eventIDs:  make(map[string]time.Time),
+	}
+}
+
+// StartHTTP serves HTTPHandler on path, DefaultHTTPPath when empty, at addr,
+// DefaultHTTPAddr when empty. Run it behind a TLS terminating proxy. The connection is
+// STATECONNECTED while listening and STATEDEGRADED once the server failed.
+func (c *Client) StartHTTP(addr string, path string, Processor func(event interface{})) error {
+	if addr == "" {
+		addr = DefaultHTTPAddr
+	}
+	if path == "" {
+		path = DefaultHTTPPath
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, c.HTTPHandler(Processor))
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	c.setState(STATECONNECTING, nil)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		c.setState(STATEDEGRADED, err)
+		return fmt.Errorf("error listening for slack requests: %v", err)
+	}
+	c.setState(STATECONNECTED, nil)
+
+	log.Printf("Listening for Slack requests on %s%s", addr, path)
+	if err := server.Serve(listener); err != nil {
+		c.setState(STATEDEGRADED, err)
+		return fmt.Errorf("error running slack http server: %v", err)
+	}
+	return nil
+}
+
+// httpReceiver handles the requests of HTTPHandler
+type httpReceiver struct {
+	client    *Client
+	processor func(event interface{})
+	verifier  Verifier
+
+	mu       sync.Mutex
+	eventIDs map[string]time.Time
+}
+
+func (h *httpReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	if err := h.verifier.Verify(r.Header, body); err != nil {
+		log.Printf("Rejected Slack request from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		h.handleEvent(w, body)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	switch {
+	case form.Get("payload") != "":
+		var callback slack.InteractionCallback
+		if err := json.Unmarshal([]byte(form.Get("payload")), &callback); err != nil {
+			http.Error(w, "invalid interaction payload", http.StatusBadRequest)
+			return
+		}
+		log.Printf("I got an interaction: %s\n", callback.Type)
+		h.client.handleInteraction(callback, func(payload interface{}) { ack(w, payload) })
+	case form.Get("command") != "":
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		command, err := slack.SlashCommandParse(r)
+		if err != nil {
+			http.Error(w, "invalid slash command", http.StatusBadRequest)
+			return
+		}
+		log.Printf("I got a slash command: %s %s\n", command.Command, command.Text)
+		h.client.handleCommand(command, func(payload interface{}) { ack(w, payload) })
+	default:
+		http.Error(w, "unsupported request", http.StatusBadRequest)
+	}
+}
+
+// handleEvent answers the URL verification challenge and passes callback events to the
+// processor, once per event ID since Slack retries deliveries it thinks were lost
+func (h *httpReceiver) handleEvent(w http.ResponseWriter, body []byte) {
+	event, err := slackevents.ParseEvent(json.RawMessage(body), slackevents.OptionNoVerifyToken())
+	if err != nil {
+		log.Printf("Ignored Slack event: %v", err)
+		// unknown inner events are acknowledged so Slack does not retry them
+		ack(w, nil)
+		return
+	}
+
+	switch event.Type {
+	case slackevents.URLVerification:
+		challenge, ok := event.Data.(*slackevents.EventsAPIURLVerificationEvent)
+		if !ok {
+			http.Error(w, "invalid challenge", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, challenge.Challenge)
+	case slackevents.CallbackEvent:
+		var outer struct {
+			EventID string `json:"event_id"`
+		}
+		json.Unmarshal(body, &outer)
+		ack(w, nil)
+		if !h.firstDelivery(outer.EventID) {
+			log.Printf("Skipping retried event %s", outer.EventID)
+			return
+		}
+		log.Printf("I got an event: %v\n", event)
+		h.client.processEvent(event, h.processor)
+	default:
+		log.Printf("Ignored Slack event of type %s", event.Type)
+		ack(w, nil)
+	}
+}
+
+// firstDelivery remembers an event ID and reports whether it was not seen recently
+func (h *httpReceiver) firstDelivery(eventID string) bool {
+	if eventID == "" {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	if seen, ok := h.eventIDs[eventID]; ok && now.Sub(seen) < eventIDWindow {
+		return false
+	}
+	for id, seen := range h.eventIDs {
+		if now.Sub(seen) >= eventIDWindow {
+			delete(h.eventIDs, id)
+		}
+	}
+	h.eventIDs[eventID] = now
+	return true
+}
+
+// ack completes the response with the JSON payload, or an empty body when nil, so Slack
+// gets it while the request is still being handled
+func ack(w http.ResponseWriter, payload interface{}) {
+	var body []byte
+	if payload != nil {
+		var err error
+		body, err = json.Marshal(payload)
+		if err != nil {
+			log.Printf("Failed to marshal acknowledgement: %v", err)
+			body = nil
+		}
+	}
+	if len(body) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
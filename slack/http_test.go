@@ -0,0 +1,167 @@
+package slack
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+const testSecret = "secret"
+
+func newTestClient() *Client {
+	return &Client{
+		api:           slack.New("xoxb-test"),
+		interactions:  NewInteractionRouter(),
+		commands:      NewCommandRouter(),
+		signingSecret: testSecret,
+	}
+}
+
+// signedRequest builds a request signed with secret at timestamp
+func signedRequest(secret string, timestamp time.Time, contentType string, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, DefaultHTTPPath, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(HEADERTIMESTAMP, strconv.FormatInt(timestamp.Unix(), 10))
+	req.Header.Set(HEADERSIGNATURE, Sign(secret, timestamp.Unix(), []byte(body)))
+	return req
+}
+
+func serve(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+const mentionEvent = `{"type":"event_callback","team_id":"T1","api_app_id":"A1","event_id":"Ev1","event_time":1,
+	"event":{"type":"app_mention","user":"U1","text":"hi","ts":"1.0","channel":"C1","event_ts":"1.0"}}`
+
+func TestHTTPHandlerVerifiesSignature(t *testing.T) {
+	handler := newTestClient().HTTPHandler(func(event interface{}) {
+		t.Errorf("unverified event processed: %v", event)
+	})
+	for name, req := range map[string]*http.Request{
+		"bad signature": signedRequest("other", time.Now(), "application/json", mentionEvent),
+		"stale":         signedRequest(testSecret, time.Now().Add(-2*MaxRequestAge), "application/json", mentionEvent),
+	} {
+		if rec := serve(handler, req); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", name, rec.Code)
+		}
+	}
+	unsigned := httptest.NewRequest(http.MethodPost, DefaultHTTPPath, strings.NewReader(mentionEvent))
+	if rec := serve(handler, unsigned); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned: status %d, want 401", rec.Code)
+	}
+}
+
+func TestHTTPHandlerAnswersChallenge(t *testing.T) {
+	handler := newTestClient().HTTPHandler(func(event interface{}) {})
+	body := `{"type":"url_verification","token":"t","challenge":"abc123"}`
+	rec := serve(handler, signedRequest(testSecret, time.Now(), "application/json", body))
+	if rec.Code != http.StatusOK || rec.Body.String() != "abc123" {
+		t.Errorf("challenge answered %d %q, want 200 abc123", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHTTPHandlerSkipsRetriedEvents(t *testing.T) {
+	var events []interface{}
+	handler := newTestClient().HTTPHandler(func(event interface{}) {
+		events = append(events, event)
+	})
+	for i := 0; i < 2; i++ {
+		req := signedRequest(testSecret, time.Now(), "application/json", mentionEvent)
+		req.Header.Set("X-Slack-Retry-Num", strconv.Itoa(i))
+		if rec := serve(handler, req); rec.Code != http.StatusOK {
+			t.Fatalf("delivery %d: status %d", i, rec.Code)
+		}
+	}
+	if len(events) != 1 {
+		t.Fatalf("processed %d events, want 1", len(events))
+	}
+	mention, ok := events[0].(*slackevents.AppMentionEvent)
+	if !ok || mention.Channel != "C1" || mention.Text != "hi" {
+		t.Errorf("processed %#v, want the app mention", events[0])
+	}
+}
+
+func TestHTTPHandlerRunsCommands(t *testing.T) {
+	client := newTestClient()
+	ran := make(chan *Command, 1)
+	client.Commands().OnCommand("/ask", "<question>", func(cmd *Command) error {
+		ran <- cmd
+		return nil
+	})
+	handler := client.HTTPHandler(func(event interface{}) {})
+
+	form := url.Values{"command": {"/ask"}, "text": {"what time is it"}, "channel_id": {"C1"}, "user_id": {"U1"},
+		"response_url": {"https://hooks.slack.com/commands/1"}}
+	rec := serve(handler, signedRequest(testSecret, time.Now(), "application/x-www-form-urlencoded", form.Encode()))
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("command acknowledged %d %q, want an empty 200", rec.Code, rec.Body.String())
+	}
+	select {
+	case cmd := <-ran:
+		if cmd.Args() != "what time is it" {
+			t.Errorf("command args %q", cmd.Args())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("command handler did not run")
+	}
+
+	form.Set("command", "/unknown")
+	rec = serve(handler, signedRequest(testSecret, time.Now(), "application/x-www-form-urlencoded", form.Encode()))
+	if !strings.Contains(rec.Body.String(), "/ask") {
+		t.Errorf("unknown command acknowledged with %q, want the usage", rec.Body.String())
+	}
+}
+
+func TestHTTPHandlerRunsInteractions(t *testing.T) {
+	client := newTestClient()
+	clicked := make(chan string, 1)
+	client.Interactions().OnAction(ACTIONAPPROVE, func(interaction *Interaction) interface{} {
+		clicked <- interaction.Action.Value
+		return nil
+	})
+	client.Interactions().OnViewSubmission("feedback", func(interaction *Interaction) interface{} {
+		return slack.NewErrorsViewSubmissionResponse(map[string]string{"comment": "required"})
+	})
+	handler := client.HTTPHandler(func(event interface{}) {})
+
+	post := func(payload string) *httptest.ResponseRecorder {
+		form := url.Values{"payload": {payload}}
+		return serve(handler, signedRequest(testSecret, time.Now(), "application/x-www-form-urlencoded", form.Encode()))
+	}
+
+	rec := post(`{"type":"block_actions","user":{"id":"U1"},"container":{"channel_id":"C1","message_ts":"1.0"},
+		"actions":[{"action_id":"approve","block_id":"b","type":"button","value":"req-1"}]}`)
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("click acknowledged %d %q, want an empty 200", rec.Code, rec.Body.String())
+	}
+	select {
+	case value := <-clicked:
+		if value != "req-1" {
+			t.Errorf("clicked %q, want req-1", value)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("action handler did not run")
+	}
+
+	rec = post(`{"type":"view_submission","user":{"id":"U1"},"view":{"id":"V1","callback_id":"feedback"}}`)
+	var response struct {
+		ResponseAction string            `json:"response_action"`
+		Errors         map[string]string `json:"errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("submission acknowledged with %q: %v", rec.Body.String(), err)
+	}
+	if response.ResponseAction != "errors" || response.Errors["comment"] != "required" {
+		t.Errorf("submission acknowledged with %+v, want the handler errors", response)
+	}
+}
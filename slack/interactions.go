@@ -0,0 +1,389 @@
+package slack
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/slack-go/slack"
+)
+
+// button styles of NewButton
+const (
+	STYLEPRIMARY = "primary"
+	STYLEDANGER  = "danger"
+)
+
+// action ids of ApprovalButtons
+const (
+	ACTIONAPPROVE = "approve"
+	ACTIONREJECT  = "reject"
+)
+
+// InteractionHandler handles a Block Kit interaction. For view submissions the returned
+// payload is sent with the acknowledgement, e.g. slack.NewErrorsViewSubmissionResponse to
+// show validation errors on the modal; return nil to close it. Other interactions are
+// acknowledged before the handler runs off the event loop, see Client.SetRunner, and the
+// returned payload is ignored.
+type InteractionHandler func(interaction *Interaction) interface{}
+
+// Interaction is a button click or other block action, a modal submission or close, or a
+// shortcut, with helpers to answer it
+type Interaction struct {
+	slack.InteractionCallback
+	// Action is the action being handled for block_actions, a payload may carry several
+	Action *slack.BlockAction
+
+	client *Client
+	ctx    context.Context
+}
+
+// Context returns the context given by the runner, e.g. with the deadline of the interaction
+func (i *Interaction) Context() context.Context {
+	if i.ctx == nil {
+		return context.Background()
+	}
+	return i.ctx
+}
+
+// InteractionRouter routes interactions to the handler registered for their action_id
+// (block actions) or callback_id (modals and shortcuts). Interactions without a handler go
+// to the OnInteraction handler, or are logged and dropped.
+type InteractionRouter struct {
+	mu          sync.RWMutex
+	actions     map[string]InteractionHandler
+	submissions map[string]InteractionHandler
+	closes      map[string]InteractionHandler
+	shortcuts   map[string]InteractionHandler
+	fallback    InteractionHandler
+}
+
+// NewInteractionRouter creates a router without handlers
+func NewInteractionRouter() *InteractionRouter {
+	return &InteractionRouter{
+		actions:     make(map[string]InteractionHandler),
+		submissions: make(map[string]InteractionHandler),
+		closes:      make(map[string]InteractionHandler),
+		shortcuts:   make(map[string]InteractionHandler),
+	}
+}
+
+// OnAction handles the block actions with actionID, e.g. a button click
+func (r *InteractionRouter) OnAction(actionID string, handler InteractionHandler) {
+	r.set(r.actions, actionID, handler)
+}
+
+// OnViewSubmission handles the submissions of the modals with callbackID
+func (r *InteractionRouter) OnViewSubmission(callbackID string, handler InteractionHandler) {
+	r.set(r.submissions, callbackID, handler)
+}
+
+// OnViewClosed handles the modals with callbackID closed by the user. Slack only sends
+// them for modals opened with NotifyOnClose.
+func (r *InteractionRouter) OnViewClosed(callbackID string, handler InteractionHandler) {
+	r.set(r.closes, callbackID, handler)
+}
+
+// OnShortcut handles the global and message shortcuts with callbackID
+func (r *InteractionRouter) OnShortcut(callbackID string, handler InteractionHandler) {
+	r.set(r.shortcuts, callbackID, handler)
+}
+
+// OnInteraction handles the interactions no other handler matches
+func (r *InteractionRouter) OnInteraction(handler InteractionHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = handler
+}
+
+func (r *InteractionRouter) set(handlers map[string]InteractionHandler, id string, handler InteractionHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	handlers[id] = handler
+}
+
+// handler returns the handler of id in handlers, or the fallback
+func (r *InteractionRouter) handler(handlers map[string]InteractionHandler, id string) InteractionHandler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if handler, ok := handlers[id]; ok {
+		return handler
+	}
+	return r.fallback
+}
+
+// Dispatch runs the handlers of an interaction and returns the payload of the acknowledgement
+func (r *InteractionRouter) Dispatch(client *Client, callback slack.InteractionCallback) interface{} {
+	return r.DispatchContext(context.Background(), client, callback)
+}
+
+// DispatchContext is Dispatch with the context of the Interaction
+func (r *InteractionRouter) DispatchContext(ctx context.Context, client *Client, callback slack.InteractionCallback) interface{} {
+	switch callback.Type {
+	case slack.InteractionTypeBlockActions:
+		for _, action := range callback.ActionCallback.BlockActions {
+			handler := r.handler(r.actions, action.ActionID)
+			if handler == nil {
+				log.Printf("No handler for block action %q", action.ActionID)
+				continue
+			}
+			handler(&Interaction{InteractionCallback: callback, Action: action, client: client, ctx: ctx})
+		}
+		return nil
+	case slack.InteractionTypeViewSubmission:
+		return r.run(ctx, r.submissions, callback.View.CallbackID, client, callback)
+	case slack.InteractionTypeViewClosed:
+		return r.run(ctx, r.closes, callback.View.CallbackID, client, callback)
+	case slack.InteractionTypeShortcut, slack.InteractionTypeMessageAction:
+		return r.run(ctx, r.shortcuts, callback.CallbackID, client, callback)
+	}
+	return r.run(ctx, nil, "", client, callback)
+}
+
+func (r *InteractionRouter) run(ctx context.Context, handlers map[string]InteractionHandler, id string, client *Client, callback slack.InteractionCallback) interface{} {
+	handler := r.handler(handlers, id)
+	if handler == nil {
+		log.Printf("No handler for %s interaction %q", callback.Type, id)
+		return nil
+	}
+	return handler(&Interaction{InteractionCallback: callback, client: client, ctx: ctx})
+}
+
+// Interactions returns the router of the interactions received by Start
+func (c *Client) Interactions() *InteractionRouter {
+	return c.interactions
+}
+
+// SetInteractions replaces the router of the interactions received by Start
+func (c *Client) SetInteractions(router *InteractionRouter) {
+	c.interactions = router
+}
+
+// handleInteraction acknowledges an interaction and runs its handlers with the runner, off
+// the event loop. View submissions are acknowledged after the handler, with its payload, so
+// their handler runs on the caller's goroutine.
+func (c *Client) handleInteraction(callback slack.InteractionCallback, ack func(payload interface{})) {
+	if callback.Type == slack.InteractionTypeViewSubmission {
+		ack(c.interactions.Dispatch(c, callback))
+		return
+	}
+	ack(nil)
+	c.run(interactionKey(callback), func(ctx context.Context) {
+		c.interactions.DispatchContext(ctx, c, callback)
+	})
+}
+
+// interactionKey orders the clicks in a thread, and the other interactions of a user
+func interactionKey(callback slack.InteractionCallback) string {
+	if callback.Container.ChannelID != "" && callback.Container.MessageTs != "" {
+		threadTimeStamp := callback.Message.ThreadTimestamp
+		if threadTimeStamp == "" {
+			threadTimeStamp = callback.Container.MessageTs
+		}
+		return ThreadKey(callback.Container.ChannelID, threadTimeStamp)
+	}
+	return "interaction:" + callback.User.ID
+}
+
+// Values returns the values of the inputs of a submitted modal, or of the inputs of the
+// message of a block action, keyed by action_id. Selections are returned as their option
+// values, or user, channel or conversation IDs, joined by commas for multi selects.
+func (i *Interaction) Values() map[string]string {
+	values := make(map[string]string)
+	var states []map[string]map[string]slack.BlockAction
+	if i.View.State != nil {
+		states = append(states, i.View.State.Values)
+	}
+	if i.BlockActionState != nil {
+		states = append(states, i.BlockActionState.Values)
+	}
+	for _, state := range states {
+		for _, block := range state {
+			for actionID, action := range block {
+				values[actionID] = actionValue(action)
+			}
+		}
+	}
+	return values
+}
+
+// actionValue returns the value of an input, whatever its element type
+func actionValue(action slack.BlockAction) string {
+	switch {
+	case action.Value != "":
+		return action.Value
+	case action.SelectedOption.Value != "":
+		return action.SelectedOption.Value
+	case len(action.SelectedOptions) > 0:
+		options := make([]string, len(action.SelectedOptions))
+		for i, option := range action.SelectedOptions {
+			options[i] = option.Value
+		}
+		return strings.Join(options, ",")
+	case action.SelectedUser != "":
+		return action.SelectedUser
+	case len(action.SelectedUsers) > 0:
+		return strings.Join(action.SelectedUsers, ",")
+	case action.SelectedChannel != "":
+		return action.SelectedChannel
+	case len(action.SelectedChannels) > 0:
+		return strings.Join(action.SelectedChannels, ",")
+	case action.SelectedConversation != "":
+		return action.SelectedConversation
+	case len(action.SelectedConversations) > 0:
+		return strings.Join(action.SelectedConversations, ",")
+	case action.SelectedDate != "":
+		return action.SelectedDate
+	case action.SelectedTime != "":
+		return action.SelectedTime
+	}
+	return ""
+}
+
+// Respond posts text through the response URL of the interaction, replacing the message
+// that holds the clicked button when replace is set, e.g. to swap the Approve / Reject
+// buttons for the outcome
+func (i *Interaction) Respond(text string, replace bool) error {
+	return i.RespondBlocks(text, replace)
+}
+
+// RespondBlocks is Respond with Block Kit blocks, text is the notification fallback
+func (i *Interaction) RespondBlocks(text string, replace bool, blocks ...slack.Block) error {
+	if i.ResponseURL == "" {
+		return fmt.Errorf("%s interaction has no response url", i.Type)
+	}
+	msg := &slack.WebhookMessage{Text: ToMrkdwn(text), ReplaceOriginal: replace}
+	if len(blocks) > 0 {
+		msg.Blocks = &slack.Blocks{BlockSet: blocks}
+	}
+	if !replace && i.Container.IsEphemeral {
+		msg.ResponseType = slack.ResponseTypeEphemeral
+	}
+	if err := slack.PostWebhookContext(i.Context(), i.ResponseURL, msg); err != nil {
+		return fmt.Errorf("error responding to interaction: %v", err)
+	}
+	return nil
+}
+
+// OpenModal opens a modal with the trigger of the interaction
+func (i *Interaction) OpenModal(view slack.ModalViewRequest) (*slack.View, error) {
+	return i.client.OpenModal(i.TriggerID, view)
+}
+
+// PushModal pushes a modal on top of the modal of the interaction
+func (i *Interaction) PushModal(view slack.ModalViewRequest) (*slack.View, error) {
+	return i.client.PushModal(i.TriggerID, view)
+}
+
+// UpdateModal replaces the modal of the interaction, failing if it changed in the meantime
+func (i *Interaction) UpdateModal(view slack.ModalViewRequest) (*slack.View, error) {
+	return i.client.UpdateModal(i.View.ID, i.View.Hash, view)
+}
+
+// OpenModal opens a modal for the user of an interaction or slash command, triggerID
+// expires 3 seconds after the user acted
+func (c *Client) OpenModal(triggerID string, view slack.ModalViewRequest) (*slack.View, error) {
+	resp, err := c.api.OpenViewContext(context.Background(), triggerID, view)
+	if err != nil {
+		return nil, fmt.Errorf("error opening modal: %v", err)
+	}
+	return &resp.View, nil
+}
+
+// PushModal pushes a modal on top of the open one, Slack keeps up to three
+func (c *Client) PushModal(triggerID string, view slack.ModalViewRequest) (*slack.View, error) {
+	resp, err := c.api.PushViewContext(context.Background(), triggerID, view)
+	if err != nil {
+		return nil, fmt.Errorf("error pushing modal: %v", err)
+	}
+	return &resp.View, nil
+}
+
+// UpdateModal replaces the content of an open modal. hash, when set, makes the update fail
+// if the modal was updated since it was read.
+func (c *Client) UpdateModal(viewID string, hash string, view slack.ModalViewRequest) (*slack.View, error) {
+	resp, err := c.api.UpdateViewContext(context.Background(), view, "", hash, viewID)
+	if err != nil {
+		return nil, fmt.Errorf("error updating modal: %v", err)
+	}
+	return &resp.View, nil
+}
+
+// PostBlocks posts Block Kit blocks, e.g. buttons, to a channel or, when threadTimeStamp is
+// set, a thread. text is the notification fallback. It returns the message timestamp.
+func (c *Client) PostBlocks(channel string, threadTimeStamp string, text string, blocks ...slack.Block) (string, error) {
+	options := []slack.MsgOption{
+		slack.MsgOptionText(ToMrkdwn(text), false),
+		slack.MsgOptionBlocks(blocks...),
+	}
+	if threadTimeStamp != "" {
+		options = append(options, slack.MsgOptionTS(threadTimeStamp))
+	}
+	_, ts, err := c.api.PostMessage(channel, options...)
+	if err != nil {
+		return "", fmt.Errorf("error sending message: %v", err)
+	}
+	return ts, nil
+}
+
+// NewModal builds a modal with a submit button labelled submit, or without one when empty
+func NewModal(callbackID string, title string, submit string, blocks ...slack.Block) slack.ModalViewRequest {
+	view := slack.ModalViewRequest{
+		Type:       slack.VTModal,
+		CallbackID: callbackID,
+		Title:      plainText(title),
+		Close:      plainText("Cancel"),
+		Blocks:     slack.Blocks{BlockSet: blocks},
+	}
+	if submit != "" {
+		view.Submit = plainText(submit)
+	}
+	return view
+}
+
+// NewSection builds a section block with mrkdwn text
+func NewSection(text string) *slack.SectionBlock {
+	return slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, ToMrkdwn(text), false, false), nil, nil)
+}
+
+// NewButton builds a button sending value with the action, style is empty, STYLEPRIMARY
+// or STYLEDANGER
+func NewButton(actionID string, text string, value string, style string) *slack.ButtonBlockElement {
+	button := slack.NewButtonBlockElement(actionID, value, plainText(text))
+	button.Style = slack.Style(style)
+	return button
+}
+
+// NewButtons builds an actions block with the buttons
+func NewButtons(blockID string, buttons ...*slack.ButtonBlockElement) *slack.ActionBlock {
+	elements := make([]slack.BlockElement, len(buttons))
+	for i, button := range buttons {
+		elements[i] = button
+	}
+	return slack.NewActionBlock(blockID, elements...)
+}
+
+// ApprovalButtons builds Approve / Reject buttons with the ACTIONAPPROVE and ACTIONREJECT
+// action ids, both carrying value, e.g. the id of the request to approve
+func ApprovalButtons(blockID string, value string) *slack.ActionBlock {
+	return NewButtons(blockID,
+		NewButton(ACTIONAPPROVE, "Approve", value, STYLEPRIMARY),
+		NewButton(ACTIONREJECT, "Reject", value, STYLEDANGER),
+	)
+}
+
+// NewTextInput builds a plain text input block whose value is returned by Values under
+// actionID
+func NewTextInput(actionID string, label string, multiline bool, optional bool) *slack.InputBlock {
+	element := slack.NewPlainTextInputBlockElement(nil, actionID)
+	element.Multiline = multiline
+	block := slack.NewInputBlock(actionID, plainText(label), nil, element)
+	block.Optional = optional
+	return block
+}
+
+func plainText(text string) *slack.TextBlockObject {
+	return slack.NewTextBlockObject(slack.PlainTextType, text, false, false)
+}
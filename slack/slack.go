@@ -5,10 +5,9 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"os/signal"
 	"regexp"
 	"strings"
-	"syscall"
+	"sync"
 
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
@@ -20,6 +19,50 @@ type Client struct {
 	socketClient *socketmode.Client
 	channelID    string
 	threadMax    int
+	botUserID    string
+	botID        string
+	userNames    sync.Map
+	channelNames sync.Map
+	interactions *InteractionRouter
+	commands     *CommandRouter
+	// signingSecret verifies the requests received by HTTPHandler
+	signingSecret string
+	// reconnect, conn and onConnection supervise the connection, see StartContext
+	reconnect    *ReconnectPolicy
+	connMu       sync.Mutex
+	conn         ConnectionStats
+	onConnection func(stats ConnectionStats)
+	// runSocket replaces socketClient.RunContext in tests
+	runSocket func(ctx context.Context) error
+	// runner runs handlers after their acknowledgement, see SetRunner
+	runner Runner
+}
+
+// Runner runs the handler of a command or interaction after it was acknowledged, so the
+// event loop is not held while it works. Requests with the same key, e.g. the commands of
+// a user in a channel or the clicks in a thread, should run in order. ctx becomes the
+// Context of the Command or Interaction.
+type Runner func(key string, run func(ctx context.Context))
+
+// SetRunner replaces the default runner, which starts a goroutine per request, e.g. with a
+// bounded worker pool
+func (c *Client) SetRunner(runner Runner) {
+	c.runner = runner
+}
+
+// run hands a handler to the runner
+func (c *Client) run(key string, run func(ctx context.Context)) {
+	if c.runner == nil {
+		go run(context.Background())
+		return
+	}
+	c.runner(key, run)
+}
+
+// ThreadKey is the runner key of the requests of a Slack thread, threadTimeStamp being the
+// timestamp of its first message
+func ThreadKey(channel string, threadTimeStamp string) string {
+	return "thread:" + channel + ":" + threadTimeStamp
 }
 
 func (c *Client) SetThreadMax(threadMax int) {
@@ -33,80 +76,60 @@ func New(botToken string, appToken string, channelID string) *Client {
 	log.Printf("Using app token: %s...", appToken[:10]+"...")
 
 	api := slack.New(botToken, slack.OptionAppLevelToken(appToken))
-
-	// Test the bot token
-	_, err := api.AuthTest()
-	if err != nil {
-		log.Printf("Warning: Bot token test failed: %v", err)
-	} else {
-		log.Println("Bot token test successful")
-	}
+	c := newClient(api, channelID)
 
 	// Initialize socket mode with more options
-	socketClient := socketmode.New(
+	c.socketClient = socketmode.New(
 		api,
 		socketmode.OptionDebug(true),
 		socketmode.OptionLog(log.New(os.Stdout, "socketmode: ", log.Lshortfile|log.LstdFlags)),
 	)
+	return c
+}
+
+// NewWithAPI creates a client on a slack-go client, e.g. one pointed at a test server with
+// slack.OptionAPIURL. It has no Socket Mode connection; use New or NewHTTP to receive events.
+func NewWithAPI(api *slack.Client, channelID string) *Client {
+	return newClient(api, channelID)
+}
+
+// newClient tests the bot token, the response identifies the bot's own messages
+func newClient(api *slack.Client, channelID string) *Client {
+	auth, err := api.AuthTest()
+	var botUserID, botID string
+	if err != nil {
+		log.Printf("Warning: Bot token test failed: %v", err)
+	} else {
+		log.Println("Bot token test successful")
+		botUserID, botID = auth.UserID, auth.BotID
+	}
 
 	return &Client{
 		api:          api,
-		socketClient: socketClient,
 		channelID:    channelID,
 		threadMax:    20,
+		botUserID:    botUserID,
+		botID:        botID,
+		interactions: NewInteractionRouter(),
+		commands:     NewCommandRouter(),
 	}
 }
 
-// Start starts the Slack client and listens for events
+// Start runs StartContext until the process stops
 func (c *Client) Start(Processor func(event interface{})) error {
-	log.Println("Starting Slack client...")
-
-	// Create a channel to handle OS signals
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	// Start the socket mode client
-	go func() {
-		for evt := range c.socketClient.Events {
-			switch evt.Type {
-			case socketmode.EventTypeConnecting:
-				log.Println("Connecting to Slack with Socket Mode...")
-			case socketmode.EventTypeConnectionError:
-				log.Printf("Connection failed: %+v", evt.Data)
-			case socketmode.EventTypeConnected:
-				log.Println("Connected to Slack with Socket Mode.")
-			case socketmode.EventTypeEventsAPI:
-				eventsAPIEvent, ok := evt.Data.(slackevents.EventsAPIEvent)
-				if !ok {
-					log.Printf("Ignored %+v\n", evt)
-					continue
-				}
-				log.Printf("I got an event: %v\n", eventsAPIEvent)
-
-				c.socketClient.Ack(*evt.Request)
-
-				switch eventsAPIEvent.Type {
-				case slackevents.CallbackEvent:
-					innerEvent := eventsAPIEvent.InnerEvent
-					Processor(innerEvent.Data)
-
-				default:
-					c.socketClient.Debugf("unsupported Events API event received")
-				}
-			}
-		}
-	}()
+	return c.StartContext(context.Background(), Processor)
+}
 
-	// Start the socket mode client
-	log.Println("Running socket mode client...")
-	err := c.socketClient.Run()
-	if err != nil {
-		return fmt.Errorf("error running socket mode client: %v", err)
-	}
+// processEvent passes the inner event of callback events to Processor
+func (c *Client) processEvent(eventsAPIEvent slackevents.EventsAPIEvent, Processor func(event interface{})) {
+	switch eventsAPIEvent.Type {
+	case slackevents.CallbackEvent:
+		innerEvent := eventsAPIEvent.InnerEvent
+		Processor(innerEvent.Data)
 
-	// Wait for OS signal
-	<-sigChan
-	return nil
+	default:
+		log.Printf("unsupported Events API event received: %s", eventsAPIEvent.Type)
+	}
 }
 
 // SendMessage sends a message to the configured Slack channel
@@ -170,8 +193,14 @@ func AddText(newText string, to string, bold bool) string {
 }
 
 func (c *Client) GetThreadMessages(channelID string, threadTS string) ([]slack.Message, error) {
+	return c.GetThreadMessagesContext(context.Background(), channelID, threadTS)
+}
+
+// GetThreadMessagesContext fetches up to the thread max messages of a thread, with a
+// context to cancel the call
+func (c *Client) GetThreadMessagesContext(ctx context.Context, channelID string, threadTS string) ([]slack.Message, error) {
 	replies, _, _, err := c.api.GetConversationRepliesContext(
-		context.Background(),
+		ctx,
 		&slack.GetConversationRepliesParameters{
 			ChannelID: channelID,
 			Timestamp: threadTS,
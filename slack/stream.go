@@ -0,0 +1,174 @@
+package slack
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+const (
+	// DefaultStreamInterval is the minimum time between chat.update calls of a stream.
+	// Slack allows roughly one update per second per channel.
+	DefaultStreamInterval = time.Second
+	// StreamPlaceholder is posted while the first tokens are on their way.
+	StreamPlaceholder = "_Thinking..._"
+)
+
+// MessageStream is a Slack message that is progressively updated as text arrives. Append
+// only buffers the text, a goroutine started by the first Append sends it with chat.update
+// at most once per interval until Finish.
+type MessageStream struct {
+	client   *Client
+	channel  string
+	ts       string
+	mu       sync.Mutex
+	interval time.Duration
+	text     strings.Builder
+	started  bool
+	finished bool
+	// updated wakes the flusher on new text, done stops it and stopped is closed once it
+	// has returned
+	updated  chan struct{}
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+	// lastSent is only used by the flusher, then by Finish once it has stopped
+	lastSent string
+}
+
+// UpdateMessage replaces the text of an existing message
+func (c *Client) UpdateMessage(channel string, timestamp string, message string) error {
+	_, _, _, err := c.api.UpdateMessage(
+		channel,
+		timestamp,
+		slack.MsgOptionText(ToMrkdwn(message), false),
+	)
+	if err != nil {
+		return fmt.Errorf("error updating message: %v", err)
+	}
+	return nil
+}
+
+// StartStream posts a placeholder message and returns a stream that updates it.
+// When threadTimeStamp is set the placeholder is posted as a thread reply, like PostInThread.
+func (c *Client) StartStream(channel string, threadTimeStamp string) (*MessageStream, error) {
+	var ts string
+	var err error
+	if threadTimeStamp == "" {
+		ts, err = c.PostInChannel(channel, StreamPlaceholder)
+	} else {
+		ts, err = c.PostInThread(channel, StreamPlaceholder, threadTimeStamp)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &MessageStream{
+		client:   c,
+		channel:  channel,
+		ts:       ts,
+		interval: DefaultStreamInterval,
+		updated:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}, nil
+}
+
+// SetInterval changes the minimum time between message updates
+func (s *MessageStream) SetInterval(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interval = interval
+}
+
+// Timestamp returns the timestamp of the streamed message
+func (s *MessageStream) Timestamp() string {
+	return s.ts
+}
+
+// Text returns the text received so far
+func (s *MessageStream) Text() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.text.String()
+}
+
+// Append adds a fragment of text to the message. It never waits for Slack, so it can be
+// used as the token callback of a streamed LLM call; update errors are logged and the next
+// update or Finish retries.
+func (s *MessageStream) Append(delta string) {
+	s.mu.Lock()
+	s.text.WriteString(delta)
+	if !s.started && !s.finished {
+		s.started = true
+		go s.run()
+	}
+	s.mu.Unlock()
+	select {
+	case s.updated <- struct{}{}:
+	default:
+	}
+}
+
+// Finish stops the updates and writes the final text into the message. An empty text
+// keeps what was streamed.
+func (s *MessageStream) Finish(final string) error {
+	s.mu.Lock()
+	s.finished = true
+	started := s.started
+	if final == "" {
+		final = s.text.String()
+	}
+	s.mu.Unlock()
+	if started {
+		s.stopOnce.Do(func() { close(s.done) })
+		<-s.stopped
+	}
+
+	if final == s.lastSent {
+		return nil
+	}
+	if err := s.client.UpdateMessage(s.channel, s.ts, final); err != nil {
+		return err
+	}
+	s.lastSent = final
+	return nil
+}
+
+// run sends the buffered text an interval after new text arrives, until Finish
+func (s *MessageStream) run() {
+	defer close(s.stopped)
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.updated:
+		}
+		s.mu.Lock()
+		timer := time.NewTimer(s.interval)
+		s.mu.Unlock()
+		select {
+		case <-s.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.flush()
+	}
+}
+
+// flush sends the text received so far if it changed
+func (s *MessageStream) flush() {
+	text := s.Text()
+	if strings.TrimSpace(text) == "" || text == s.lastSent {
+		return
+	}
+	if err := s.client.UpdateMessage(s.channel, s.ts, text); err != nil {
+		log.Printf("Failed to update streamed message: %v", err)
+		return
+	}
+	s.lastSent = text
+}
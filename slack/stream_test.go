@@ -0,0 +1,76 @@
+package slack
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// updateRecorder answers chat.postMessage and records the texts of chat.update, holding
+// each update until release is closed
+type updateRecorder struct {
+	mu      sync.Mutex
+	texts   []string
+	release chan struct{}
+}
+
+func (r *updateRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.URL.Path == "/chat.update" {
+		<-r.release
+		req.ParseForm()
+		r.mu.Lock()
+		r.texts = append(r.texts, req.Form.Get("text"))
+		r.mu.Unlock()
+	}
+	w.Write([]byte(`{"ok":true,"channel":"C1","ts":"1.0"}`))
+}
+
+func (r *updateRecorder) updates() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.texts...)
+}
+
+func TestMessageStreamAppendDoesNotWaitForSlack(t *testing.T) {
+	recorder := &updateRecorder{release: make(chan struct{})}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+	client := &Client{api: slack.New("xoxb-test", slack.OptionAPIURL(server.URL+"/"))}
+
+	stream, err := client.StartStream("C1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.SetInterval(time.Millisecond)
+	stream.Append("Hello")
+	time.Sleep(20 * time.Millisecond) // the flusher is now blocked in chat.update
+
+	appended := make(chan struct{})
+	go func() {
+		stream.Append(" world")
+		close(appended)
+	}()
+	select {
+	case <-appended:
+	case <-time.After(time.Second):
+		t.Fatal("Append waited for chat.update")
+	}
+
+	close(recorder.release)
+	if err := stream.Finish(""); err != nil {
+		t.Fatal(err)
+	}
+	updates := recorder.updates()
+	if len(updates) == 0 || updates[len(updates)-1] != "Hello world" {
+		t.Errorf("updates %q, want the full text last", updates)
+	}
+	stream.Append(" again")
+	if got := recorder.updates(); len(got) != len(updates) {
+		t.Errorf("updated after Finish: %q", got)
+	}
+}
@@ -0,0 +1,114 @@
+package slack
+
+import (
+	"context"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+var (
+	reUserMention = regexp.MustCompile(`<@([UW][A-Z0-9]+)(?:\|[^>]*)?>`)
+	reChannelLink = regexp.MustCompile(`<#[CG][A-Z0-9]+\|([^>]*)>`)
+	reLabeledLink = regexp.MustCompile(`<(https?://[^|>]+)\|([^>]+)>`)
+	reLink        = regexp.MustCompile(`<(https?://[^|>]+)>`)
+	reSpecial     = regexp.MustCompile(`<!(here|channel|everyone)(?:\|[^>]*)?>`)
+)
+
+// BotUserID returns the user ID of the bot, as reported by AuthTest
+func (c *Client) BotUserID() string {
+	return c.botUserID
+}
+
+// IsOwnMessage reports whether the message was posted by this bot
+func (c *Client) IsOwnMessage(msg slack.Message) bool {
+	if c.botUserID != "" && msg.User == c.botUserID {
+		return true
+	}
+	return c.botID != "" && msg.BotID == c.botID
+}
+
+// UserName resolves a user ID to the display name, falling back to the real name and
+// the handle. Names are cached for the life of the client; unknown users keep their ID.
+func (c *Client) UserName(userID string) string {
+	return c.UserNameContext(context.Background(), userID)
+}
+
+// UserNameContext is UserName with a context to cancel the lookup
+func (c *Client) UserNameContext(ctx context.Context, userID string) string {
+	if userID == "" {
+		return ""
+	}
+	if name, ok := c.userNames.Load(userID); ok {
+		return name.(string)
+	}
+
+	user, err := c.api.GetUserInfoContext(ctx, userID)
+	if err != nil {
+		return userID
+	}
+	name := user.Profile.DisplayName
+	if name == "" {
+		name = user.RealName
+	}
+	if name == "" {
+		name = user.Name
+	}
+	if name == "" {
+		name = userID
+	}
+	c.userNames.Store(userID, name)
+	return name
+}
+
+// ChannelName resolves a channel ID to its name, cached like UserName. Direct messages
+// and channels the bot can't read keep their ID. Needs the channels:read scope.
+func (c *Client) ChannelName(channelID string) string {
+	return c.ChannelNameContext(context.Background(), channelID)
+}
+
+// ChannelNameContext is ChannelName with a context to cancel the lookup
+func (c *Client) ChannelNameContext(ctx context.Context, channelID string) string {
+	if channelID == "" {
+		return ""
+	}
+	if name, ok := c.channelNames.Load(channelID); ok {
+		return name.(string)
+	}
+
+	channel, err := c.api.GetConversationInfoContext(ctx, &slack.GetConversationInfoInput{ChannelID: channelID})
+	if err != nil {
+		return channelID
+	}
+	name := channel.Name
+	if name == "" {
+		name = channelID
+	}
+	c.channelNames.Store(channelID, name)
+	return name
+}
+
+// PlainText turns Slack message markup into plain text: mentions of the bot are removed,
+// other mentions become @name, links and channel references are unwrapped and HTML
+// entities are decoded
+func (c *Client) PlainText(text string) string {
+	return c.PlainTextContext(context.Background(), text)
+}
+
+// PlainTextContext is PlainText with a context to cancel the lookups of mentioned users
+func (c *Client) PlainTextContext(ctx context.Context, text string) string {
+	text = reUserMention.ReplaceAllStringFunc(text, func(m string) string {
+		id := reUserMention.FindStringSubmatch(m)[1]
+		if id == c.botUserID {
+			return ""
+		}
+		return "@" + c.UserNameContext(ctx, id)
+	})
+	text = reChannelLink.ReplaceAllString(text, "#$1")
+	text = reLabeledLink.ReplaceAllString(text, "$2 ($1)")
+	text = reLink.ReplaceAllString(text, "$1")
+	text = reSpecial.ReplaceAllString(text, "@$1")
+	return strings.TrimSpace(html.UnescapeString(text))
+}
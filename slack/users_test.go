@@ -0,0 +1,124 @@
+package slack
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+// newUsersServer answers users.info and conversations.info, counting the lookups
+func newUsersServer(t *testing.T, lookups *int32) *httptest.Server {
+	users := map[string]string{
+		"U1": `{"id":"U1","name":"alice.h","real_name":"Alice H","profile":{"display_name":"alice"}}`,
+		"U2": `{"id":"U2","name":"bob","real_name":"Bob Smith","profile":{}}`,
+		"U3": `{"id":"U3","name":"carol","profile":{}}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(lookups, 1)
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/users.info":
+			if user, ok := users[r.Form.Get("user")]; ok {
+				w.Write([]byte(`{"ok":true,"user":` + user + `}`))
+				return
+			}
+			w.Write([]byte(`{"ok":false,"error":"user_not_found"}`))
+		case "/conversations.info":
+			if r.Form.Get("channel") == "C1" {
+				w.Write([]byte(`{"ok":true,"channel":{"id":"C1","name":"general"}}`))
+				return
+			}
+			w.Write([]byte(`{"ok":false,"error":"channel_not_found"}`))
+		default:
+			t.Errorf("unexpected call to %s", r.URL.Path)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestIsOwnMessage(t *testing.T) {
+	c := &Client{botUserID: "UBOT", botID: "BBOT"}
+	for _, tc := range []struct {
+		msg  slack.Message
+		want bool
+	}{
+		{slack.Message{Msg: slack.Msg{User: "UBOT"}}, true},
+		{slack.Message{Msg: slack.Msg{BotID: "BBOT"}}, true},
+		{slack.Message{Msg: slack.Msg{User: "U1"}}, false},
+		{slack.Message{Msg: slack.Msg{BotID: "BOTHER", Username: "webhook"}}, false},
+	} {
+		if got := c.IsOwnMessage(tc.msg); got != tc.want {
+			t.Errorf("IsOwnMessage(user %q, bot %q) = %v", tc.msg.User, tc.msg.BotID, got)
+		}
+	}
+	if (&Client{}).IsOwnMessage(slack.Message{}) {
+		t.Error("a client without identity owns a message without author")
+	}
+}
+
+func TestUserNameFallsBackAndCaches(t *testing.T) {
+	var lookups int32
+	server := newUsersServer(t, &lookups)
+	c := &Client{api: slack.New("xoxb-test", slack.OptionAPIURL(server.URL+"/"))}
+
+	for id, want := range map[string]string{"U1": "alice", "U2": "Bob Smith", "U3": "carol", "U4": "U4", "": ""} {
+		if got := c.UserName(id); got != want {
+			t.Errorf("UserName(%q) = %q, want %q", id, got, want)
+		}
+	}
+	if atomic.LoadInt32(&lookups) != 4 {
+		t.Fatalf("%d lookups, want 4", atomic.LoadInt32(&lookups))
+	}
+	c.UserName("U1")
+	if atomic.LoadInt32(&lookups) != 4 {
+		t.Error("a known user was looked up again")
+	}
+	// unknown users are not cached, they may join later
+	c.UserName("U4")
+	if atomic.LoadInt32(&lookups) != 5 {
+		t.Error("the unknown user was not looked up again")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got := c.UserNameContext(ctx, "U2"); got != "Bob Smith" {
+		t.Errorf("cached name %q with a cancelled context", got)
+	}
+	if got := c.ChannelNameContext(ctx, "C1"); got != "C1" {
+		t.Errorf("ChannelNameContext with a cancelled context = %q, want the id", got)
+	}
+	if got := c.ChannelName("C1"); got != "general" {
+		t.Errorf("ChannelName = %q", got)
+	}
+	if got := c.ChannelName("D1"); got != "D1" {
+		t.Errorf("ChannelName of a direct message = %q", got)
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	var lookups int32
+	server := newUsersServer(t, &lookups)
+	c := &Client{api: slack.New("xoxb-test", slack.OptionAPIURL(server.URL+"/")), botUserID: "UBOT"}
+
+	for text, want := range map[string]string{
+		"<@UBOT> what's new?":                       "what's new?",
+		"ask <@U1|alice.h> and <@W2>":               "ask @alice and @W2",
+		"see <#C1|general> or <#C2|>":               "see #general or #",
+		"read <https://example.com/a|the docs>":     "read the docs (https://example.com/a)",
+		"at <https://example.com/b>":                "at https://example.com/b",
+		"<!here> <!channel|@channel> <!everyone>":   "@here @channel @everyone",
+		"1 &lt; 2 &amp;&amp; 3 &gt; 2":              "1 < 2 && 3 > 2",
+		"  <@UBOT>  ":                               "",
+		"mail <mailto:a@example.com|a@example.com>": "mail <mailto:a@example.com|a@example.com>",
+	} {
+		if got := c.PlainText(text); got != want {
+			t.Errorf("PlainText(%q) = %q, want %q", text, got, want)
+		}
+	}
+}